@@ -1,10 +1,65 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
 	"github.com/cuiweixie/toylang/eval"
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/ssa"
+)
+
+var (
+	optimize = flag.Bool("O", false, "optimize the program before running it")
+	dumpIR   = flag.Bool("dumpir", false, "optimize, printing the IR before and after each pass")
+	dumpSSA  = flag.Bool("dumpssa", false, "print the SSA form of the program before running it")
 )
 
 func main() {
-	testFileName := "D:\\code\\go\\gopath\\src\\github.com\\cuiweixie\\toylang\\cmd\\basic\\test.toy"
-	eval.EvalFile(testFileName)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] file.toy\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	fileName := flag.Arg(0)
+	src, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	in, err := eval.New(fileName, src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *optimize || *dumpIR {
+		pm := ir.NewPassManager()
+		if *dumpIR {
+			pm.Dump = os.Stderr
+		}
+		in.SetPasses(pm)
+	}
+	if *dumpSSA {
+		nodes, err := in.IR()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		prog, err := ssa.Build(nodes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		ssa.Fprint(os.Stderr, prog)
+	}
+	if err := in.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
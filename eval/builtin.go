@@ -0,0 +1,177 @@
+package eval
+
+import "github.com/cuiweixie/toylang/ir"
+
+// builtins are predeclared in the global scope. A builtin reports
+// errors with a nil node; callBuiltIn positions them at the call.
+var builtins = map[string]func(c *EvalCtx, args []*Var){
+	"print":  builtinPrint,
+	"len":    builtinLen,
+	"append": builtinAppend,
+	"push":   builtinPush,
+	"pop":    builtinPop,
+	"delete": builtinDelete,
+	"keys":   builtinKeys,
+	"has":    builtinHas,
+	"range":  builtinRange,
+}
+
+// callBuiltIn calls the builtin fn from site. Its results are left
+// in c.Result.
+func (c *EvalCtx) callBuiltIn(site ir.Node, fn *Var, args []*Var) {
+	defer func() {
+		if r := recover(); r != nil {
+			if re, ok := r.(*RuntimeError); ok && !re.Pos.IsValid() && site != nil {
+				re.Pos = site.Pos()
+			}
+			panic(r)
+		}
+	}()
+	c.Result = nil
+	fn.BuiltIn(c, args)
+}
+
+// wantArgs checks the argument count of the builtin name, which takes
+// n arguments, or at least n when variadic.
+func (c *EvalCtx) wantArgs(name string, args []*Var, n int, variadic bool) {
+	if variadic && len(args) < n {
+		c.errorf(nil, ErrArgCount, "call %s: want at least %d args, got %d", name, n, len(args))
+	}
+	if !variadic && len(args) != n {
+		c.errorf(nil, ErrArgCount, "call %s: want %d args, got %d", name, n, len(args))
+	}
+}
+
+// listArg returns args[i], which must be a list.
+func (c *EvalCtx) listArg(name string, args []*Var, i int) *List {
+	if args[i].Type != LIST {
+		c.errorf(nil, ErrType, "invalid argument: %s(%v)", name, args[i].Type)
+	}
+	return args[i].ListVal
+}
+
+// mapArg returns args[i], which must be a map.
+func (c *EvalCtx) mapArg(name string, args []*Var, i int) *Map {
+	if args[i].Type != MAP {
+		c.errorf(nil, ErrType, "invalid argument: %s(%v)", name, args[i].Type)
+	}
+	return args[i].MapVal
+}
+
+func builtinPrint(c *EvalCtx, args []*Var) {
+	for _, arg := range args {
+		FprintVar(c.out, arg)
+	}
+}
+
+// builtinLen returns the number of elements of a list or map, or
+// of bytes of a string.
+func builtinLen(c *EvalCtx, args []*Var) {
+	c.wantArgs("len", args, 1, false)
+	var n int
+	switch args[0].Type {
+	case LIST:
+		n = len(args[0].ListVal.Elems)
+	case MAP:
+		n = args[0].MapVal.Len()
+	case STRING:
+		n = len(args[0].StringVal)
+	default:
+		c.errorf(nil, ErrType, "invalid argument: len(%v)", args[0].Type)
+	}
+	c.Result = []*Var{{Type: NUM, NumVal: float64(n)}}
+}
+
+// builtinAppend returns a new list holding the elements of the list
+// argument followed by the other arguments.
+func builtinAppend(c *EvalCtx, args []*Var) {
+	c.wantArgs("append", args, 1, true)
+	list := c.listArg("append", args, 0)
+	elems := append([]*Var(nil), list.Elems...)
+	for _, arg := range args[1:] {
+		elem := *arg
+		elems = append(elems, &elem)
+	}
+	c.Result = []*Var{{Type: LIST, ListVal: &List{Elems: elems}}}
+}
+
+// builtinPush adds the other arguments to the end of the list
+// argument in place.
+func builtinPush(c *EvalCtx, args []*Var) {
+	c.wantArgs("push", args, 1, true)
+	list := c.listArg("push", args, 0)
+	for _, arg := range args[1:] {
+		elem := *arg
+		list.Elems = append(list.Elems, &elem)
+	}
+}
+
+// builtinPop removes the last element of the list argument and
+// returns it.
+func builtinPop(c *EvalCtx, args []*Var) {
+	c.wantArgs("pop", args, 1, false)
+	list := c.listArg("pop", args, 0)
+	n := len(list.Elems)
+	if n == 0 {
+		c.errorf(nil, ErrIndex, "pop from empty list")
+	}
+	last := list.Elems[n-1]
+	list.Elems[n-1] = nil
+	list.Elems = list.Elems[:n-1]
+	c.Result = []*Var{last}
+}
+
+// builtinDelete removes a key from a map.
+func builtinDelete(c *EvalCtx, args []*Var) {
+	c.wantArgs("delete", args, 2, false)
+	if err := c.mapArg("delete", args, 0).Delete(args[1]); err != nil {
+		c.fail(nil, err)
+	}
+}
+
+// builtinKeys returns the keys of a map as a list, in the order they
+// were added.
+func builtinKeys(c *EvalCtx, args []*Var) {
+	c.wantArgs("keys", args, 1, false)
+	keys := c.mapArg("keys", args, 0).Keys()
+	c.Result = []*Var{{Type: LIST, ListVal: &List{Elems: keys}}}
+}
+
+// builtinHas reports whether a map holds a key.
+func builtinHas(c *EvalCtx, args []*Var) {
+	c.wantArgs("has", args, 2, false)
+	v, err := c.mapArg("has", args, 0).Get(args[1])
+	if err != nil {
+		c.fail(nil, err)
+	}
+	c.Result = []*Var{{Type: BOOL, BoolVal: v != nil}}
+}
+
+// builtinRange returns the list of numbers range(hi), range(lo, hi)
+// or range(lo, hi, step) counts through, from lo up to but not
+// including hi.
+func builtinRange(c *EvalCtx, args []*Var) {
+	if len(args) < 1 || len(args) > 3 {
+		c.errorf(nil, ErrArgCount, "call range: want 1 to 3 args, got %d", len(args))
+	}
+	for _, arg := range args {
+		if arg.Type != NUM {
+			c.errorf(nil, ErrType, "invalid argument: range(%v)", arg.Type)
+		}
+	}
+	lo, hi, step := 0.0, args[0].NumVal, 1.0
+	if len(args) > 1 {
+		lo, hi = args[0].NumVal, args[1].NumVal
+	}
+	if len(args) > 2 {
+		step = args[2].NumVal
+	}
+	if step == 0 {
+		c.errorf(nil, ErrRange, "range step must not be zero")
+	}
+	list := &List{}
+	for i := lo; step > 0 && i < hi || step < 0 && i > hi; i += step {
+		list.Elems = append(list.Elems, &Var{Type: NUM, NumVal: i})
+	}
+	c.Result = []*Var{{Type: LIST, ListVal: list}}
+}
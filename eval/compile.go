@@ -0,0 +1,721 @@
+package eval
+
+import (
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// proto is a compiled func, or the code of a top level var
+// declaration, which has no fn.
+type proto struct {
+	fn   *ir.Func
+	code []instr
+	// nodes holds the node each instruction was compiled from;
+	// runtime errors are reported at it.
+	nodes  []ir.Node
+	consts []*Var
+	names  []string
+	protos []*proto
+}
+
+type instr struct {
+	op   opcode
+	a, b int
+}
+
+// branch is a loop or switch that break and continue can jump out
+// of, with the number of envs and iterators open at its end and at
+// the start of an iteration.
+type branch struct {
+	label       string
+	loop        bool
+	envs, iters int
+	contEnvs    int
+	breaks      []int
+	continues   []int
+}
+
+// compiler compiles a func, whose names were bound to slots by
+// ir.Resolve. The envs of the VM are the frames of the walker: a
+// scope gets one when it declares names.
+type compiler struct {
+	p        *proto
+	names    map[string]int
+	branches []*branch
+	// envs and iters count the envs and range iterators opened
+	// by the func being compiled.
+	envs, iters int
+}
+
+func newCompiler(p *proto) *compiler {
+	return &compiler{p: p, names: make(map[string]int)}
+}
+
+// compileFunc compiles the func f.
+func compileFunc(f *ir.Func) *proto {
+	p := &proto{fn: f}
+	cm := newCompiler(p)
+	for _, stmt := range f.Body {
+		cm.stmt(stmt)
+	}
+	cm.emit(f, opReturn, 0, 0)
+	return p
+}
+
+// compileDecl compiles the top level var declaration d.
+func compileDecl(d *ir.VarDecl) *proto {
+	p := &proto{}
+	cm := newCompiler(p)
+	n := len(d.Lhs)
+	cm.values(d, d.Rhs, n)
+	for i, name := range d.Lhs {
+		cm.emit(d, opPick, n-1-i, 0)
+		cm.emit(d, opDefineGlobal, cm.name(name), 0)
+	}
+	cm.emit(d, opPopN, n, 0)
+	cm.emit(d, opReturn, 0, 0)
+	return p
+}
+
+func (cm *compiler) emit(node ir.Node, op opcode, a, b int) int {
+	cm.p.code = append(cm.p.code, instr{op: op, a: a, b: b})
+	cm.p.nodes = append(cm.p.nodes, node)
+	return len(cm.p.code) - 1
+}
+
+// patch makes the jump at pc go to the next instruction.
+func (cm *compiler) patch(pc int) {
+	cm.p.code[pc].a = len(cm.p.code)
+}
+
+func (cm *compiler) constant(v *Var) int {
+	cm.p.consts = append(cm.p.consts, v)
+	return len(cm.p.consts) - 1
+}
+
+func (cm *compiler) name(name string) int {
+	if i, ok := cm.names[name]; ok {
+		return i
+	}
+	cm.p.names = append(cm.p.names, name)
+	cm.names[name] = len(cm.p.names) - 1
+	return len(cm.p.names) - 1
+}
+
+// pushEnv enters a scope with a frame of n slots at run time.
+func (cm *compiler) pushEnv(node ir.Node, n int) {
+	if n > 0 {
+		cm.emit(node, opPushEnv, n, 0)
+		cm.envs++
+	}
+}
+
+// popEnv leaves the scope entered by pushEnv(node, n).
+func (cm *compiler) popEnv(node ir.Node, n int) {
+	if n > 0 {
+		cm.emit(node, opPopEnv, 1, 0)
+		cm.envs--
+	}
+}
+
+// define pops a value into the variable name in slot of the env.
+func (cm *compiler) define(node ir.Node, name string, slot int) {
+	cm.emit(node, opDefine, slot, cm.name(name))
+}
+
+func (cm *compiler) stmt(node ir.Node) {
+	switch node := node.(type) {
+	case nil:
+	case *ir.VarDecl:
+		n := len(node.Lhs)
+		cm.values(node, node.Rhs, n)
+		if n == 1 {
+			cm.define(node, node.Lhs[0], node.Slots[0])
+			break
+		}
+		for i, name := range node.Lhs {
+			cm.emit(node, opPick, n-1-i, 0)
+			cm.define(node, name, node.Slots[i])
+		}
+		cm.emit(node, opPopN, n, 0)
+	case *ir.AssignStmt:
+		cm.assign(node)
+	case *ir.ReturnStmt:
+		spread := false
+		for _, expr := range node.Returns {
+			if _, ok := expr.(*ir.CallExpr); ok {
+				spread = true
+			}
+		}
+		if !spread {
+			for _, expr := range node.Returns {
+				cm.expr(expr)
+			}
+			cm.emit(node, opReturn, len(node.Returns), 0)
+			break
+		}
+		cm.emit(node, opMark, 0, 0)
+		for _, expr := range node.Returns {
+			cm.spread(expr)
+		}
+		cm.emit(node, opReturn, -1, 0)
+	case *ir.BreakStmt:
+		cm.jump(node, node.Label, false)
+	case *ir.ContinueStmt:
+		cm.jump(node, node.Label, true)
+	case *ir.BlockStmt:
+		for _, stmt := range node.Stmts {
+			cm.stmt(stmt)
+		}
+	case *ir.IfStmt:
+		jf := cm.cond(node.Cond)
+		cm.scoped(node.Body, node.BodyFrame)
+		if node.Else == nil {
+			cm.patch(jf)
+			break
+		}
+		end := cm.emit(node, opJump, 0, 0)
+		cm.patch(jf)
+		cm.scoped(node.Else, node.ElseFrame)
+		cm.patch(end)
+	case *ir.ForStmt:
+		cm.pushEnv(node, node.Frame)
+		cm.stmt(node.Init)
+		top := len(cm.p.code)
+		jf := -1
+		if node.Cond != nil {
+			jf = cm.cond(node.Cond)
+		}
+		b := cm.openBranch(node.Label, true, cm.envs)
+		cm.scoped(node.Body, node.BodyFrame)
+		cm.closeBranch()
+		for _, pc := range b.continues {
+			cm.patch(pc)
+		}
+		cm.stmt(node.Post)
+		cm.emit(node, opJump, top, 0)
+		if jf >= 0 {
+			cm.patch(jf)
+		}
+		cm.patchBreaks(b)
+		cm.popEnv(node, node.Frame)
+	case *ir.WhileStmt:
+		top := len(cm.p.code)
+		jf := cm.cond(node.Cond)
+		b := cm.loopBody(node, node.Label, node.Body, node.Frame, top)
+		cm.patch(jf)
+		cm.patchBreaks(b)
+	case *ir.LoopStmt:
+		b := cm.loopBody(node, node.Label, node.Body, node.Frame, len(cm.p.code))
+		cm.patchBreaks(b)
+	case *ir.RangeStmt:
+		cm.rangeStmt(node)
+	case *ir.SwitchStmt:
+		cm.switchStmt(node)
+	case *ir.CallExpr:
+		cm.call(node, 0)
+	default:
+		cm.expr(node)
+		cm.emit(node, opPop, 0, 0)
+	}
+}
+
+// cond compiles the condition node and a jump taken when it is
+// false, to be patched. A comparison jumps without making a bool.
+func (cm *compiler) cond(node ir.Node) int {
+	if node, ok := node.(*ir.BinaryExpr); ok {
+		switch node.Op {
+		case syntax.OpEQ, syntax.OpNEQ, syntax.OpLT, syntax.OpLEQ, syntax.OpGT, syntax.OpGEQ:
+			cm.expr(node.Lhs)
+			cm.expr(node.Rhs)
+			return cm.emit(node, opJumpIfNotCompare, 0, int(node.Op))
+		}
+	}
+	cm.expr(node)
+	return cm.emit(node, opJumpIfNot, 0, 0)
+}
+
+// scoped compiles node in a scope of its own, with a frame of n
+// slots.
+func (cm *compiler) scoped(node ir.Node, n int) {
+	cm.pushEnv(node, n)
+	cm.stmt(node)
+	cm.popEnv(node, n)
+}
+
+func (cm *compiler) openBranch(label string, loop bool, contEnvs int) *branch {
+	b := &branch{label: label, loop: loop, envs: cm.envs, iters: cm.iters, contEnvs: contEnvs}
+	cm.branches = append(cm.branches, b)
+	return b
+}
+
+func (cm *compiler) closeBranch() {
+	cm.branches = cm.branches[:len(cm.branches)-1]
+}
+
+func (cm *compiler) patchBreaks(b *branch) {
+	for _, pc := range b.breaks {
+		cm.patch(pc)
+	}
+}
+
+// loopBody compiles the body of a while or infinite loop, which
+// runs in a new frame of n slots each iteration and then jumps back
+// to top.
+func (cm *compiler) loopBody(node ir.Node, label string, body ir.Node, n, top int) *branch {
+	b := cm.openBranch(label, true, cm.envs)
+	cm.scoped(body, n)
+	cm.closeBranch()
+	for _, pc := range b.continues {
+		cm.p.code[pc].a = top
+	}
+	cm.emit(node, opJump, top, 0)
+	return b
+}
+
+// jump compiles a break or continue: the envs and iterators opened
+// since the target are left, then the jump is patched in later.
+func (cm *compiler) jump(node ir.Node, label string, isContinue bool) {
+	var b *branch
+	for i := len(cm.branches) - 1; i >= 0; i-- {
+		t := cm.branches[i]
+		if label != "" && t.label == label || label == "" && (t.loop || !isContinue) {
+			b = t
+			break
+		}
+	}
+	if b == nil {
+		panic("jump without target")
+	}
+	envs := b.envs
+	if isContinue {
+		envs = b.contEnvs
+	}
+	if n := cm.envs - envs; n > 0 {
+		cm.emit(node, opPopEnv, n, 0)
+	}
+	if n := cm.iters - b.iters; n > 0 {
+		cm.emit(node, opPopIter, n, 0)
+	}
+	pc := cm.emit(node, opJump, 0, 0)
+	if isContinue {
+		b.continues = append(b.continues, pc)
+	} else {
+		b.breaks = append(b.breaks, pc)
+	}
+}
+
+func (cm *compiler) rangeStmt(node *ir.RangeStmt) {
+	cm.expr(node.X)
+	cm.emit(node, opIter, 0, 0)
+	cm.iters++
+	single := 0
+	if node.Key == "" {
+		single = 1
+	}
+	top := cm.emit(node, opNext, 0, single)
+	b := cm.openBranch(node.Label, true, cm.envs)
+	cm.pushEnv(node, node.Frame)
+	slot := 0
+	if node.Key != "" {
+		cm.define(node, node.Key, slot)
+		slot++
+	}
+	cm.define(node, node.Value, slot)
+	cm.scoped(node.Body, node.BodyFrame)
+	cm.popEnv(node, node.Frame)
+	cm.closeBranch()
+	for _, pc := range b.continues {
+		cm.p.code[pc].a = top
+	}
+	cm.emit(node, opJump, top, 0)
+	cm.patch(top)
+	cm.patchBreaks(b)
+	cm.emit(node, opPopIter, 1, 0)
+	cm.iters--
+}
+
+// switchStmt compiles node like the walker runs it: each case value
+// is tried in a new env for the names its pattern binds, which the
+// body of the case then runs in.
+func (cm *compiler) switchStmt(node *ir.SwitchStmt) {
+	tagged := node.Tag != nil
+	if tagged {
+		cm.expr(node.Tag)
+	}
+	// the envs of the cases are pushed here and popped by the
+	// bodies, so they are not counted in cm.envs until then.
+	push := func(i int, at ir.Node) {
+		if n := node.Cases[i].Frame; n > 0 {
+			cm.emit(at, opPushEnv, n, 0)
+		}
+	}
+	pop := func(i int, at ir.Node) {
+		if node.Cases[i].Frame > 0 {
+			cm.emit(at, opPopEnv, 1, 0)
+		}
+	}
+	bodies := make([][]int, len(node.Cases))
+	def := -1
+	for i, clause := range node.Cases {
+		if clause.Values == nil {
+			def = i
+		}
+		for _, value := range clause.Values {
+			push(i, value)
+			var fails []int
+			_, isList := value.(*ir.ListPattern)
+			_, isMap := value.(*ir.MapPattern)
+			isPattern := tagged && (isList || isMap)
+			switch {
+			case isPattern:
+				cm.emit(value, opMark, 0, 0)
+				cm.emit(value, opDup, 0, 0)
+				cm.match(value, &fails)
+				cm.emit(value, opDropMark, 0, 0)
+			case tagged:
+				cm.expr(value)
+				cm.emit(value, opPick, 1, 0)
+				cm.emit(value, opEqual, 0, 0)
+				fails = append(fails, cm.emit(value, opJumpIfNot, 0, 0))
+			default:
+				fails = append(fails, cm.cond(value))
+			}
+			if tagged {
+				cm.emit(value, opPop, 0, 0)
+			}
+			bodies[i] = append(bodies[i], cm.emit(value, opJump, 0, 0))
+			for _, pc := range fails {
+				cm.patch(pc)
+			}
+			if isPattern {
+				cm.emit(value, opTruncate, 0, 0)
+			}
+			pop(i, value)
+		}
+	}
+	if tagged {
+		cm.emit(node, opPop, 0, 0)
+	}
+	b := cm.openBranch(node.Label, false, 0)
+	if def >= 0 {
+		push(def, node.Cases[def])
+		bodies[def] = append(bodies[def], cm.emit(node, opJump, 0, 0))
+	} else {
+		b.breaks = append(b.breaks, cm.emit(node, opJump, 0, 0))
+	}
+	for i, clause := range node.Cases {
+		for _, pc := range bodies[i] {
+			cm.patch(pc)
+		}
+		if clause.Frame > 0 {
+			cm.envs++
+		}
+		for _, stmt := range clause.Body {
+			cm.stmt(stmt)
+		}
+		cm.popEnv(clause, clause.Frame)
+		if clause.Fallthrough && i+1 < len(node.Cases) {
+			// runs on into the next body, in an env of its own.
+			push(i+1, clause)
+			continue
+		}
+		b.breaks = append(b.breaks, cm.emit(clause, opJump, 0, 0))
+	}
+	cm.closeBranch()
+	cm.patchBreaks(b)
+}
+
+// match compiles matching pattern against the value on top of the
+// stack, which it pops on success. A failed match jumps to one of
+// fails, leaving the stack to be truncated to the mark set before.
+func (cm *compiler) match(pattern ir.Node, fails *[]int) {
+	switch pattern := pattern.(type) {
+	case *ir.Name:
+		if pattern.Name == "_" {
+			cm.emit(pattern, opPop, 0, 0)
+			return
+		}
+		cm.define(pattern, pattern.Name, pattern.Ref.Slot)
+	case *ir.ListPattern:
+		rest := 0
+		if pattern.Rest != "" {
+			rest = 1
+		}
+		cm.emit(pattern, opMatchList, len(pattern.Elems), rest)
+		*fails = append(*fails, cm.emit(pattern, opJumpIfNot, 0, 0))
+		for i, elem := range pattern.Elems {
+			cm.emit(elem, opDup, 0, 0)
+			cm.emit(elem, opElem, i, 0)
+			cm.match(elem, fails)
+		}
+		if pattern.Rest != "" {
+			cm.emit(pattern, opDup, 0, 0)
+			cm.emit(pattern, opRest, len(pattern.Elems), 0)
+			cm.define(pattern, pattern.Rest, pattern.RestSlot)
+		}
+		cm.emit(pattern, opPop, 0, 0)
+	case *ir.MapPattern:
+		cm.emit(pattern, opIsMap, 0, 0)
+		*fails = append(*fails, cm.emit(pattern, opJumpIfNot, 0, 0))
+		for i, key := range pattern.Keys {
+			cm.emit(key, opDup, 0, 0)
+			cm.expr(key)
+			cm.emit(key, opMapLookup, 0, 0)
+			*fails = append(*fails, cm.emit(key, opJumpIfNot, 0, 0))
+			cm.match(pattern.Values[i], fails)
+		}
+		cm.emit(pattern, opPop, 0, 0)
+	default:
+		cm.expr(pattern)
+		cm.emit(pattern, opEqual, 0, 0)
+		*fails = append(*fails, cm.emit(pattern, opJumpIfNot, 0, 0))
+	}
+}
+
+// values compiles the right hand side exprs of an assignment to n
+// variables, leaving n copies on the stack.
+func (cm *compiler) values(node ir.Node, exprs []ir.Node, n int) {
+	if len(exprs) == 1 && n == 1 {
+		if _, ok := exprs[0].(*ir.CallExpr); !ok {
+			cm.expr(exprs[0])
+			return
+		}
+	}
+	cm.emit(node, opMark, 0, 0)
+	if len(exprs) == 1 {
+		cm.spread(exprs[0])
+	} else {
+		for _, expr := range exprs {
+			cm.expr(expr)
+		}
+	}
+	cm.emit(node, opUnpack, n, 0)
+}
+
+// assign compiles node like the walker's target: the operands of
+// every target are evaluated and checked before the values.
+func (cm *compiler) assign(node *ir.AssignStmt) {
+	if node.Op != 0 {
+		target := node.Lhs[0]
+		cm.target(target)
+		switch target := target.(type) {
+		case *ir.Name:
+			cm.expr(target)
+		case *ir.IndexExpr:
+			cm.emit(target, opPick, 1, 0)
+			cm.emit(target, opPick, 1, 0)
+			cm.emit(target, opIndexLoad, 0, 0)
+		case *ir.SelectorExpr:
+			cm.emit(target, opDup, 0, 0)
+			cm.emit(target, opField, cm.name(target.Sel), 0)
+		}
+		cm.expr(node.Rhs[0])
+		cm.emit(node, opBinary, int(node.Op), 0)
+		cm.store(target)
+		return
+	}
+	n := len(node.Lhs)
+	operands := 0
+	for _, target := range node.Lhs {
+		operands += cm.target(target)
+	}
+	cm.values(node, node.Rhs, n)
+	if n == 1 {
+		cm.store(node.Lhs[0])
+		return
+	}
+	// the stack holds the operands of every target, then the
+	// values; each store picks up copies of its own.
+	total := operands + n
+	at := 0
+	for i, target := range node.Lhs {
+		k := operandCount(target)
+		for j := 0; j < k; j++ {
+			cm.emit(target, opPick, total-1-(at+j)+j, 0)
+		}
+		cm.emit(target, opPick, total-1-(operands+i)+k, 0)
+		cm.store(target)
+		at += k
+	}
+	cm.emit(node, opPopN, total, 0)
+}
+
+func operandCount(target ir.Node) int {
+	switch target.(type) {
+	case *ir.IndexExpr:
+		return 2
+	case *ir.SelectorExpr:
+		return 1
+	}
+	return 0
+}
+
+// target compiles the operands of target and checks them, returning
+// how many it left on the stack.
+func (cm *compiler) target(target ir.Node) int {
+	switch target := target.(type) {
+	case *ir.Name:
+		// a local is checked when it is stored to.
+		if target.Ref.Global {
+			cm.emit(target, opCheckGlobal, target.Ref.Slot, 0)
+		}
+	case *ir.IndexExpr:
+		cm.expr(target.X)
+		cm.expr(target.Index)
+		cm.emit(target, opCheckIndex, 0, 0)
+	case *ir.SelectorExpr:
+		cm.expr(target.X)
+		cm.emit(target, opCheckField, cm.name(target.Sel), 0)
+	default:
+		panic("unknown assign target")
+	}
+	return operandCount(target)
+}
+
+// store pops a value, and the operands of target, into target.
+func (cm *compiler) store(target ir.Node) {
+	switch target := target.(type) {
+	case *ir.Name:
+		if target.Ref.Global {
+			cm.emit(target, opStoreGlobal, target.Ref.Slot, 0)
+			return
+		}
+		cm.emit(target, opStoreLocal, target.Ref.Depth, target.Ref.Slot)
+	case *ir.IndexExpr:
+		cm.emit(target, opSetIndex, 0, 0)
+	case *ir.SelectorExpr:
+		cm.emit(target, opSetField, cm.name(target.Sel), 0)
+	}
+}
+
+// spread compiles node as an expression that may have any number of
+// values, as a call argument or return value.
+func (cm *compiler) spread(node ir.Node) {
+	if call, ok := node.(*ir.CallExpr); ok {
+		cm.call(call, -1)
+		return
+	}
+	cm.expr(node)
+}
+
+// call compiles a call keeping want of its results: -1 for all of
+// them, 0 for none, or 1 for exactly one.
+func (cm *compiler) call(node *ir.CallExpr, want int) {
+	cm.expr(node.Fun)
+	cm.emit(node, opCheckFunc, 0, 0)
+	spread := false
+	for _, arg := range node.Args {
+		if _, ok := arg.(*ir.CallExpr); ok {
+			spread = true
+		}
+	}
+	if !spread {
+		for _, arg := range node.Args {
+			cm.expr(arg)
+		}
+		cm.emit(node, opCall, len(node.Args), want)
+		return
+	}
+	cm.emit(node, opMark, 0, 0)
+	for _, arg := range node.Args {
+		cm.spread(arg)
+	}
+	cm.emit(node, opCall, -1, want)
+}
+
+// expr compiles node as an expression with exactly one value.
+func (cm *compiler) expr(node ir.Node) {
+	switch node := node.(type) {
+	case *ir.Name:
+		if node.Ref.Global {
+			cm.emit(node, opGlobal, node.Ref.Slot, 0)
+			return
+		}
+		cm.emit(node, opLocal, node.Ref.Depth, node.Ref.Slot)
+	case *ir.Literal:
+		var v Var
+		switch node.Type {
+		case syntax.TNUM:
+			v.Type = NUM
+			// the scanner reported the literals ParseNum fails for.
+			v.NumVal, _ = syntax.ParseNum(node.Val)
+		case syntax.TSTRING:
+			v.Type = STRING
+			v.StringVal = node.Val
+		case syntax.TBOOL:
+			v.Type = BOOL
+			v.BoolVal = node.Val == "true"
+		case syntax.TNIL:
+			v.Type = NIL
+		}
+		cm.emit(node, opConst, cm.constant(&v), 0)
+	case *ir.FuncLit:
+		cm.p.protos = append(cm.p.protos, compileFunc(node.Func))
+		cm.emit(node, opClosure, len(cm.p.protos)-1, 0)
+	case *ir.CallExpr:
+		cm.call(node, 1)
+	case *ir.UnaryExpr:
+		cm.expr(node.X)
+		cm.emit(node, opUnary, int(node.Op), 0)
+	case *ir.BinaryExpr:
+		if node.Op == syntax.OpAND || node.Op == syntax.OpOR {
+			cm.expr(node.Lhs)
+			jump := cm.emit(node.Lhs, opLogic, 0, int(node.Op))
+			cm.expr(node.Rhs)
+			cm.emit(node.Rhs, opCheckBool, int(node.Op), 0)
+			cm.patch(jump)
+			return
+		}
+		cm.expr(node.Lhs)
+		cm.expr(node.Rhs)
+		cm.emit(node, opBinary, int(node.Op), 0)
+	case *ir.ListLit:
+		for _, elem := range node.Elems {
+			cm.expr(elem)
+		}
+		cm.emit(node, opList, len(node.Elems), 0)
+	case *ir.MapLit:
+		cm.emit(node, opMap, 0, 0)
+		for i := range node.Keys {
+			cm.expr(node.Keys[i])
+			cm.expr(node.Values[i])
+			cm.emit(node.Keys[i], opMapSet, 0, 0)
+		}
+	case *ir.StructLit:
+		cm.expr(node.Type)
+		cm.emit(node, opStruct, 0, 0)
+		for i, value := range node.Values {
+			if node.Fields == nil {
+				cm.expr(value)
+				cm.emit(value, opInitField, i, 0)
+				continue
+			}
+			name := cm.name(node.Fields[i])
+			cm.emit(value, opFieldIndex, name, 0)
+			cm.expr(value)
+			cm.emit(value, opInitField, -1, name)
+		}
+	case *ir.SelectorExpr:
+		cm.expr(node.X)
+		cm.emit(node, opSelect, cm.name(node.Sel), 0)
+	case *ir.IndexExpr:
+		cm.expr(node.X)
+		cm.expr(node.Index)
+		cm.emit(node, opIndex, 0, 0)
+	case *ir.SliceExpr:
+		cm.expr(node.X)
+		parts := 0
+		if node.Lo != nil {
+			cm.expr(node.Lo)
+			parts |= 1
+		}
+		if node.Hi != nil {
+			cm.expr(node.Hi)
+			parts |= 2
+		}
+		cm.emit(node, opSlice, parts, 0)
+	default:
+		panic("unknown expr type")
+	}
+}
@@ -0,0 +1,474 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+var (
+	varType     = reflect.TypeOf((*Var)(nil))
+	ctxType     = reflect.TypeOf((*EvalCtx)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	builtInType = reflect.TypeOf((func(c *EvalCtx, args []*Var))(nil))
+)
+
+// structTypes holds the StructType made for each Go struct type, so
+// values converted from the same Go type have the same toylang type.
+var structTypes sync.Map
+
+// ToVar converts a Go value to a toylang value. Bools, strings and
+// numbers convert to themselves, slices and arrays to lists, maps
+// with bool, number or string keys to maps and structs to structs
+// of their exported fields, named by a `toy:"name"` tag if they have
+// one. Pointers and interfaces convert to what they point to, or to
+// nil. A func(c *EvalCtx, args []*Var) is a host func called like a
+// builtin; other funcs are wrapped by WrapFunc. A *Var is copied.
+// A value that contains itself cannot be converted.
+func ToVar(x interface{}) (*Var, error) {
+	switch x := x.(type) {
+	case nil:
+		return &Var{Type: NIL}, nil
+	case *Var:
+		v := *x
+		return &v, nil
+	}
+	return toVar(reflect.ValueOf(x), make(map[ref]bool))
+}
+
+// ref is a Go pointer, map or slice. toVar keeps those it is in the
+// middle of converting, which a cyclic value leads it back to.
+type ref struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// enter adds rv to seen, failing if it is there already.
+func enter(rv reflect.Value, seen map[ref]bool) (ref, error) {
+	r := ref{rv.Pointer(), rv.Type()}
+	if seen[r] {
+		return r, fmt.Errorf("cannot convert cyclic %v", rv.Type())
+	}
+	seen[r] = true
+	return r, nil
+}
+
+func toVar(rv reflect.Value, seen map[ref]bool) (*Var, error) {
+	switch rv.Kind() {
+	case reflect.Bool:
+		return &Var{Type: BOOL, BoolVal: rv.Bool()}, nil
+	case reflect.String:
+		return &Var{Type: STRING, StringVal: rv.String()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Var{Type: NUM, NumVal: float64(rv.Int())}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Var{Type: NUM, NumVal: float64(rv.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Var{Type: NUM, NumVal: rv.Float()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return &Var{Type: NIL}, nil
+		}
+		if rv.Type() == varType {
+			v := *rv.Interface().(*Var)
+			return &v, nil
+		}
+		if rv.Kind() == reflect.Ptr {
+			r, err := enter(rv, seen)
+			if err != nil {
+				return nil, err
+			}
+			defer delete(seen, r)
+		}
+		return toVar(rv.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return &Var{Type: NIL}, nil
+		}
+		if rv.Kind() == reflect.Slice && rv.Len() > 0 {
+			r, err := enter(rv, seen)
+			if err != nil {
+				return nil, err
+			}
+			defer delete(seen, r)
+		}
+		list := &List{Elems: make([]*Var, rv.Len())}
+		for i := range list.Elems {
+			elem, err := toVar(rv.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			list.Elems[i] = elem
+		}
+		return &Var{Type: LIST, ListVal: list}, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return &Var{Type: NIL}, nil
+		}
+		r, err := enter(rv, seen)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(seen, r)
+		// Go maps have no order, so the keys are added sorted.
+		type entry struct {
+			k, v *Var
+		}
+		entries := make([]entry, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := toVar(iter.Key(), seen)
+			if err != nil {
+				return nil, err
+			}
+			v, err := toVar(iter.Value(), seen)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{k, v})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return lessKey(entries[i].k, entries[j].k)
+		})
+		m := NewMap()
+		for _, e := range entries {
+			if err := m.Set(e.k, e.v); err != nil {
+				return nil, err
+			}
+		}
+		return &Var{Type: MAP, MapVal: m}, nil
+	case reflect.Struct:
+		t := structType(rv.Type())
+		s := &Struct{Type: t, Fields: make([]*Var, len(t.Fields))}
+		for i, f := range exportedFields(rv.Type()) {
+			v, err := toVar(rv.FieldByIndex(f.Index), seen)
+			if err != nil {
+				return nil, err
+			}
+			s.Fields[i] = v
+		}
+		return &Var{Type: STRUCT, StructVal: s}, nil
+	case reflect.Func:
+		if rv.IsNil() {
+			return &Var{Type: NIL}, nil
+		}
+		if rv.Type() == builtInType {
+			return &Var{Type: FUNC, BuiltIn: rv.Interface().(func(c *EvalCtx, args []*Var))}, nil
+		}
+		return wrapFunc(goFuncName(rv), rv), nil
+	}
+	return nil, fmt.Errorf("cannot convert %v to a toylang value", rv.Type())
+}
+
+// lessKey orders map keys: bools, then numbers, then strings.
+func lessKey(a, b *Var) bool {
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	switch a.Type {
+	case BOOL:
+		return !a.BoolVal && b.BoolVal
+	case NUM:
+		return a.NumVal < b.NumVal
+	}
+	return a.StringVal < b.StringVal
+}
+
+// exportedFields returns the fields of the struct type t that are
+// converted.
+func exportedFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" && f.Tag.Get("toy") != "-" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func fieldName(f reflect.StructField) string {
+	if name := f.Tag.Get("toy"); name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// structType returns the StructType of the Go struct type t.
+func structType(t reflect.Type) *StructType {
+	if st, ok := structTypes.Load(t); ok {
+		return st.(*StructType)
+	}
+	var names []string
+	for _, f := range exportedFields(t) {
+		names = append(names, fieldName(f))
+	}
+	st, _ := structTypes.LoadOrStore(t, NewStructType(t.Name(), names))
+	return st.(*StructType)
+}
+
+// WrapFunc makes a builtin of the Go func fn. The arguments of a call
+// are converted to the parameter types of fn by Decode, and its
+// results back by ToVar. A first parameter of type *EvalCtx gets the
+// calling context, and a non-nil error as the last result aborts the
+// call with a runtime error.
+func WrapFunc(fn interface{}) (*Var, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("cannot wrap %T as a func", fn)
+	}
+	return wrapFunc(goFuncName(rv), rv), nil
+}
+
+// goFuncName returns the name Go knows fn by.
+func goFuncName(fn reflect.Value) string {
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		return f.Name()
+	}
+	return "func"
+}
+
+// wrapFunc makes a builtin of fn, which errors call name.
+func wrapFunc(name string, fn reflect.Value) *Var {
+	t := fn.Type()
+	in := 0
+	if t.NumIn() > 0 && t.In(0) == ctxType {
+		in = 1
+	}
+	out := t.NumOut()
+	hasErr := out > 0 && t.Out(out-1) == errorType
+	if hasErr {
+		out--
+	}
+	builtIn := func(c *EvalCtx, args []*Var) {
+		fixed := t.NumIn() - in
+		if t.IsVariadic() {
+			c.wantArgs(name, args, fixed-1, true)
+		} else {
+			c.wantArgs(name, args, fixed, false)
+		}
+		var argv []reflect.Value
+		if in == 1 {
+			argv = append(argv, reflect.ValueOf(c))
+		}
+		for i, arg := range args {
+			var pt reflect.Type
+			if t.IsVariadic() && in+i >= t.NumIn()-1 {
+				pt = t.In(t.NumIn() - 1).Elem()
+			} else {
+				pt = t.In(in + i)
+			}
+			av, err := decode(arg, pt)
+			if err != nil {
+				c.errorf(nil, ErrType, "call %s: argument %d: %v", name, i+1, err)
+			}
+			argv = append(argv, av)
+		}
+		results := callGo(c, name, fn, argv)
+		if hasErr {
+			if err, _ := results[out].Interface().(error); err != nil {
+				c.fail(nil, &RuntimeError{Code: ErrHost, Msg: err.Error()})
+			}
+		}
+		c.Result = nil
+		for _, result := range results[:out] {
+			v, err := toVar(result, make(map[ref]bool))
+			if err != nil {
+				c.errorf(nil, ErrType, "call %s: %v", name, err)
+			}
+			c.Result = append(c.Result, v)
+		}
+	}
+	return &Var{Name: name, Type: FUNC, BuiltIn: builtIn}
+}
+
+// callGo calls fn with argv. A panic of fn fails the call like an
+// error it returns; one raised by c.Error is passed on as it is.
+func callGo(c *EvalCtx, name string, fn reflect.Value, argv []reflect.Value) []reflect.Value {
+	defer func() {
+		if r := recover(); r != nil {
+			if re, ok := r.(*RuntimeError); ok {
+				panic(re)
+			}
+			c.errorf(nil, ErrHost, "call %s: panic: %v", name, r)
+		}
+	}()
+	return fn.Call(argv)
+}
+
+// FromVar converts a toylang value to Go: nil, a bool, a string, a
+// float64, a []interface{} or a map[interface{}]interface{}. Funcs,
+// structs and types are returned as the *Var itself.
+func FromVar(v *Var) interface{} {
+	switch v.Type {
+	case BOOL:
+		return v.BoolVal
+	case NUM:
+		return v.NumVal
+	case STRING:
+		return v.StringVal
+	case LIST:
+		list := make([]interface{}, len(v.ListVal.Elems))
+		for i, elem := range v.ListVal.Elems {
+			list[i] = FromVar(elem)
+		}
+		return list
+	case MAP:
+		m := make(map[interface{}]interface{}, v.MapVal.Len())
+		for _, e := range v.MapVal.live() {
+			m[FromVar(e.Key)] = FromVar(e.Val)
+		}
+		return m
+	case NIL:
+		return nil
+	}
+	return v
+}
+
+// Decode stores v in the Go value ptr points to, converting it to
+// the type of that value. Numbers convert to any Go number type they
+// fit in without loss, lists to slices and arrays, maps and structs
+// to maps and to structs, whose fields are matched by name, and nil
+// to the zero value of a pointer, slice, map or interface. Any value
+// can be stored in an interface{}, as FromVar converts it, or in a
+// *Var.
+func Decode(v *Var, ptr interface{}) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode into non-pointer %T", ptr)
+	}
+	dv, err := decode(v, rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(dv)
+	return nil
+}
+
+func decode(v *Var, t reflect.Type) (reflect.Value, error) {
+	if t == varType {
+		cp := *v
+		return reflect.ValueOf(&cp), nil
+	}
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot use %v as %v", v.Type, t)
+	}
+	if v.Type == NIL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(t), nil
+		}
+		return mismatch()
+	}
+	rv := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		x := FromVar(v)
+		if !reflect.TypeOf(x).Implements(t) {
+			return mismatch()
+		}
+		rv.Set(reflect.ValueOf(x))
+	case reflect.Bool:
+		if v.Type != BOOL {
+			return mismatch()
+		}
+		rv.SetBool(v.BoolVal)
+	case reflect.String:
+		if v.Type != STRING {
+			return mismatch()
+		}
+		rv.SetString(v.StringVal)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type != NUM {
+			return mismatch()
+		}
+		n := v.NumVal
+		if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 || rv.OverflowInt(int64(n)) {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %v", n, t)
+		}
+		rv.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Type != NUM {
+			return mismatch()
+		}
+		n := v.NumVal
+		if n != math.Trunc(n) || n < 0 || n >= math.MaxUint64 || rv.OverflowUint(uint64(n)) {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %v", n, t)
+		}
+		rv.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		if v.Type != NUM {
+			return mismatch()
+		}
+		rv.SetFloat(v.NumVal)
+	case reflect.Ptr:
+		elem, err := decode(v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.Set(reflect.New(t.Elem()))
+		rv.Elem().Set(elem)
+	case reflect.Slice, reflect.Array:
+		if v.Type != LIST {
+			return mismatch()
+		}
+		elems := v.ListVal.Elems
+		if t.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(t, len(elems), len(elems)))
+		} else if len(elems) != t.Len() {
+			return reflect.Value{}, fmt.Errorf("cannot use list of %d elements as %v", len(elems), t)
+		}
+		for i, elem := range elems {
+			ev, err := decode(elem, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			rv.Index(i).Set(ev)
+		}
+	case reflect.Map:
+		if v.Type != MAP {
+			return mismatch()
+		}
+		rv.Set(reflect.MakeMapWithSize(t, v.MapVal.Len()))
+		for _, e := range v.MapVal.live() {
+			kv, err := decode(e.Key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			ev, err := decode(e.Val, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			rv.SetMapIndex(kv, ev)
+		}
+	case reflect.Struct:
+		if v.Type != STRUCT && v.Type != MAP {
+			return mismatch()
+		}
+		// fields missing from v are left zero.
+		for _, f := range exportedFields(t) {
+			var fv *Var
+			switch v.Type {
+			case STRUCT:
+				if i := v.StructVal.Type.field(fieldName(f)); i >= 0 {
+					fv = v.StructVal.Fields[i]
+				}
+			case MAP:
+				fv, _ = v.MapVal.Get(&Var{Type: STRING, StringVal: fieldName(f)})
+			}
+			if fv == nil {
+				continue
+			}
+			ev, err := decode(fv, f.Type)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("field %s: %v", fieldName(f), err)
+			}
+			rv.FieldByIndex(f.Index).Set(ev)
+		}
+	default:
+		return mismatch()
+	}
+	return rv, nil
+}
@@ -0,0 +1,159 @@
+package eval
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type point struct {
+	X, Y float64
+	Tag  string `toy:"tag"`
+	priv int
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestConvert(t *testing.T) {
+	in := newInterp(t, `
+func main() {
+    print(parse("12", 3), "\n")
+    var p = origin()
+    print(p, " ", p.tag, "\n")
+    p.X = 4
+    print(norm(p), " ", norm({"X": 1, "Y": 1}), "\n")
+    print(sum(1, 2, 3), " ", sum(), " ", two(4), "\n")
+    print(cfg["b"], " ", cfg, " ", nums, "\n")
+    parse("x", 1)
+}
+func float() { return sum(1.5) }
+func str() { return sum("a") }
+func boom() { return crash(1) }
+`)
+	defs := map[string]interface{}{
+		"parse": func(s string, scale int) (float64, error) {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return 0, errors.New("not a number: " + s)
+			}
+			return float64(n * scale), nil
+		},
+		"origin": func() point { return point{Tag: "o"} },
+		"norm":   func(p point) float64 { return p.X*p.X + p.Y*p.Y },
+		"sum": func(ns ...int) int {
+			t := 0
+			for _, n := range ns {
+				t += n
+			}
+			return t
+		},
+		"two": func(c *EvalCtx, a int) (int, int) { return a, a * 2 },
+		"crash": func(n int) int {
+			var m map[string]int
+			m["x"] = n
+			return n
+		},
+		"cfg":  map[string]int{"b": 2, "a": 1},
+		"nums": []uint8{1, 2},
+	}
+	for name, x := range defs {
+		if err := in.Define(name, x); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	var out bytes.Buffer
+	in.SetOutput(&out)
+	err := in.Run()
+	want := "36.000000\npoint{X:0.000000 Y:0.000000 tag:o} o\n16.000000 2.000000\n6.000000 0.000000 4.0000008.000000\n2.000000 {a:1.000000 b:2.000000} [1.000000 2.000000]\n"
+	if out.String() != want || err == nil || err.Error() != "host.toy:10:5: not a number: x" {
+		t.Errorf("Run: got %q, %v", out.String(), err)
+	}
+	for name, want := range map[string]string{
+		"float": "host.toy:12:23: call sum: argument 1: cannot use 1.5 as int",
+		"str":   "host.toy:13:21: call sum: argument 1: cannot use string as int",
+		"boom":  "host.toy:14:22: call crash: panic: assignment to entry in nil map",
+	} {
+		if _, err := in.Call(name); err == nil || err.Error() != want {
+			t.Errorf("%s: got %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestToVar(t *testing.T) {
+	shared := &node{Name: "s"}
+	self := &node{Name: "a"}
+	self.Next = self
+	list := []interface{}{1, nil}
+	list[1] = list
+	m := map[string]interface{}{}
+	m["m"] = m
+	tests := []struct {
+		x    interface{}
+		want string
+	}{
+		{[]*node{shared, shared}, "[map[Name:s Next:<nil>] map[Name:s Next:<nil>]]"},
+		{map[bool]string{true: "t", false: "f"}, "map[false:f true:t]"},
+		{[2]float32{0.5, 2}, "[0.5 2]"},
+		{self, "cannot convert cyclic *eval.node"},
+		{list, "cannot convert cyclic []interface {}"},
+		{m, "cannot convert cyclic map[string]interface {}"},
+		{make(chan int), "cannot convert chan int to a toylang value"},
+		{map[point]int{{}: 1}, "invalid map key type struct"},
+	}
+	for _, test := range tests {
+		v, err := ToVar(test.x)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = fmt.Sprint(fromStruct(FromVar(v)))
+		}
+		if got != test.want {
+			t.Errorf("%T: got %s, want %s", test.x, got, test.want)
+		}
+	}
+}
+
+// fromStruct turns the structs in x, which FromVar leaves as *Var,
+// into maps of their fields, so they print the same every time.
+func fromStruct(x interface{}) interface{} {
+	switch x := x.(type) {
+	case []interface{}:
+		for i := range x {
+			x[i] = fromStruct(x[i])
+		}
+	case *Var:
+		if x.Type == STRUCT {
+			m := make(map[string]interface{})
+			for i, f := range x.StructVal.Fields {
+				m[x.StructVal.Type.Fields[i]] = fromStruct(FromVar(f))
+			}
+			return m
+		}
+	}
+	return x
+}
+
+func TestDecode(t *testing.T) {
+	var p point
+	v, _ := ToVar(map[string]interface{}{"X": 2, "tag": "t"})
+	if err := Decode(v, &p); err != nil || p != (point{X: 2, Tag: "t"}) {
+		t.Errorf("point: got %+v, %v", p, err)
+	}
+	var xs []int
+	v, _ = ToVar([]float64{1, 2.5})
+	if err := Decode(v, &xs); err == nil || err.Error() != "cannot use 2.5 as int" {
+		t.Errorf("[]int: got %v, %v", xs, err)
+	}
+	var m map[string][]string
+	v, _ = ToVar(map[string]interface{}{"a": []interface{}{"x"}, "b": nil})
+	if err := Decode(v, &m); err != nil || !reflect.DeepEqual(m, map[string][]string{"a": {"x"}, "b": nil}) {
+		t.Errorf("map: got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,82 @@
+package eval
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cuiweixie/toylang/ir"
+)
+
+var update = flag.Bool("update", false, "rewrite the .out files in testdata")
+
+// modes are the ways a program can be run, which all print the same.
+var modes = []struct {
+	name     string
+	engine   Engine
+	optimize bool
+}{
+	{"walker", Walker, false},
+	{"vm", VM, false},
+	{"walker -O", Walker, true},
+	{"vm -O", VM, true},
+}
+
+// runFile runs the program in file and returns what it prints,
+// followed by the error it fails with.
+func runFile(t *testing.T, file string, engine Engine, optimize bool) string {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = func() error {
+		in, err := New(file, src)
+		if err != nil {
+			return err
+		}
+		in.SetOutput(&out)
+		in.SetEngine(engine)
+		if optimize {
+			in.SetPasses(ir.NewPassManager())
+		}
+		return in.Run()
+	}()
+	s := out.String()
+	if err != nil {
+		s += "\nerror: " + err.Error() + "\n"
+	}
+	return s
+}
+
+// TestEngines runs the programs in testdata in every mode and
+// compares what they print with the .out file next to them, which
+// -update writes from what the walker prints.
+func TestEngines(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.toy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		golden := strings.TrimSuffix(file, ".toy") + ".out"
+		if *update {
+			out := runFile(t, file, Walker, false)
+			if err := ioutil.WriteFile(golden, []byte(out), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range modes {
+			got := runFile(t, file, m.engine, m.optimize)
+			if got != string(want) {
+				t.Errorf("%s %s:\ngot  %q\nwant %q", m.name, file, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package eval
+
+import (
+	"fmt"
+
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+//go:generate stringer -type ErrorCode -linecomment errors.go
+type ErrorCode int
+
+const (
+	_             ErrorCode = iota
+	ErrUndefined            // undefined name
+	ErrType                 // type mismatch
+	ErrArgCount             // wrong argument count
+	ErrValueCount           // wrong value count
+	ErrNotFunc              // call of non-function
+	ErrRange                // value out of range
+	ErrIndex                // index out of range
+	ErrDuplicate            // duplicate declaration
+	ErrHost                 // error from host func
+	ErrDepth                // call depth exceeded
+)
+
+// RuntimeError is a failure while evaluating a program.
+type RuntimeError struct {
+	Pos  syntax.Pos
+	Code ErrorCode
+	Msg  string
+}
+
+func (e *RuntimeError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Pos.String(), e.Msg)
+}
+
+// errorf aborts the evaluation with a RuntimeError about node. It
+// is turned back into an error by recoverError.
+func (c *EvalCtx) errorf(node ir.Node, code ErrorCode, format string, args ...interface{}) {
+	c.fail(node, &RuntimeError{Code: code, Msg: fmt.Sprintf(format, args...)})
+}
+
+// fail aborts the evaluation with err, an error found by a helper
+// that does not know which node it is working on.
+func (c *EvalCtx) fail(node ir.Node, err error) {
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		re = &RuntimeError{Msg: err.Error()}
+	}
+	if !re.Pos.IsValid() && node != nil {
+		re.Pos = node.Pos()
+	}
+	panic(re)
+}
+
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		re, ok := r.(*RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		*err = re
+	}
+}
@@ -4,23 +4,29 @@ import (
 	"fmt"
 	"github.com/cuiweixie/toylang/ir"
 	"github.com/cuiweixie/toylang/syntax"
-	"strconv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
 )
 
+// EvalFile runs the main func of the named file. Syntax errors, and
+// undefined and duplicate names, are returned as a syntax.ErrorList,
+// failures while running as a *RuntimeError.
 func EvalFile(name string) error {
-	file, err := syntax.ParseFile(name)
+	src, err := ioutil.ReadFile(name)
 	if err != nil {
 		return err
 	}
-	nodes := ir.GenAst(file)
-	c := loadNodes(nodes)
-	err = registGlobalBultin(c.Scope)
+	in, err := New(name, src)
 	if err != nil {
 		return err
 	}
-	node := GetFuncByName(c, "main")
-	EvalNode(c, node, nil)
-	return nil
+	return in.Run()
 }
 
 
@@ -37,42 +43,98 @@ func GetFuncByName(c *EvalCtx, name string) ir.Node {
 func NewEvalCtx(scope *Scope) *EvalCtx {
 	c := new(EvalCtx)
 	c.Scope = scope
+	c.maxDepth = DefaultMaxDepth
+	c.out = os.Stdout
 	return c
 }
 
-func registGlobalBultin(scope *Scope) error {
-	var printVar Var
-	printVar.Type = FUNC
-	printVar.BuiltIn = func(c *EvalCtx, args []*Var) {
-		for _, arg := range args {
-			PrintVar(arg)
-		}
+func registGlobalBultin(scope *Scope) {
+	for name, fn := range builtins {
+		scope.Def[name] = &Var{Name: name, Type: FUNC, BuiltIn: fn}
 	}
-	scope.Def["print"] = &printVar
-	return nil
 }
 
 
+// PrintVar prints v to os.Stdout.
 func PrintVar(v *Var) {
+	FprintVar(os.Stdout, v)
+}
+
+// FprintVar prints v to w as the print builtin does.
+func FprintVar(w io.Writer, v *Var) {
 	switch v.Type {
 	case NUM:
-		fmt.Printf("%f", v.NumVal)
+		fmt.Fprintf(w, "%f", v.NumVal)
 	case STRING:
-		fmt.Printf("%s", v.StringVal)
+		fmt.Fprintf(w, "%s", v.StringVal)
 	case FUNC:
-		fmt.Printf("func[%s]", v.Func.(*ir.Func).FuncName)
+		name := v.Name
+		if f, ok := v.Func.(*ir.Func); ok {
+			name = f.FuncName
+		}
+		fmt.Fprintf(w, "func[%s]", name)
 	case BOOL:
-		fmt.Printf("%v", v.BoolVal)
+		fmt.Fprintf(w, "%v", v.BoolVal)
 	case NIL:
-		fmt.Print("nil")
+		fmt.Fprint(w, "nil")
+	case LIST:
+		fmt.Fprint(w, "[")
+		for i, elem := range v.ListVal.Elems {
+			if i > 0 {
+				fmt.Fprint(w, " ")
+			}
+			FprintVar(w, elem)
+		}
+		fmt.Fprint(w, "]")
+	case MAP:
+		fmt.Fprint(w, "{")
+		for i, e := range v.MapVal.live() {
+			if i > 0 {
+				fmt.Fprint(w, " ")
+			}
+			FprintVar(w, e.Key)
+			fmt.Fprint(w, ":")
+			FprintVar(w, e.Val)
+		}
+		fmt.Fprint(w, "}")
+	case STRUCT:
+		t := v.StructVal.Type
+		fmt.Fprintf(w, "%s{", t.Name)
+		for i, f := range v.StructVal.Fields {
+			if i > 0 {
+				fmt.Fprint(w, " ")
+			}
+			fmt.Fprintf(w, "%s:", t.Fields[i])
+			FprintVar(w, f)
+		}
+		fmt.Fprint(w, "}")
+	case TYPE:
+		fmt.Fprintf(w, "type[%s]", v.TypeVal.Name)
 	}
 }
 
+// Scope holds the globals by name, for the host to get at; the
+// program itself uses them by the slots ir.Resolve gave them.
 type Scope struct {
 	Parent *Scope
 	Def map[string]Def
 }
 
+// env is the frame of a scope: its variables, by the slots
+// ir.Resolve gave them, and the frame of the scope around it.
+type env struct {
+	slots  []*Var
+	parent *env
+}
+
+// up returns the frame depth frames out from e.
+func (e *env) up(depth int) *env {
+	for ; depth > 0; depth-- {
+		e = e.parent
+	}
+	return e
+}
+
 type Def interface {
 	aVar()
 }
@@ -81,85 +143,182 @@ type Var struct {
 	Name string
 	NumVal float64
 	StringVal string
-	BoolVal bool
-	Func ir.Node
-	Type VarType
+	BoolVal   bool
+	ListVal   *List
+	MapVal    *Map
+	StructVal *Struct
+	TypeVal   *StructType
+	Func      ir.Node
+	// Recv is the receiver a method FUNC is bound to.
+	Recv    *Var
+	Type    VarType
 	BuiltIn func(c *EvalCtx, args []*Var)
+	// env is the frame a FUNC was defined in, which the frame
+	// of a call is put on, and proto its code when it was
+	// compiled for the VM.
+	env   *env
+	proto *proto
 	Def
 }
 
+// List is shared by every Var holding it, so a list passed
+// around keeps referring to the same elements.
+type List struct {
+	Elems []*Var
+}
+
 type EvalCtx struct {
 	Scope      *Scope
 	Result     []*Var
-	isReturn   bool
-	isContinue bool
-	isBreak    bool
+	// env is the frame of the innermost scope being run.
+	env *env
+	// globals are the globals by slot, and slots the slot of
+	// each of their names.
+	globals []*Var
+	slots   map[string]int
+	// vm runs the program when it was loaded by loadVM.
+	vm *vm
+	// depth is the number of funcs the walker is running, which
+	// may not be more than maxDepth.
+	depth, maxDepth int
+	// out is where print writes.
+	out io.Writer
 }
 
+// completion records how a statement finished. A break, continue or
+// return is passed on by the statements around it until the loop,
+// switch or func it is aimed at handles it.
+type completion struct {
+	kind completionKind
+	// label is the label of the loop or switch a break or
+	// continue is aimed at, or empty for the innermost one.
+	label string
+	// values are the results of a return.
+	values []*Var
+}
+
+type completionKind int
+
+const (
+	normal completionKind = iota
+	breakCompletion
+	continueCompletion
+	returnCompletion
+)
+
+//go:generate stringer -type VarType -linecomment eval.go
 type VarType int
 
 const (
-	_ VarType = iota
-	BOOL
-	NUM
-	STRING
-	NIL
-	FUNC
+	_      VarType = iota
+	BOOL           // bool
+	NUM            // num
+	STRING         // string
+	NIL            // nil
+	FUNC           // func
+	LIST           // list
+	MAP            // map
+	STRUCT         // struct
+	TYPE           // type
 )
 
 
-func loadNodes(nodes []ir.Node) *EvalCtx {
-	scope := &Scope{
-		Parent: nil,
-		Def:    make(map[string]Def),
+// resolve binds the names of the program nodes, whose predeclared
+// globals are those defined so far.
+func (c *EvalCtx) resolve(nodes []ir.Node) error {
+	global := c.global()
+	var predeclared []string
+	for name := range global.Def {
+		predeclared = append(predeclared, name)
 	}
-	c := NewEvalCtx(scope)
+	sort.Strings(predeclared)
+	names, err := ir.Resolve(nodes, predeclared)
+	if err != nil {
+		return err
+	}
+	c.globals = make([]*Var, len(names))
+	c.slots = make(map[string]int)
+	for i, name := range names {
+		c.globals[i], _ = global.Def[name].(*Var)
+		c.slots[name] = i
+	}
+	return nil
+}
+
+// setGlobal makes v the global name.
+func (c *EvalCtx) setGlobal(name string, v *Var) {
+	v.Name = name
+	c.global().Def[name] = v
+	if i, ok := c.slots[name]; ok {
+		c.globals[i] = v
+	}
+}
+
+// loadNodes declares the top level nodes in the current scope.
+func (c *EvalCtx) loadNodes(nodes []ir.Node) {
+	c.loadTypes(nodes)
 	for _, node := range nodes {
 		switch node := node.(type) {
 		case *ir.VarDecl:
-			c = EvalNode(c, node, nil)
+			for i, v := range c.values(node, node.Rhs, len(node.Lhs)) {
+				c.setGlobal(node.Lhs[i], v)
+			}
 		case *ir.Func:
-			c.Scope.Def[node.FuncName] = &Var{Name: node.FuncName, Type: FUNC, Func: node}
+			if node.RecvType != "" {
+				c.loadMethod(node, &Var{Type: FUNC, Func: node})
+				break
+			}
+			c.setGlobal(node.FuncName, &Var{Type: FUNC, Func: node})
+		case *ir.TypeDecl:
 		default:
 			panic("no support node")
 		}
 	}
-	return c
 }
 
+// loadTypes declares the types among the top level nodes. They come
+// first, so methods and vars can use the types declared below them.
+func (c *EvalCtx) loadTypes(nodes []ir.Node) {
+	for _, node := range nodes {
+		if node, ok := node.(*ir.TypeDecl); ok {
+			c.setGlobal(node.Name, &Var{Type: TYPE, TypeVal: NewStructType(node.Name, node.Fields)})
+		}
+	}
+}
+
+// EvalNode evaluates an expression, leaving its values in c.Result,
+// or calls the func node with args. Statements are run by exec.
 func EvalNode(c *EvalCtx, node ir.Node, args []*Var) *EvalCtx {
 	c.Result = nil
-	if c.isReturn || c.isBreak || c.isContinue {
-		return c
-	}
 	switch node := node.(type) {
 	case *ir.Name:
-		_varDef := c.LookupVar(node.Name)
-		_var, ok := _varDef.(*Var)
-		if !ok {
-			panic(fmt.Sprintf("undefine var %s", node.Name))
-		}
-		c.Result = append(c.Result, _var)
-	case *ir.VarDecl:
-		for i := range node.Lhs {
-			c = EvalNode(c, node.Rhs[i], nil)
-			c.Scope.Def[node.Lhs[i]] = c.Result[0]
-		}
+		// a copy, which a later store to the name leaves as it was:
+		// the args of a call and the values it returns are those the
+		// names had when they were evaluated.
+		v := *c.variable(c.env, node)
+		c.Result = append(c.Result, &v)
 	case *ir.Literal:
 		var result Var
 		switch node.Type {
 		case syntax.TNUM:
 			result.Type = NUM
-			result.NumVal, _ = strconv.ParseFloat(node.Val, 64)
+			// the scanner reported the literals ParseNum fails for.
+			result.NumVal, _ = syntax.ParseNum(node.Val)
 		case syntax.TSTRING:
 			result.Type = STRING
 			result.StringVal = node.Val
+		case syntax.TBOOL:
+			result.Type = BOOL
+			result.BoolVal = node.Val == "true"
+		case syntax.TNIL:
+			result.Type = NIL
 		}
 		c.Result = []*Var{&result}
+	case *ir.FuncLit:
+		c.Result = []*Var{{Type: FUNC, Func: node.Func, env: c.env}}
 	case *ir.CallExpr:
-		funcName := node.Name
-		def := c.LookupVar(funcName)
-		varItem, _ := def.(*Var)
+		varItem := c.value(node.Fun)
+		c.checkFunc(node, varItem)
 		var args []*Var
 		for i := 0; i<len(node.Args); i++ {
 			c = EvalNode(c, node.Args[i], nil)
@@ -167,22 +326,149 @@ func EvalNode(c *EvalCtx, node ir.Node, args []*Var) *EvalCtx {
 				args = append(args, v)
 			}
 		}
-		if varItem.BuiltIn != nil {
-			varItem.BuiltIn(c, args)
-		} else {
-			c = EvalNode(c, varItem.Func, args)
-		}
-		c.isReturn = false
+		c.callValue(node, varItem, args)
 	case *ir.Func:
-		c.PushScope()
+		c.env = &env{slots: make([]*Var, node.Frame), parent: c.env}
+		bindArgs(c.env.slots, node, args)
+		var results []*Var
 		for _, bn := range node.Body {
-			c = EvalNode(c, bn, nil)
-			if c.isReturn {
-				c.isReturn = false
+			if cmp := c.exec(bn); cmp.kind == returnCompletion {
+				results = cmp.values
 				break
 			}
 		}
-		c.PopScope()
+		c.Result = results
+		c.env = c.env.parent
+	case *ir.UnaryExpr:
+		x := c.value(node.X)
+		result, err := GetUnaryOpResult(node.Op, x)
+		if err != nil {
+			c.fail(node, err)
+		}
+		c.Result = []*Var{result}
+	case *ir.BinaryExpr:
+		if node.Op == syntax.OpAND || node.Op == syntax.OpOR {
+			c.Result = []*Var{c.logicalOp(node)}
+			break
+		}
+		leftVar := c.value(node.Lhs)
+		rightVar := c.value(node.Rhs)
+		result, err := GetBinaryOpResult(node.Op, leftVar, rightVar)
+		if err != nil {
+			c.fail(node, err)
+		}
+		c.Result = []*Var{result}
+	case *ir.ListLit:
+		list := &List{}
+		for _, elem := range node.Elems {
+			v := *c.value(elem)
+			list.Elems = append(list.Elems, &v)
+		}
+		c.Result = []*Var{{Type: LIST, ListVal: list}}
+	case *ir.MapLit:
+		m := NewMap()
+		for i := range node.Keys {
+			k := c.value(node.Keys[i])
+			if err := m.Set(k, c.value(node.Values[i])); err != nil {
+				c.fail(node.Keys[i], err)
+			}
+		}
+		c.Result = []*Var{{Type: MAP, MapVal: m}}
+	case *ir.StructLit:
+		c.Result = []*Var{c.structLit(node)}
+	case *ir.SelectorExpr:
+		c.Result = []*Var{c.selector(node)}
+	case *ir.IndexExpr:
+		x := c.value(node.X)
+		c.Result = []*Var{c.indexOf(node, x, c.value(node.Index))}
+	case *ir.SliceExpr:
+		x := c.value(node.X)
+		var lo, hi *Var
+		if node.Lo != nil {
+			lo = c.value(node.Lo)
+		}
+		if node.Hi != nil {
+			hi = c.value(node.Hi)
+		}
+		c.Result = []*Var{c.sliceOf(node, x, lo, hi)}
+	}
+	return c
+}
+
+// indexOf returns a copy of x[k], the element of a list or the value
+// of a map for k, which is nil if the map has no such key.
+func (c *EvalCtx) indexOf(node *ir.IndexExpr, x, k *Var) *Var {
+	switch x.Type {
+	case LIST:
+		elem := *x.ListVal.Elems[c.checkIndex(node.Index, k, len(x.ListVal.Elems), false)]
+		return &elem
+	case MAP:
+		v, err := x.MapVal.Get(k)
+		if err != nil {
+			c.fail(node.Index, err)
+		}
+		if v == nil {
+			return &Var{Type: NIL}
+		}
+		elem := *v
+		return &elem
+	}
+	c.errorf(node.X, ErrType, "cannot index %v", x.Type)
+	return nil
+}
+
+// sliceOf returns the list x[lo:hi]; lo and hi are nil when omitted.
+func (c *EvalCtx) sliceOf(node *ir.SliceExpr, x, lo, hi *Var) *Var {
+	if x.Type != LIST {
+		c.errorf(node.X, ErrType, "cannot slice %v", x.Type)
+	}
+	elems := x.ListVal.Elems
+	l, h := 0, len(elems)
+	if lo != nil {
+		l = c.checkIndex(node.Lo, lo, len(elems), true)
+	}
+	if hi != nil {
+		h = c.checkIndex(node.Hi, hi, len(elems), true)
+	}
+	if l > h {
+		c.errorf(node, ErrIndex, "invalid slice indices: %d > %d", l, h)
+	}
+	// the slice is a new list; assigning to its elements
+	// leaves x alone.
+	elems = append([]*Var(nil), elems[l:h]...)
+	return &Var{Type: LIST, ListVal: &List{Elems: elems}}
+}
+
+// exec runs the statement node and returns how it completed.
+func (c *EvalCtx) exec(node ir.Node) completion {
+	switch node := node.(type) {
+	case nil:
+	case *ir.VarDecl:
+		values := c.values(node, node.Rhs, len(node.Lhs))
+		for i, v := range values {
+			v.Name = node.Lhs[i]
+			c.env.slots[node.Slots[i]] = v
+		}
+	case *ir.AssignStmt:
+		// every operand is evaluated before anything is
+		// assigned, so a, b = b, a swaps.
+		if node.Op != 0 {
+			load, store := c.target(node.Lhs[0])
+			v, err := GetBinaryOpResult(node.Op, load(), c.value(node.Rhs[0]))
+			if err != nil {
+				c.fail(node, err)
+			}
+			store(v)
+			break
+		}
+		var stores []func(v *Var)
+		for _, target := range node.Lhs {
+			_, store := c.target(target)
+			stores = append(stores, store)
+		}
+		for i, v := range c.values(node, node.Rhs, len(node.Lhs)) {
+			stores[i](v)
+		}
 	case *ir.ReturnStmt:
 		var results []*Var
 		for i := 0; i<len(node.Returns); i++ {
@@ -191,194 +477,740 @@ func EvalNode(c *EvalCtx, node ir.Node, args []*Var) *EvalCtx {
 				results = append(results, v)
 			}
 		}
-		c.Result = results
-		c.isReturn = true
+		return completion{kind: returnCompletion, values: results}
+	case *ir.BreakStmt:
+		return completion{kind: breakCompletion, label: node.Label}
+	case *ir.ContinueStmt:
+		return completion{kind: continueCompletion, label: node.Label}
 	case *ir.BlockStmt:
 		for _, stmt := range node.Stmts {
-			c = EvalNode(c, stmt, nil)
-			if c.isReturn {
-				return c
+			if cmp := c.exec(stmt); cmp.kind != normal {
+				return cmp
 			}
 		}
-	case *ir.BinaryExpr:
-		c = EvalNode(c, node.Lhs, nil)
-		leftVar := c.Result[0]
-		if len(c.Result) != 1 {
-			panic("expr op != 1")
+	case *ir.IfStmt:
+		body, frame := node.Body, node.BodyFrame
+		if !c.cond(node.Cond) {
+			body, frame = node.Else, node.ElseFrame
 		}
-		c = EvalNode(c, node.Rhs, nil)
-		rightVar := c.Result[0]
-		if len(c.Result) != 1 {
-			panic("expr op != 1")
+		c.pushFrame(frame)
+		cmp := c.exec(body)
+		c.popFrame(frame)
+		return cmp
+	case *ir.ForStmt:
+		c.pushFrame(node.Frame)
+		c.exec(node.Init)
+		var cmp completion
+		for node.Cond == nil || c.cond(node.Cond) {
+			c.pushFrame(node.BodyFrame)
+			done, result := c.loopBody(node.Label, node.Body)
+			c.popFrame(node.BodyFrame)
+			if done {
+				cmp = result
+				break
+			}
+			c.exec(node.Post)
 		}
-		result := GetBinaryOpResult(node.Op, leftVar, rightVar)
-		if result == nil {
-			panic("get binary op result error")
+		c.popFrame(node.Frame)
+		return cmp
+	case *ir.WhileStmt:
+		for c.cond(node.Cond) {
+			c.pushFrame(node.Frame)
+			done, cmp := c.loopBody(node.Label, node.Body)
+			c.popFrame(node.Frame)
+			if done {
+				return cmp
+			}
 		}
-		c.Result = []*Var{result}
-	case *ir.IfStmt:
-		c = EvalNode(c, node.Cond, nil)
-		if len(c.Result) != 1 {
-			panic("condition result != 1")
+	case *ir.LoopStmt:
+		for {
+			c.pushFrame(node.Frame)
+			done, cmp := c.loopBody(node.Label, node.Body)
+			c.popFrame(node.Frame)
+			if done {
+				return cmp
+			}
+		}
+	case *ir.RangeStmt:
+		return c.rangeLoop(node)
+	case *ir.SwitchStmt:
+		return c.switchStmt(node)
+	default:
+		EvalNode(c, node, nil)
+	}
+	return completion{}
+}
+
+// loopBody runs the body of the loop labelled label once. It reports
+// whether the loop is over, and if so how the loop completes: a
+// return, or a break or continue aimed at an outer statement, is
+// passed on.
+func (c *EvalCtx) loopBody(label string, body ir.Node) (bool, completion) {
+	cmp := c.exec(body)
+	switch cmp.kind {
+	case breakCompletion:
+		if cmp.label == "" || cmp.label == label {
+			return true, completion{}
 		}
-		if c.Result[0].Type != BOOL {
-			panic("error result")
+		return true, cmp
+	case continueCompletion:
+		if cmp.label == "" || cmp.label == label {
+			return false, completion{}
 		}
-		c.PushScope()
-		if c.Result[0].BoolVal {
-			c = EvalNode(c, node.Body, nil)
-		} else {
-			c = EvalNode(c, node.Else, nil)
+		return true, cmp
+	case returnCompletion:
+		return true, cmp
+	}
+	return false, completion{}
+}
+
+// rangeLoop runs a for-in loop over the numbers below a number, or
+// the index and element pairs of a string or list, or the key and
+// value pairs of a map. With a single variable, a map gives its keys
+// and the others their elements. Every iteration gets new variables,
+// so closures created in the body see the values of their iteration.
+func (c *EvalCtx) rangeLoop(node *ir.RangeStmt) completion {
+	it := c.iterate(node, c.value(node.X))
+	for {
+		key, value, ok := it.next()
+		if !ok {
+			return completion{}
 		}
-		c.PopScope()
-	case *ir.ForStmt:
-		c.PushScope()
-		c = EvalNode(c, node.Init, nil)
-		for {
-			c = EvalNode(c, node.Cond, nil)
-			if len(c.Result) != 1 {
-				panic("condition result != 1")
+		c.pushFrame(node.Frame)
+		slot := 0
+		if node.Key != "" {
+			key.Name = node.Key
+			c.env.slots[slot] = key
+			slot++
+		}
+		value.Name = node.Value
+		c.env.slots[slot] = value
+		c.pushFrame(node.BodyFrame)
+		done, cmp := c.loopBody(node.Label, node.Body)
+		c.popFrame(node.BodyFrame)
+		c.popFrame(node.Frame)
+		if done {
+			return cmp
+		}
+	}
+}
+
+// iterator steps through the pairs a for-in loop visits.
+type iterator struct {
+	x *Var
+	// single is set for a loop with one variable.
+	single bool
+	i      int
+	// s holds the string, elems the list elements and keys
+	// the map keys being visited.
+	s     string
+	elems []*Var
+	keys  []*Var
+}
+
+// iterate starts the for-in loop node over x.
+func (c *EvalCtx) iterate(node *ir.RangeStmt, x *Var) *iterator {
+	it := &iterator{x: x, single: node.Key == ""}
+	switch x.Type {
+	case NUM:
+		if !it.single {
+			c.errorf(node.X, ErrType, "range over num permits only one iteration variable")
+		}
+	case STRING:
+		it.s = x.StringVal
+	case LIST:
+		it.elems = x.ListVal.Elems
+	case MAP:
+		it.keys = x.MapVal.Keys()
+	default:
+		c.errorf(node.X, ErrType, "cannot range over %v", x.Type)
+	}
+	return it
+}
+
+// next returns the next key and value, which are new variables, so
+// closures created in the body see the values of their iteration.
+// The key is nil when the loop has one variable.
+func (it *iterator) next() (key, value *Var, ok bool) {
+	switch it.x.Type {
+	case NUM:
+		if float64(it.i) >= it.x.NumVal {
+			return nil, nil, false
+		}
+		it.i++
+		return nil, &Var{Type: NUM, NumVal: float64(it.i - 1)}, true
+	case STRING:
+		if it.i >= len(it.s) {
+			return nil, nil, false
+		}
+		r, size := utf8.DecodeRuneInString(it.s[it.i:])
+		key = &Var{Type: NUM, NumVal: float64(it.i)}
+		it.i += size
+		return key, &Var{Type: STRING, StringVal: string(r)}, true
+	case LIST:
+		if it.i >= len(it.elems) {
+			return nil, nil, false
+		}
+		v := *it.elems[it.i]
+		it.i++
+		return &Var{Type: NUM, NumVal: float64(it.i - 1)}, &v, true
+	}
+	// keys deleted by the body before they are reached are
+	// skipped; keys added are not visited.
+	for it.i < len(it.keys) {
+		k := it.keys[it.i]
+		it.i++
+		elem, _ := it.x.MapVal.Get(k)
+		if elem == nil {
+			continue
+		}
+		if it.single {
+			// a single variable gets the keys.
+			v := *k
+			return nil, &v, true
+		}
+		v := *elem
+		return k, &v, true
+	}
+	return nil, nil, false
+}
+
+// switchStmt runs the first clause of node whose values match, or
+// else the default clause, and the clauses it falls through to. Each
+// clause runs in its own scope, holding the names its pattern bound.
+func (c *EvalCtx) switchStmt(node *ir.SwitchStmt) completion {
+	var tag *Var
+	if node.Tag != nil {
+		tag = c.value(node.Tag)
+	}
+	run := -1
+	for i, clause := range node.Cases {
+		if clause.Values == nil {
+			continue
+		}
+		for _, value := range clause.Values {
+			c.pushFrame(clause.Frame)
+			if c.caseMatches(value, tag) {
+				run = i
+				break
 			}
-			if c.Result[0].Type != BOOL {
-				panic("error result")
+			c.popFrame(clause.Frame)
+		}
+		if run >= 0 {
+			break
+		}
+	}
+	if run < 0 {
+		for i, clause := range node.Cases {
+			if clause.Values == nil {
+				run = i
+				c.pushFrame(clause.Frame)
 			}
-			if !c.Result[0].BoolVal {
+		}
+		if run < 0 {
+			return completion{}
+		}
+	}
+	for {
+		var cmp completion
+		for _, stmt := range node.Cases[run].Body {
+			if cmp = c.exec(stmt); cmp.kind != normal {
 				break
 			}
-			c = EvalNode(c, node.Body, nil)
-			if c.isBreak {
-				c.isBreak = false
-				break
+		}
+		c.popFrame(node.Cases[run].Frame)
+		if cmp.kind == breakCompletion && (cmp.label == "" || cmp.label == node.Label) {
+			return completion{}
+		}
+		if cmp.kind != normal || !node.Cases[run].Fallthrough {
+			return cmp
+		}
+		run++
+		c.pushFrame(node.Cases[run].Frame)
+	}
+}
+
+// caseMatches reports whether a case value matches the switch value
+// tag, or is true when there is no tag.
+func (c *EvalCtx) caseMatches(value ir.Node, tag *Var) bool {
+	if tag == nil {
+		return c.cond(value)
+	}
+	switch value.(type) {
+	case *ir.ListPattern, *ir.MapPattern:
+		return c.match(value, tag)
+	}
+	return Equal(c.value(value), tag)
+}
+
+// match reports whether v matches pattern, binding the names in the
+// pattern in the current frame. A name _ matches anything and binds
+// nothing.
+func (c *EvalCtx) match(pattern ir.Node, v *Var) bool {
+	switch pattern := pattern.(type) {
+	case *ir.Name:
+		if pattern.Name != "_" {
+			bound := *v
+			bound.Name = pattern.Name
+			c.env.slots[pattern.Ref.Slot] = &bound
+		}
+		return true
+	case *ir.ListPattern:
+		if v.Type != LIST {
+			return false
+		}
+		elems := v.ListVal.Elems
+		if len(elems) < len(pattern.Elems) || pattern.Rest == "" && len(elems) != len(pattern.Elems) {
+			return false
+		}
+		for i, elem := range pattern.Elems {
+			if !c.match(elem, elems[i]) {
+				return false
 			}
-			if c.isContinue {
-				c.isContinue = false
+		}
+		if pattern.Rest != "" {
+			rest := &List{}
+			for _, elem := range elems[len(pattern.Elems):] {
+				e := *elem
+				rest.Elems = append(rest.Elems, &e)
 			}
-			c = EvalNode(c, node.Post, nil)
+			c.env.slots[pattern.RestSlot] = &Var{Name: pattern.Rest, Type: LIST, ListVal: rest}
 		}
-		c.PopScope()
-	case *ir.AssignStmt:
-		for i:=0; i<len(node.Lhs); i++ {
-			_varDef := c.LookupVar(node.Lhs[i])
-			if _varDef == nil {
-				panic(fmt.Sprintf("undefine var %s", node.Lhs[i]))
+		return true
+	case *ir.MapPattern:
+		if v.Type != MAP {
+			return false
+		}
+		for i, key := range pattern.Keys {
+			elem, err := v.MapVal.Get(c.value(key))
+			if err != nil {
+				c.fail(key, err)
+			}
+			if elem == nil || !c.match(pattern.Values[i], elem) {
+				return false
 			}
-			_var, _ := _varDef.(*Var)
-			c = EvalNode(c, node.Rhs[i], nil)
-			*_var = *c.Result[0]
 		}
-	case *ir.ContinueStmt:
-		c.isContinue = true
-	case *ir.BreakStmt:
-		c.isBreak = true
+		return true
+	}
+	return Equal(c.value(pattern), v)
+}
+
+// value evaluates node as an expression yielding exactly one value.
+func (c *EvalCtx) value(node ir.Node) *Var {
+	c = EvalNode(c, node, nil)
+	if len(c.Result) != 1 {
+		c.errorf(node, ErrValueCount, "expression has %d values, want 1", len(c.Result))
+	}
+	return c.Result[0]
+}
+
+// index evaluates node as an index into a list of length n. When
+// slicing, n itself is a valid index too.
+func (c *EvalCtx) index(node ir.Node, n int, slicing bool) int {
+	return c.checkIndex(node, c.value(node), n, slicing)
+}
+
+// checkIndex checks that v, the value of node, is an index into a
+// list of length n, as index does.
+func (c *EvalCtx) checkIndex(node ir.Node, v *Var, n int, slicing bool) int {
+	if v.Type != NUM {
+		c.errorf(node, ErrType, "invalid index type %v", v.Type)
+	}
+	if v.NumVal != math.Trunc(v.NumVal) {
+		c.errorf(node, ErrIndex, "invalid index %g (not an integer)", v.NumVal)
+	}
+	limit := n
+	if slicing {
+		limit++
+	}
+	if v.NumVal < 0 || v.NumVal >= float64(limit) {
+		c.errorf(node, ErrIndex, "index out of range [%g] with length %d", v.NumVal, n)
+	}
+	return int(v.NumVal)
+}
+
+// values evaluates the right hand side exprs of an assignment to n
+// variables at node: n expressions, or one yielding n values. The
+// values are copies, so assigning one never changes another.
+func (c *EvalCtx) values(node ir.Node, exprs []ir.Node, n int) []*Var {
+	var values []*Var
+	if len(exprs) == 1 {
+		c = EvalNode(c, exprs[0], nil)
+		values = c.Result
+	} else {
+		for _, expr := range exprs {
+			values = append(values, c.value(expr))
+		}
+	}
+	if len(values) != n {
+		c.errorf(node, ErrValueCount, "assignment mismatch: %s but %s", plural(n, "variable"), plural(len(values), "value"))
+	}
+	copies := make([]*Var, n)
+	for i, v := range values {
+		cp := *v
+		copies[i] = &cp
+	}
+	return copies
+}
+
+// plural returns n and noun, in the plural unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// target evaluates the operands of target, a name, a list or map
+// element or a struct field, and returns the func storing a value
+// in it.
+func (c *EvalCtx) target(target ir.Node) (load func() *Var, store func(v *Var)) {
+	switch target := target.(type) {
+	case *ir.Name:
+		_var := c.variable(c.env, target)
+		return func() *Var {
+				return _var
+			}, func(v *Var) {
+				*_var = *v
+			}
+	case *ir.IndexExpr:
+		x := c.value(target.X)
+		switch x.Type {
+		case LIST:
+			list := x.ListVal
+			i := c.index(target.Index, len(list.Elems), false)
+			return func() *Var {
+					return list.Elems[i]
+				}, func(v *Var) {
+					elem := *v
+					list.Elems[i] = &elem
+				}
+		case MAP:
+			m, k := x.MapVal, *c.value(target.Index)
+			return func() *Var {
+					v, err := m.Get(&k)
+					if err != nil {
+						c.fail(target.Index, err)
+					}
+					if v == nil {
+						v = &Var{Type: NIL}
+					}
+					return v
+				}, func(v *Var) {
+					if err := m.Set(&k, v); err != nil {
+						c.fail(target.Index, err)
+					}
+				}
+		default:
+			c.errorf(target.X, ErrType, "cannot index %v", x.Type)
+		}
+	case *ir.SelectorExpr:
+		s, i := c.field(target)
+		return func() *Var {
+				return s.Fields[i]
+			}, func(v *Var) {
+				elem := *v
+				s.Fields[i] = &elem
+			}
+	}
+	panic("unknown assign target")
+}
+
+func (c *EvalCtx) cond(node ir.Node) bool {
+	v := c.value(node)
+	if v.Type != BOOL {
+		c.errorf(node, ErrType, "non-bool condition (%v)", v.Type)
+	}
+	return v.BoolVal
+}
+
+// pushFrame enters a scope with a frame of n slots. A scope
+// declaring no names has none.
+func (c *EvalCtx) pushFrame(n int) {
+	if n > 0 {
+		c.env = &env{slots: make([]*Var, n), parent: c.env}
+	}
+}
+
+// popFrame leaves the scope entered by pushFrame(n).
+func (c *EvalCtx) popFrame(n int) {
+	if n > 0 {
+		c.env = c.env.parent
+	}
+}
+
+// variable returns the variable n refers to from the frame e. It
+// is nil until its declaration has run, as for a global used by
+// a var declared above it, or a local a closure is called before.
+func (c *EvalCtx) variable(e *env, n *ir.Name) *Var {
+	var v *Var
+	if n.Ref.Global {
+		v = c.globals[n.Ref.Slot]
+	} else {
+		v = e.up(n.Ref.Depth).slots[n.Ref.Slot]
+	}
+	if v == nil {
+		c.errorf(n, ErrUndefined, "undefined: %s", n.Name)
+	}
+	return v
+}
+
+// checkFunc checks that fn, the func node calls, is a func.
+func (c *EvalCtx) checkFunc(node *ir.CallExpr, fn *Var) {
+	if fn.Type != FUNC {
+		funcName := "expression"
+		if name, ok := node.Fun.(*ir.Name); ok {
+			funcName = name.Name
+		}
+		c.errorf(node, ErrNotFunc, "call of non-function %s (%v)", funcName, fn.Type)
+	}
+}
+
+// callValue calls fn from site, whether it is a builtin, a func of
+// the VM or one of the walker. Its results are left in c.Result.
+func (c *EvalCtx) callValue(site ir.Node, fn *Var, args []*Var) {
+	switch {
+	case fn.BuiltIn != nil:
+		c.callBuiltIn(site, fn, args)
+	case fn.proto != nil:
+		c.Result = c.vm.call(site, fn, args)
+	default:
+		c.callFunc(site, fn, args)
+	}
+}
+
+// callFunc calls the toylang function fn from site. The body runs
+// in a new frame on top of the one fn was defined in.
+func (c *EvalCtx) callFunc(site ir.Node, fn *Var, args []*Var) {
+	f := fn.Func.(*ir.Func)
+	c.checkArgs(site, f, len(args))
+	c.checkDepth(site, f, c.depth)
+	if fn.Recv != nil {
+		args = append([]*Var{fn.Recv}, args...)
+	}
+	caller := c.env
+	c.env = fn.env
+	c.depth++
+	c = EvalNode(c, f, args)
+	c.depth--
+	c.env = caller
+}
+
+// checkArgs checks that f can be called with n arguments.
+func (c *EvalCtx) checkArgs(site ir.Node, f *ir.Func, n int) {
+	fixed := len(f.Args)
+	if f.Variadic {
+		fixed--
+		if n < fixed {
+			c.errorf(site, ErrArgCount, "call %s: want at least %d args, got %d", f.FuncName, fixed, n)
+		}
+	} else if n != fixed {
+		c.errorf(site, ErrArgCount, "call %s: want %d args, got %d", f.FuncName, fixed, n)
+	}
+}
+
+// checkDepth checks that f can be called with depth calls running,
+// so that a program recursing without end fails instead of using up
+// the stack.
+func (c *EvalCtx) checkDepth(site ir.Node, f *ir.Func, depth int) {
+	if depth >= c.maxDepth {
+		c.errorf(site, ErrDepth, "call %s: more than %d calls deep", f.FuncName, c.maxDepth)
 	}
-	return c
 }
 
-func (c *EvalCtx) PushScope() {
-	curScope := &Scope{
-		Parent:	c.Scope,
-		Def:    make(map[string]Def),
+// bindArgs puts the parameters of f in the first slots of its frame.
+// Every argument is copied, so assigning to a parameter never changes
+// the caller's variable. The argument count was checked by callFunc,
+// which passes the receiver of a method as the first argument.
+func bindArgs(slots []*Var, f *ir.Func, args []*Var) {
+	if f.Recv != "" {
+		recv := *args[0]
+		recv.Name = f.Recv
+		slots[0] = &recv
+		slots, args = slots[1:], args[1:]
+	}
+	fixed := len(f.Args)
+	if f.Variadic {
+		fixed--
+	}
+	for i := 0; i < fixed; i++ {
+		arg := *args[i]
+		arg.Name = f.Args[i]
+		slots[i] = &arg
+	}
+	if f.Variadic {
+		rest := &List{}
+		for _, arg := range args[fixed:] {
+			elem := *arg
+			rest.Elems = append(rest.Elems, &elem)
+		}
+		name := f.Args[fixed]
+		slots[fixed] = &Var{Name: name, Type: LIST, ListVal: rest}
 	}
-	c.Scope = curScope
 }
 
-func (c *EvalCtx) PopScope() {
-	c.Scope = c.Scope.Parent
+// logicalOp evaluates && and ||, which only evaluate their right
+// operand when the left one does not decide the result.
+func (c *EvalCtx) logicalOp(node *ir.BinaryExpr) *Var {
+	left := c.value(node.Lhs)
+	if left.Type != BOOL {
+		c.errorf(node.Lhs, ErrType, "invalid operation: operator %v not defined on %v", node.Op, left.Type)
+	}
+	if left.BoolVal == (node.Op == syntax.OpOR) {
+		return &Var{Type: BOOL, BoolVal: left.BoolVal}
+	}
+	right := c.value(node.Rhs)
+	if right.Type != BOOL {
+		c.errorf(node.Rhs, ErrType, "invalid operation: operator %v not defined on %v", node.Op, right.Type)
+	}
+	return &Var{Type: BOOL, BoolVal: right.BoolVal}
 }
 
-func GetBinaryOpResult(op syntax.Op, leftVar *Var, rightVar *Var) *Var {
+// GetUnaryOpResult applies the prefix operator op to x.
+func GetUnaryOpResult(op syntax.Op, x *Var) (*Var, error) {
+	switch {
+	case op == syntax.OpMINUS && x.Type == NUM:
+		return &Var{Type: NUM, NumVal: -x.NumVal}, nil
+	case op == syntax.OpPLUS && x.Type == NUM:
+		return &Var{Type: NUM, NumVal: x.NumVal}, nil
+	case op == syntax.OpNOT && x.Type == BOOL:
+		return &Var{Type: BOOL, BoolVal: !x.BoolVal}, nil
+	}
+	return nil, &RuntimeError{
+		Code: ErrType,
+		Msg:  fmt.Sprintf("invalid operation: operator %v not defined on %v", op, x.Type),
+	}
+}
+
+// GetBinaryOpResult applies op to its operands. The returned error
+// carries no position; the caller knows where the expression is.
+func GetBinaryOpResult(op syntax.Op, leftVar *Var, rightVar *Var) (*Var, error) {
+	switch op {
+	case syntax.OpEQ:
+		return &Var{BoolVal: Equal(leftVar, rightVar), Type: BOOL}, nil
+	case syntax.OpNEQ:
+		return &Var{BoolVal: !Equal(leftVar, rightVar), Type: BOOL}, nil
+	}
+	if leftVar.Type != rightVar.Type {
+		return nil, opError(op, leftVar, rightVar)
+	}
 	switch op {
 	case syntax.OpPLUS:
-		if leftVar.Type != rightVar.Type {
-			panic("type not equal")
-		}
 		switch leftVar.Type {
 		case NUM:
 			return &Var{
 				NumVal:    leftVar.NumVal + rightVar.NumVal,
 				Type:      NUM,
-			}
+			}, nil
 		case STRING:
 			return &Var{
 				StringVal: leftVar.StringVal + rightVar.StringVal,
 				Type:      STRING,
-			}
-		default:
-			panic("error type +")
+			}, nil
 		}
 	case syntax.OpMINUS:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal in minus")
-		}
-		return &Var{
-			NumVal: leftVar.NumVal - rightVar.NumVal,
-			Type:      NUM,
+		if leftVar.Type == NUM {
+			return &Var{
+				NumVal: leftVar.NumVal - rightVar.NumVal,
+				Type:   NUM,
+			}, nil
 		}
 	case syntax.OpMUL:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal mul")
-		}
-		return &Var{
-			NumVal: leftVar.NumVal * rightVar.NumVal,
-			Type:      NUM,
+		if leftVar.Type == NUM {
+			return &Var{
+				NumVal: leftVar.NumVal * rightVar.NumVal,
+				Type:   NUM,
+			}, nil
 		}
 	case syntax.OpDiv:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal div")
-		}
-		return &Var{
-			NumVal: leftVar.NumVal / rightVar.NumVal,
-			Type:      NUM,
-		}
-	case syntax.OpEQ:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal div")
-		}
-		return &Var{
-			BoolVal: leftVar.NumVal == rightVar.NumVal,
-			Type:      BOOL,
-		}
-	case syntax.OpLEQ:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal div")
-		}
-		return &Var{
-			BoolVal: leftVar.NumVal <= rightVar.NumVal,
-			Type:      BOOL,
-		}
-	case syntax.OpLT:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal div")
+		if leftVar.Type == NUM {
+			return &Var{
+				NumVal: leftVar.NumVal / rightVar.NumVal,
+				Type:   NUM,
+			}, nil
 		}
-		return &Var{
-			BoolVal: leftVar.NumVal < rightVar.NumVal,
-			Type:      BOOL,
+	case syntax.OpMOD:
+		if leftVar.Type == NUM {
+			return &Var{
+				NumVal: math.Mod(leftVar.NumVal, rightVar.NumVal),
+				Type:   NUM,
+			}, nil
 		}
-	case syntax.OpGEQ:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal div")
+	case syntax.OpLEQ, syntax.OpLT, syntax.OpGEQ, syntax.OpGT:
+		var cmp int
+		switch leftVar.Type {
+		case NUM:
+			switch {
+			case leftVar.NumVal < rightVar.NumVal:
+				cmp = -1
+			case leftVar.NumVal > rightVar.NumVal:
+				cmp = 1
+			case leftVar.NumVal != rightVar.NumVal:
+				// NaN is unordered: every comparison is false.
+				return &Var{Type: BOOL}, nil
+			}
+		case STRING:
+			cmp = strings.Compare(leftVar.StringVal, rightVar.StringVal)
+		default:
+			return nil, opError(op, leftVar, rightVar)
 		}
-		return &Var{
-			BoolVal: leftVar.NumVal >= rightVar.NumVal,
-			Type:      BOOL,
+		var ok bool
+		switch op {
+		case syntax.OpLEQ:
+			ok = cmp <= 0
+		case syntax.OpLT:
+			ok = cmp < 0
+		case syntax.OpGEQ:
+			ok = cmp >= 0
+		case syntax.OpGT:
+			ok = cmp > 0
 		}
-	case syntax.OpGT:
-		if leftVar.Type != rightVar.Type || leftVar.Type != NUM  {
-			panic("type not equal div")
+		return &Var{BoolVal: ok, Type: BOOL}, nil
+	}
+	return nil, opError(op, leftVar, rightVar)
+}
+
+// Equal reports whether l and r are equal. Values of different
+// types are never equal; funcs, lists, maps and structs compare by
+// identity.
+func Equal(l, r *Var) bool {
+	if l.Type != r.Type {
+		return false
+	}
+	switch l.Type {
+	case NUM:
+		return l.NumVal == r.NumVal
+	case STRING:
+		return l.StringVal == r.StringVal
+	case BOOL:
+		return l.BoolVal == r.BoolVal
+	case NIL:
+		return true
+	case FUNC:
+		if l.BuiltIn != nil || r.BuiltIn != nil {
+			return l.BuiltIn != nil && r.BuiltIn != nil &&
+				reflect.ValueOf(l.BuiltIn).Pointer() == reflect.ValueOf(r.BuiltIn).Pointer()
 		}
-		return &Var{
-			BoolVal: leftVar.NumVal > rightVar.NumVal,
-			Type:      BOOL,
+		if l.Recv != nil || r.Recv != nil {
+			return l.Recv != nil && r.Recv != nil &&
+				l.Func == r.Func && Equal(l.Recv, r.Recv)
 		}
+		return l.Func == r.Func && l.env == r.env
+	case LIST:
+		return l.ListVal == r.ListVal
+	case MAP:
+		return l.MapVal == r.MapVal
+	case STRUCT:
+		return l.StructVal == r.StructVal
+	case TYPE:
+		return l.TypeVal == r.TypeVal
 	}
-	return nil
+	return false
 }
 
-func (c *EvalCtx) LookupVar(name string) Def {
-	for scope := c.Scope; scope != nil; scope = scope.Parent {
-		if v, ok := scope.Def[name]; ok {
-			return v
-		}
+func opError(op syntax.Op, leftVar *Var, rightVar *Var) *RuntimeError {
+	return &RuntimeError{
+		Code: ErrType,
+		Msg:  fmt.Sprintf("invalid operation: %v %v %v", leftVar.Type, op, rightVar.Type),
 	}
-	return nil
 }
\ No newline at end of file
@@ -0,0 +1,246 @@
+package eval
+
+import (
+	"fmt"
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+	"github.com/cuiweixie/toylang/types"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// Interpreter runs a program for a Go host. The values and funcs the
+// host defines are globals of the program, next to the builtins;
+// the program's own declarations are run by the first Run, Call or
+// Get and win over host names they redeclare.
+type Interpreter struct {
+	c       *EvalCtx
+	nodes   []ir.Node
+	engine  Engine
+	passes  *ir.PassManager
+	loaded  bool
+	loadErr error
+
+	resolved   bool
+	resolveErr error
+}
+
+// Engine is how an Interpreter runs the program. Both run it with
+// the same results and errors.
+type Engine int
+
+const (
+	// Walker evaluates the ir tree of the program as it goes.
+	Walker Engine = iota
+	// VM compiles the program to bytecode and runs it on a
+	// stack machine, which is faster.
+	VM
+)
+
+// New parses src, which name only names in positions. Syntax errors
+// are returned as a syntax.ErrorList.
+func New(name string, src []byte) (*Interpreter, error) {
+	file, err := syntax.Parse(name, src)
+	if err != nil {
+		return nil, err
+	}
+	scope := &Scope{
+		Parent: nil,
+		Def:    make(map[string]Def),
+	}
+	registGlobalBultin(scope)
+	return &Interpreter{c: NewEvalCtx(scope), nodes: ir.GenAst(file)}, nil
+}
+
+// NewReader is like New but reads the source from r.
+func NewReader(name string, r io.Reader) (*Interpreter, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return New(name, src)
+}
+
+// Define makes value, converted by ToVar, a global of the program.
+// A Go func is wrapped by WrapFunc, and its errors name it name.
+func (in *Interpreter) Define(name string, value interface{}) error {
+	var v *Var
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Func && rv.Type() != builtInType && !rv.IsNil() {
+		v = wrapFunc(name, rv)
+	} else {
+		var err error
+		if v, err = ToVar(value); err != nil {
+			return err
+		}
+	}
+	in.c.setGlobal(name, v)
+	return nil
+}
+
+// DefineFunc makes fn a global func of the program. Like a builtin,
+// fn leaves its results in c.Result and reports errors with c.Error.
+func (in *Interpreter) DefineFunc(name string, fn func(c *EvalCtx, args []*Var)) {
+	in.c.setGlobal(name, &Var{Type: FUNC, BuiltIn: fn})
+}
+
+// SetEngine sets the engine running the program, Walker unless set.
+// It must be called before the first Run, Call or Get.
+func (in *Interpreter) SetEngine(e Engine) {
+	in.engine = e
+}
+
+// SetPasses sets the passes the program is optimized by before it
+// runs, none unless set. It must be called before the first Run,
+// Call or Get.
+func (in *Interpreter) SetPasses(pm *ir.PassManager) {
+	in.passes = pm
+}
+
+// IR returns the ir tree of the program, its names resolved, its
+// types checked and the passes run, once, as Run would run it. Names
+// that are undefined or declared twice, and type errors, are
+// reported as a syntax.ErrorList.
+func (in *Interpreter) IR() ([]ir.Node, error) {
+	if in.resolved {
+		if in.resolveErr != nil {
+			return nil, in.resolveErr
+		}
+		return in.nodes, nil
+	}
+	in.resolved = true
+	if in.resolveErr = in.c.resolve(in.nodes); in.resolveErr != nil {
+		return nil, in.resolveErr
+	}
+	if in.resolveErr = types.Check(in.nodes); in.resolveErr != nil {
+		return nil, in.resolveErr
+	}
+	if in.passes != nil {
+		// the passes run on a program known to be well formed, and
+		// the slots of what they leave are given anew.
+		in.nodes = in.passes.Run(in.nodes)
+		if in.resolveErr = in.c.resolve(in.nodes); in.resolveErr != nil {
+			return nil, in.resolveErr
+		}
+	}
+	return in.nodes, nil
+}
+
+// load resolves the names of the program, checks its types and runs
+// its declarations, once. Names that are undefined or declared
+// twice, and type errors, are reported as a syntax.ErrorList before
+// anything runs.
+func (in *Interpreter) load() (err error) {
+	if in.loaded {
+		return in.loadErr
+	}
+	in.loaded = true
+	defer func() {
+		in.loadErr = err
+	}()
+	if _, err := in.IR(); err != nil {
+		return err
+	}
+	defer recoverError(&err)
+	if in.engine == VM {
+		in.c.loadVM(in.nodes)
+	} else {
+		in.c.loadNodes(in.nodes)
+	}
+	return nil
+}
+
+// DefaultMaxDepth is how many calls deep a program may run unless
+// SetMaxDepth says otherwise.
+const DefaultMaxDepth = 10000
+
+// SetMaxDepth sets how many calls deep the program may run. A call
+// any deeper fails with a RuntimeError of code ErrDepth.
+func (in *Interpreter) SetMaxDepth(n int) {
+	in.c.maxDepth = n
+}
+
+// SetOutput sets where the print builtin writes, os.Stdout unless
+// set.
+func (in *Interpreter) SetOutput(w io.Writer) {
+	in.c.out = w
+}
+
+// Run calls the main func of the program.
+func (in *Interpreter) Run() error {
+	if err := in.load(); err != nil {
+		return err
+	}
+	_, err := in.call("main", nil)
+	return err
+}
+
+// Call calls the global func name with args, each converted by
+// ToVar, and returns its results converted by FromVar.
+func (in *Interpreter) Call(name string, args ...interface{}) ([]interface{}, error) {
+	if err := in.load(); err != nil {
+		return nil, err
+	}
+	vars := make([]*Var, len(args))
+	for i, arg := range args {
+		v, err := ToVar(arg)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: argument %d: %v", name, i+1, err)
+		}
+		vars[i] = v
+	}
+	vars, err := in.call(name, vars)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(vars))
+	for i, v := range vars {
+		results[i] = FromVar(v)
+	}
+	return results, nil
+}
+
+// call calls the global func name. It may be called again by a host
+// func the program calls, so the state of the running call is put
+// back when it returns or fails.
+func (in *Interpreter) call(name string, args []*Var) (results []*Var, err error) {
+	c := in.c
+	global := c.global()
+	fn, ok := global.Def[name].(*Var)
+	if !ok || fn.Type != FUNC {
+		return nil, &RuntimeError{Code: ErrUndefined, Msg: fmt.Sprintf("func %s undefined", name)}
+	}
+	e, result, depth := c.env, c.Result, c.depth
+	defer func() {
+		c.env, c.Result, c.depth = e, result, depth
+	}()
+	defer recoverError(&err)
+	c.callValue(nil, fn, args)
+	return c.Result, nil
+}
+
+// Get returns the global name, converted by FromVar.
+func (in *Interpreter) Get(name string) (interface{}, error) {
+	if err := in.load(); err != nil {
+		return nil, err
+	}
+	v, ok := in.c.global().Def[name].(*Var)
+	if !ok {
+		return nil, &RuntimeError{Code: ErrUndefined, Msg: fmt.Sprintf("undefined: %s", name)}
+	}
+	return FromVar(v), nil
+}
+
+// global returns the outermost scope.
+func (c *EvalCtx) global() *Scope {
+	scope := c.Scope
+	for scope.Parent != nil {
+		scope = scope.Parent
+	}
+	return scope
+}
+
+// Error aborts the host func or builtin being run with err.
+func (c *EvalCtx) Error(err error) {
+	c.fail(nil, err)
+}
@@ -0,0 +1,119 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hostSrc = `
+var greeting = "hi " + name
+func double(x) { return x * 2 }
+func divmod(a, b) { return (a - a % b) / b, a % b }
+func sum(xs) {
+    var t = 0
+    for _, x in xs { t += x }
+    return t
+}
+func bad() { return [1][3] }
+func viaHost(n) { return twice(n) + 1 }
+func get(m, k) { return m[k] }
+`
+
+func newInterp(t *testing.T, src string) *Interpreter {
+	in, err := New("host.toy", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestInterpreter(t *testing.T) {
+	in := newInterp(t, hostSrc)
+	if err := in.Define("name", "go"); err != nil {
+		t.Fatal(err)
+	}
+	var innerErr error
+	in.DefineFunc("twice", func(c *EvalCtx, args []*Var) {
+		// a host func may call back into the program, and a call
+		// that fails leaves the one running as it was.
+		_, innerErr = in.Call("bad")
+		r, err := in.Call("double", args[0])
+		if err != nil {
+			c.Error(err)
+		}
+		c.Result = []*Var{{Type: NUM, NumVal: r[0].(float64)}}
+	})
+	tests := []struct {
+		name string
+		args []interface{}
+		want []interface{}
+		err  string
+	}{
+		{"double", []interface{}{21}, []interface{}{42.0}, ""},
+		{"divmod", []interface{}{7, 2.0}, []interface{}{3.0, 1.0}, ""},
+		{"sum", []interface{}{[]interface{}{1, 2, 3.5}}, []interface{}{6.5}, ""},
+		{"get", []interface{}{map[string]interface{}{"k": []interface{}{true, nil}}, "k"}, []interface{}{[]interface{}{true, nil}}, ""},
+		{"viaHost", []interface{}{5}, []interface{}{11.0}, ""},
+		{"double", nil, nil, "call double: want 1 args, got 0"},
+		{"double", []interface{}{make(chan int)}, nil, "call double: argument 1: cannot convert chan int to a toylang value"},
+		{"bad", nil, nil, "host.toy:10:25: index out of range [3] with length 1"},
+		{"nope", nil, nil, "func nope undefined"},
+	}
+	for _, test := range tests {
+		got, err := in.Call(test.name, test.args...)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s%v: got error %v, want %s", test.name, test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s%v: got %v, %v, want %v", test.name, test.args, got, err, test.want)
+		}
+	}
+	var re *RuntimeError
+	if !errors.As(innerErr, &re) || re.Code != ErrIndex {
+		t.Errorf("inner call: got %v, want an ErrIndex", innerErr)
+	}
+	if g, err := in.Get("greeting"); err != nil || g != "hi go" {
+		t.Errorf("greeting: got %v, %v", g, err)
+	}
+	if _, err := in.Get("missing"); err == nil {
+		t.Errorf("missing: got no error")
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	if _, err := New("host.toy", []byte("func f( {}")); err == nil || !strings.HasPrefix(err.Error(), "host.toy:1:9:") {
+		t.Errorf("New: got %v, want a syntax error", err)
+	}
+	in := newInterp(t, "var x = 1 / nope\nfunc main() {}")
+	for i := 0; i < 2; i++ {
+		if err := in.Run(); err == nil || err.Error() != "host.toy:1:13: undefined: nope" {
+			t.Errorf("Run %d: got %v", i, err)
+		}
+	}
+	in = newInterp(t, "func f(x) { print(x) }")
+	if err := in.Run(); err == nil || err.Error() != "func main undefined" {
+		t.Errorf("Run without main: got %v", err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	in := newInterp(t, "func down(n) { if n == 0 { return 0 }; return down(n - 1) + 1 }")
+	in.SetMaxDepth(50)
+	if r, err := in.Call("down", 49); err != nil || r[0] != 49.0 {
+		t.Errorf("down(49): got %v, %v", r, err)
+	}
+	_, err := in.Call("down", 50)
+	var re *RuntimeError
+	if !errors.As(err, &re) || re.Code != ErrDepth || re.Msg != "call down: more than 50 calls deep" {
+		t.Errorf("down(50): got %v", err)
+	}
+	if r, err := in.Call("down", 3); err != nil || fmt.Sprint(r) != "[3]" {
+		t.Errorf("down(3) after the error: got %v, %v", r, err)
+	}
+}
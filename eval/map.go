@@ -0,0 +1,136 @@
+package eval
+
+import "fmt"
+
+// Map is shared by every Var holding it, like List. It remembers
+// the order its keys were added in, so iterating over it is
+// deterministic.
+//
+// Delete leaves a tombstone, an entry with a nil Key, so the index
+// of the entries after it stays valid. The tombstones are dropped
+// once they outnumber the keys, or when the entries are walked.
+type Map struct {
+	entries []mapEntry
+	index   map[mapKey]int
+	dead    int
+}
+
+type mapEntry struct {
+	Key, Val *Var
+}
+
+// mapKey is the comparable form of a key Var.
+type mapKey struct {
+	Type VarType
+	num  float64
+	str  string
+	b    bool
+}
+
+func NewMap() *Map {
+	return &Map{index: make(map[mapKey]int)}
+}
+
+// keyOf returns the mapKey for k; only numbers, strings and bools
+// can be keys.
+func keyOf(k *Var) (mapKey, error) {
+	switch k.Type {
+	case NUM:
+		return mapKey{Type: NUM, num: k.NumVal}, nil
+	case STRING:
+		return mapKey{Type: STRING, str: k.StringVal}, nil
+	case BOOL:
+		return mapKey{Type: BOOL, b: k.BoolVal}, nil
+	}
+	return mapKey{}, &RuntimeError{Code: ErrType, Msg: fmt.Sprintf("invalid map key type %v", k.Type)}
+}
+
+// Get returns the value for k, or nil if k is not in m.
+func (m *Map) Get(k *Var) (*Var, error) {
+	key, err := keyOf(k)
+	if err != nil {
+		return nil, err
+	}
+	if i, ok := m.index[key]; ok {
+		return m.entries[i].Val, nil
+	}
+	return nil, nil
+}
+
+// Set stores a copy of v for k. A new key goes after the others.
+func (m *Map) Set(k, v *Var) error {
+	key, err := keyOf(k)
+	if err != nil {
+		return err
+	}
+	val := *v
+	if i, ok := m.index[key]; ok {
+		m.entries[i].Val = &val
+		return nil
+	}
+	kc := *k
+	m.index[key] = len(m.entries)
+	m.entries = append(m.entries, mapEntry{Key: &kc, Val: &val})
+	return nil
+}
+
+// Delete removes k from m, if it is there.
+func (m *Map) Delete(k *Var) error {
+	key, err := keyOf(k)
+	if err != nil {
+		return err
+	}
+	i, ok := m.index[key]
+	if !ok {
+		return nil
+	}
+	delete(m.index, key)
+	m.entries[i] = mapEntry{}
+	m.dead++
+	if m.dead > len(m.index) {
+		m.compact()
+	}
+	return nil
+}
+
+// compact drops the tombstones from the entries and reindexes the
+// ones that move.
+func (m *Map) compact() {
+	live := m.entries[:0]
+	for _, e := range m.entries {
+		if e.Key == nil {
+			continue
+		}
+		key, _ := keyOf(e.Key)
+		m.index[key] = len(live)
+		live = append(live, e)
+	}
+	for i := len(live); i < len(m.entries); i++ {
+		m.entries[i] = mapEntry{}
+	}
+	m.entries = live
+	m.dead = 0
+}
+
+// live returns the entries of m in the order they were added. It
+// compacts them first, which costs no more than walking them.
+func (m *Map) live() []mapEntry {
+	if m.dead > 0 {
+		m.compact()
+	}
+	return m.entries
+}
+
+func (m *Map) Len() int {
+	return len(m.index)
+}
+
+// Keys returns the keys of m in the order they were added.
+func (m *Map) Keys() []*Var {
+	keys := make([]*Var, m.Len())
+	for i, e := range m.live() {
+		k := *e.Key
+		keys[i] = &k
+	}
+	return keys
+}
@@ -0,0 +1,53 @@
+package eval
+
+import "testing"
+
+func num(n float64) *Var {
+	return &Var{Type: NUM, NumVal: n}
+}
+
+// TestMapDelete deletes keys in the ways a loop does and checks that
+// the rest keep their order and the tombstones do not pile up.
+func TestMapDelete(t *testing.T) {
+	m := NewMap()
+	const n = 1000
+	for i := 0; i < n; i++ {
+		m.Set(num(float64(i)), num(float64(i*i)))
+	}
+	for i := 0; i < n; i += 2 {
+		m.Delete(num(float64(i)))
+		if len(m.entries) > 2*m.Len()+1 {
+			t.Fatalf("after deleting %d: %d entries for %d keys", i, len(m.entries), m.Len())
+		}
+	}
+	m.Delete(num(1))
+	m.Set(num(0), num(-1))
+	m.Set(num(3), num(-3))
+	keys := m.Keys()
+	if len(keys) != n/2 || m.Len() != n/2 {
+		t.Fatalf("got %d keys, Len %d, want %d", len(keys), m.Len(), n/2)
+	}
+	for i, k := range keys[:len(keys)-1] {
+		if want := float64(2*i + 3); k.NumVal != want {
+			t.Fatalf("key %d is %v, want %v", i, k.NumVal, want)
+		}
+	}
+	if last := keys[len(keys)-1]; last.NumVal != 0 {
+		t.Errorf("last key %v, want 0, which was added again", last.NumVal)
+	}
+	for _, k := range []float64{0, 3, 5, 1, 2} {
+		v, _ := m.Get(num(k))
+		var want *Var
+		switch k {
+		case 0:
+			want = num(-1)
+		case 3:
+			want = num(-3)
+		case 5:
+			want = num(25)
+		}
+		if (v == nil) != (want == nil) || v != nil && v.NumVal != want.NumVal {
+			t.Errorf("Get(%v) = %v, want %v", k, v, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package eval
+
+// opcode is a VM instruction. The comments give the operands and
+// what the instruction does to the stack.
+type opcode uint8
+
+const (
+	_ opcode = iota
+
+	opConst // a: push consts[a]
+	opPop   // pop one value
+	opPopN  // a: pop a values
+	opDup   // push the top value again
+	opPick  // a: push the value a below the top
+
+	opLocal        // a, b: push slot b of the env a levels up
+	opStoreLocal   // a, b: pop into slot b of the env a levels up
+	opDefine       // a, b: pop into a new variable names[b] in slot a
+	opGlobal       // a: push the global a
+	opCheckGlobal  // a: check that the global a has been declared
+	opStoreGlobal  // a: pop into the global a
+	opDefineGlobal // a: pop into a new global names[a]
+	opPushEnv      // a: enter an env of a slots
+	opPopEnv       // a: leave a envs
+
+	opJump             // a: go to a
+	opJumpIfNot        // a: pop a bool, go to a if it is false
+	opJumpIfNotCompare // a, b: pop two values, go to a unless the comparison b holds
+	opLogic            // a, b: go to a if the bool on top decides the && or || b, else pop it
+	opCheckBool        // a: check the right operand of the && or || a
+
+	opUnary  // a: apply the operator a to the top value
+	opBinary // a: pop two values, push the result of the operator a
+	opEqual  // pop two values, push whether they are equal
+
+	opList       // a: pop a values, push a list of them
+	opMap        // push an empty map
+	opMapSet     // pop a key and a value into the map below them
+	opStruct     // pop a type, push a struct of it
+	opFieldIndex // a: check that the struct on top has a field names[a]
+	opInitField  // a, b: pop into field a, or field names[b] if a < 0, of the struct below
+	opSelect     // a: pop x, push x.names[a]
+	opIndex      // pop x and k, push x[k]
+	opSlice      // a: pop x and the bounds given by a, push the slice
+
+	opCheckIndex // check the list or map and index below the top as an assign target
+	opIndexLoad  // pop x and k, push the element x[k] is assigned to
+	opSetIndex   // pop x, k and a value, set x[k]
+	opCheckField // a: check the struct on top has a field names[a]
+	opField      // a: pop x, push its field names[a] itself
+	opSetField   // a: pop x and a value, set x.names[a]
+
+	opCheckFunc // check that the top value is a func
+	opCall      // a, b: call with a args, or those above the mark if a < 0, keeping b results
+	opReturn    // a: return a values, or those above the mark if a < 0
+	opClosure   // a: push a closure of protos[a] over the current env
+	opMark      // mark the top of the stack
+	opDropMark  // forget the last mark
+	opTruncate  // pop every value above the last mark, and the mark
+	opUnpack    // a: check that a values are above the mark, copy them and drop the mark
+
+	opIter    // pop x, start a for-in loop over it
+	opNext    // a, b: go to a when the loop is over, else push the value and, unless b is 1, the key
+	opPopIter // a: end a loops
+
+	opMatchList // a, b: push whether the top value is a list of a elements, or more if b is 1
+	opElem      // a: pop a list, push its element a
+	opRest      // a: pop a list, push a copy of its elements from a on
+	opIsMap     // push whether the top value is a map
+	opMapLookup // pop a map and key, push the value and whether it was found
+)
@@ -0,0 +1,141 @@
+package eval
+
+import "github.com/cuiweixie/toylang/ir"
+
+// StructType is the value a type declaration binds its name to.
+type StructType struct {
+	Name   string
+	Fields []string
+	// Methods holds a FUNC Var for each method, not yet bound
+	// to a receiver.
+	Methods map[string]*Var
+}
+
+// Struct is shared by every Var holding it, like List: a method
+// changing a field of its receiver changes it for the caller too.
+type Struct struct {
+	Type   *StructType
+	Fields []*Var
+}
+
+func NewStructType(name string, fields []string) *StructType {
+	return &StructType{Name: name, Fields: fields, Methods: make(map[string]*Var)}
+}
+
+// field returns the index of the named field, or -1.
+func (t *StructType) field(name string) int {
+	for i, f := range t.Fields {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadMethod adds m, the FUNC of the method f, to the receiver type
+// declared in the current scope.
+func (c *EvalCtx) loadMethod(f *ir.Func, m *Var) {
+	def, _ := c.Scope.Def[f.RecvType].(*Var)
+	if def == nil || def.Type != TYPE {
+		c.errorf(f, ErrUndefined, "undefined type %s", f.RecvType)
+	}
+	t := def.TypeVal
+	if t.field(f.FuncName) >= 0 {
+		c.errorf(f, ErrDuplicate, "field and method with the same name %s", f.FuncName)
+	}
+	if _, ok := t.Methods[f.FuncName]; ok {
+		c.errorf(f, ErrDuplicate, "method %s.%s already declared", t.Name, f.FuncName)
+	}
+	m.Name = f.FuncName
+	t.Methods[f.FuncName] = m
+}
+
+// structLit constructs the struct node describes.
+func (c *EvalCtx) structLit(node *ir.StructLit) *Var {
+	s := c.newStruct(node, c.value(node.Type))
+	for i, value := range node.Values {
+		if node.Fields == nil {
+			s.Fields[i] = c.copyOf(value)
+			continue
+		}
+		j := c.fieldIndex(node.Values[i], s.Type, node.Fields[i])
+		s.Fields[j] = c.copyOf(value)
+	}
+	return &Var{Type: STRUCT, StructVal: s}
+}
+
+// copyOf evaluates node and returns a copy of its value.
+func (c *EvalCtx) copyOf(node ir.Node) *Var {
+	v := *c.value(node)
+	return &v
+}
+
+// newStruct returns a struct of the type typ with every field nil,
+// for node to fill in.
+func (c *EvalCtx) newStruct(node *ir.StructLit, typ *Var) *Struct {
+	if typ.Type != TYPE {
+		c.errorf(node.Type, ErrType, "%v is not a type", typ.Type)
+	}
+	t := typ.TypeVal
+	if node.Fields == nil && len(node.Values) > 0 && len(node.Values) != len(t.Fields) {
+		c.errorf(node, ErrValueCount, "%s has %d fields, got %d values", t.Name, len(t.Fields), len(node.Values))
+	}
+	s := &Struct{Type: t, Fields: make([]*Var, len(t.Fields))}
+	for i := range s.Fields {
+		s.Fields[i] = &Var{Type: NIL}
+	}
+	return s
+}
+
+// fieldIndex returns the index of the field name of t, given its
+// value by node in a struct literal.
+func (c *EvalCtx) fieldIndex(node ir.Node, t *StructType, name string) int {
+	j := t.field(name)
+	if j < 0 {
+		c.errorf(node, ErrUndefined, "unknown field %s in %s", name, t.Name)
+	}
+	return j
+}
+
+// selector evaluates x.Sel: a copy of the field, or the method bound
+// to x.
+func (c *EvalCtx) selector(node *ir.SelectorExpr) *Var {
+	return c.selectorOf(node, c.value(node.X))
+}
+
+// selectorOf is selector with x already evaluated.
+func (c *EvalCtx) selectorOf(node *ir.SelectorExpr, x *Var) *Var {
+	if x.Type != STRUCT {
+		c.errorf(node, ErrType, "%v has no field or method %s", x.Type, node.Sel)
+	}
+	t := x.StructVal.Type
+	if i := t.field(node.Sel); i >= 0 {
+		v := *x.StructVal.Fields[i]
+		return &v
+	}
+	m, ok := t.Methods[node.Sel]
+	if !ok {
+		c.errorf(node, ErrUndefined, "%s has no field or method %s", t.Name, node.Sel)
+	}
+	bound := *m
+	recv := *x
+	bound.Recv = &recv
+	return &bound
+}
+
+// field returns the struct field x.Sel is assigned to.
+func (c *EvalCtx) field(node *ir.SelectorExpr) (*Struct, int) {
+	return c.fieldOf(node, c.value(node.X))
+}
+
+// fieldOf is field with x already evaluated.
+func (c *EvalCtx) fieldOf(node *ir.SelectorExpr, x *Var) (*Struct, int) {
+	if x.Type != STRUCT {
+		c.errorf(node, ErrType, "%v has no field %s", x.Type, node.Sel)
+	}
+	i := x.StructVal.Type.field(node.Sel)
+	if i < 0 {
+		c.errorf(node, ErrUndefined, "%s has no field %s", x.StructVal.Type.Name, node.Sel)
+	}
+	return x.StructVal, i
+}
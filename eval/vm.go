@@ -0,0 +1,476 @@
+package eval
+
+import (
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// frame is a call running on the VM. It keeps what the caller had
+// open, which a return puts back.
+type frame struct {
+	p  *proto
+	pc int
+	// env is the caller's env, base the stack length below the
+	// called func, and marks and iters the marks and iterators
+	// the caller had.
+	env                *env
+	base, marks, iters int
+	// want is the number of results the caller keeps, as for
+	// compiler.call, and site the call it is made from.
+	want int
+	site ir.Node
+}
+
+// vm runs compiled code. It runs the programs the walker does with
+// the same results and errors; calls from Go, such as a host func
+// calling back into the program, run on the same stack.
+type vm struct {
+	c      *EvalCtx
+	stack  []*Var
+	frames []*frame
+	marks  []int
+	iters  []*iterator
+	env    *env
+}
+
+// loadVM declares the top level nodes in the current scope like
+// loadNodes, compiling funcs for the VM.
+func (c *EvalCtx) loadVM(nodes []ir.Node) {
+	c.vm = &vm{c: c}
+	c.loadTypes(nodes)
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ir.VarDecl:
+			c.vm.call(nil, &Var{Type: FUNC, proto: compileDecl(node)}, nil)
+		case *ir.Func:
+			fn := &Var{Type: FUNC, Func: node, proto: compileFunc(node)}
+			if node.RecvType != "" {
+				c.loadMethod(node, fn)
+				break
+			}
+			c.setGlobal(node.FuncName, fn)
+		case *ir.TypeDecl:
+		default:
+			panic("no support node")
+		}
+	}
+}
+
+// call calls fn, a compiled func, from Go and returns its results. The
+// state of the VM is put back if the call fails.
+func (m *vm) call(site ir.Node, fn *Var, args []*Var) []*Var {
+	stack, frames, marks, iters, e := len(m.stack), len(m.frames), len(m.marks), len(m.iters), m.env
+	defer func() {
+		m.stack, m.frames, m.marks, m.iters, m.env = m.stack[:stack], m.frames[:frames], m.marks[:marks], m.iters[:iters], e
+	}()
+	m.stack = append(m.stack, fn)
+	m.stack = append(m.stack, args...)
+	m.enter(site, len(args), -1)
+	return m.run(frames)
+}
+
+// enter starts a call of the compiled func below the argc arguments on top
+// of the stack, which are bound to its parameters.
+func (m *vm) enter(site ir.Node, argc, want int) {
+	base := len(m.stack) - argc - 1
+	fn := m.stack[base]
+	p := fn.proto
+	if p.fn != nil {
+		m.c.checkDepth(site, p.fn, len(m.frames))
+	}
+	m.frames = append(m.frames, &frame{
+		p: p, env: m.env,
+		base: base, marks: len(m.marks), iters: len(m.iters),
+		want: want, site: site,
+	})
+	e := fn.env
+	if f := p.fn; f != nil {
+		m.c.checkArgs(site, f, argc)
+		e = &env{slots: make([]*Var, f.Frame), parent: e}
+		args := m.stack[base+1:]
+		if fn.Recv != nil {
+			args = append([]*Var{fn.Recv}, args...)
+		}
+		bindArgs(e.slots, f, args)
+	}
+	m.stack = m.stack[:base]
+	m.env = e
+}
+
+func (m *vm) push(v *Var) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *vm) pop() *Var {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+func (m *vm) top() *Var {
+	return m.stack[len(m.stack)-1]
+}
+
+func (m *vm) popMark() int {
+	mark := m.marks[len(m.marks)-1]
+	m.marks = m.marks[:len(m.marks)-1]
+	return mark
+}
+
+// global returns the global slot, failing at node, a Name, when it
+// has not been declared yet.
+func (m *vm) global(node ir.Node, slot int) *Var {
+	v := m.c.globals[slot]
+	if v == nil {
+		m.c.errorf(node, ErrUndefined, "undefined: %s", node.(*ir.Name).Name)
+	}
+	return v
+}
+
+// run runs the code of the frames above stop until the last of them
+// returns, and returns its results.
+func (m *vm) run(stop int) []*Var {
+	c := m.c
+	fr := m.frames[len(m.frames)-1]
+	for {
+		p := fr.p
+		in := p.code[fr.pc]
+		node := p.nodes[fr.pc]
+		fr.pc++
+		switch in.op {
+		case opConst:
+			// values on the stack are never changed in place, only
+			// copied, so the constant itself is pushed.
+			m.push(p.consts[in.a])
+		case opPop:
+			m.stack = m.stack[:len(m.stack)-1]
+		case opPopN:
+			m.stack = m.stack[:len(m.stack)-in.a]
+		case opDup:
+			m.push(m.top())
+		case opPick:
+			m.push(m.stack[len(m.stack)-1-in.a])
+
+		case opLocal:
+			// a copy, as the walker evaluates a name to: a store
+			// to the var overwrites it in place.
+			v := m.env.up(in.a).slots[in.b]
+			if v == nil {
+				c.errorf(node, ErrUndefined, "undefined: %s", node.(*ir.Name).Name)
+			}
+			cp := *v
+			m.push(&cp)
+		case opStoreLocal:
+			v := m.pop()
+			slot := m.env.up(in.a).slots[in.b]
+			if slot == nil {
+				c.errorf(node, ErrUndefined, "undefined: %s", node.(*ir.Name).Name)
+			}
+			*slot = *v
+		case opDefine:
+			v := *m.pop()
+			v.Name = p.names[in.b]
+			m.env.slots[in.a] = &v
+		case opGlobal:
+			v := *m.global(node, in.a)
+			m.push(&v)
+		case opCheckGlobal:
+			m.global(node, in.a)
+		case opStoreGlobal:
+			v := m.pop()
+			*m.global(node, in.a) = *v
+		case opDefineGlobal:
+			v := *m.pop()
+			c.setGlobal(p.names[in.a], &v)
+		case opPushEnv:
+			m.env = &env{slots: make([]*Var, in.a), parent: m.env}
+		case opPopEnv:
+			m.env = m.env.up(in.a)
+
+		case opJump:
+			fr.pc = in.a
+		case opJumpIfNot:
+			v := m.pop()
+			if v.Type != BOOL {
+				c.errorf(node, ErrType, "non-bool condition (%v)", v.Type)
+			}
+			if !v.BoolVal {
+				fr.pc = in.a
+			}
+		case opJumpIfNotCompare:
+			r := m.pop()
+			if !m.compare(node, syntax.Op(in.b), m.pop(), r) {
+				fr.pc = in.a
+			}
+		case opLogic:
+			op := syntax.Op(in.b)
+			v := m.pop()
+			if v.Type != BOOL {
+				c.errorf(node, ErrType, "invalid operation: operator %v not defined on %v", op, v.Type)
+			}
+			if v.BoolVal == (op == syntax.OpOR) {
+				m.push(&Var{Type: BOOL, BoolVal: v.BoolVal})
+				fr.pc = in.a
+			}
+		case opCheckBool:
+			v := m.pop()
+			if v.Type != BOOL {
+				c.errorf(node, ErrType, "invalid operation: operator %v not defined on %v", syntax.Op(in.a), v.Type)
+			}
+			m.push(&Var{Type: BOOL, BoolVal: v.BoolVal})
+
+		case opUnary:
+			v, err := GetUnaryOpResult(syntax.Op(in.a), m.pop())
+			if err != nil {
+				c.fail(node, err)
+			}
+			m.push(v)
+		case opBinary:
+			r := m.pop()
+			v, err := GetBinaryOpResult(syntax.Op(in.a), m.pop(), r)
+			if err != nil {
+				c.fail(node, err)
+			}
+			m.push(v)
+		case opEqual:
+			r := m.pop()
+			m.push(&Var{Type: BOOL, BoolVal: Equal(m.pop(), r)})
+
+		case opList:
+			list := &List{Elems: make([]*Var, in.a)}
+			for i, v := range m.stack[len(m.stack)-in.a:] {
+				elem := *v
+				list.Elems[i] = &elem
+			}
+			m.stack = m.stack[:len(m.stack)-in.a]
+			m.push(&Var{Type: LIST, ListVal: list})
+		case opMap:
+			m.push(&Var{Type: MAP, MapVal: NewMap()})
+		case opMapSet:
+			v := m.pop()
+			k := m.pop()
+			if err := m.top().MapVal.Set(k, v); err != nil {
+				c.fail(node, err)
+			}
+		case opStruct:
+			m.push(&Var{Type: STRUCT, StructVal: c.newStruct(node.(*ir.StructLit), m.pop())})
+		case opFieldIndex:
+			c.fieldIndex(node, m.top().StructVal.Type, p.names[in.a])
+		case opInitField:
+			v := *m.pop()
+			s := m.top().StructVal
+			i := in.a
+			if i < 0 {
+				i = s.Type.field(p.names[in.b])
+			}
+			s.Fields[i] = &v
+		case opSelect:
+			m.push(c.selectorOf(node.(*ir.SelectorExpr), m.pop()))
+		case opIndex:
+			k := m.pop()
+			m.push(c.indexOf(node.(*ir.IndexExpr), m.pop(), k))
+		case opSlice:
+			var lo, hi *Var
+			if in.a&2 != 0 {
+				hi = m.pop()
+			}
+			if in.a&1 != 0 {
+				lo = m.pop()
+			}
+			m.push(c.sliceOf(node.(*ir.SliceExpr), m.pop(), lo, hi))
+
+		case opCheckIndex:
+			target := node.(*ir.IndexExpr)
+			x, k := *m.stack[len(m.stack)-2], *m.top()
+			switch x.Type {
+			case LIST:
+				c.checkIndex(target.Index, &k, len(x.ListVal.Elems), false)
+			case MAP:
+			default:
+				c.errorf(target.X, ErrType, "cannot index %v", x.Type)
+			}
+			// the target is the element x and k refer to now, even
+			// if they are assigned to before it is.
+			m.stack[len(m.stack)-2], m.stack[len(m.stack)-1] = &x, &k
+		case opIndexLoad:
+			k := m.pop()
+			x := m.pop()
+			if x.Type == LIST {
+				m.push(x.ListVal.Elems[int(k.NumVal)])
+				break
+			}
+			v, err := x.MapVal.Get(k)
+			if err != nil {
+				c.fail(node.(*ir.IndexExpr).Index, err)
+			}
+			if v == nil {
+				v = &Var{Type: NIL}
+			}
+			m.push(v)
+		case opSetIndex:
+			target := node.(*ir.IndexExpr)
+			v := m.pop()
+			k := m.pop()
+			x := m.pop()
+			if x.Type == LIST {
+				elem := *v
+				x.ListVal.Elems[c.checkIndex(target.Index, k, len(x.ListVal.Elems), false)] = &elem
+				break
+			}
+			if err := x.MapVal.Set(k, v); err != nil {
+				c.fail(target.Index, err)
+			}
+		case opCheckField:
+			x := *m.top()
+			c.fieldOf(node.(*ir.SelectorExpr), &x)
+			m.stack[len(m.stack)-1] = &x
+		case opField:
+			s, i := c.fieldOf(node.(*ir.SelectorExpr), m.pop())
+			m.push(s.Fields[i])
+		case opSetField:
+			v := *m.pop()
+			s, i := c.fieldOf(node.(*ir.SelectorExpr), m.pop())
+			s.Fields[i] = &v
+
+		case opCheckFunc:
+			c.checkFunc(node.(*ir.CallExpr), m.top())
+		case opCall:
+			argc := in.a
+			if argc < 0 {
+				argc = len(m.stack) - m.popMark()
+			}
+			fn := m.stack[len(m.stack)-argc-1]
+			if fn.proto != nil {
+				m.enter(node, argc, in.b)
+				fr = m.frames[len(m.frames)-1]
+				break
+			}
+			args := append([]*Var(nil), m.stack[len(m.stack)-argc:]...)
+			m.stack = m.stack[:len(m.stack)-argc-1]
+			c.callValue(node, fn, args)
+			m.results(node, c.Result, in.b)
+		case opReturn:
+			n := in.a
+			if n < 0 {
+				n = len(m.stack) - m.popMark()
+			}
+			results := append([]*Var(nil), m.stack[len(m.stack)-n:]...)
+			m.stack = m.stack[:fr.base]
+			m.env, m.marks, m.iters = fr.env, m.marks[:fr.marks], m.iters[:fr.iters]
+			m.frames = m.frames[:len(m.frames)-1]
+			if len(m.frames) == stop {
+				return results
+			}
+			m.results(fr.site, results, fr.want)
+			fr = m.frames[len(m.frames)-1]
+		case opClosure:
+			proto := p.protos[in.a]
+			m.push(&Var{Type: FUNC, Func: proto.fn, env: m.env, proto: proto})
+		case opMark:
+			m.marks = append(m.marks, len(m.stack))
+		case opDropMark:
+			m.popMark()
+		case opTruncate:
+			m.stack = m.stack[:m.popMark()]
+		case opUnpack:
+			mark := m.popMark()
+			values := m.stack[mark:]
+			if len(values) != in.a {
+				c.errorf(node, ErrValueCount, "assignment mismatch: %s but %s", plural(in.a, "variable"), plural(len(values), "value"))
+			}
+			for i, v := range values {
+				cp := *v
+				values[i] = &cp
+			}
+
+		case opIter:
+			m.iters = append(m.iters, c.iterate(node.(*ir.RangeStmt), m.pop()))
+		case opNext:
+			key, value, ok := m.iters[len(m.iters)-1].next()
+			if !ok {
+				fr.pc = in.a
+				break
+			}
+			m.push(value)
+			if in.b == 0 {
+				m.push(key)
+			}
+		case opPopIter:
+			m.iters = m.iters[:len(m.iters)-in.a]
+
+		case opMatchList:
+			v := m.top()
+			n := 0
+			if v.Type == LIST {
+				n = len(v.ListVal.Elems)
+			}
+			ok := v.Type == LIST && (n == in.a || in.b == 1 && n > in.a)
+			m.push(&Var{Type: BOOL, BoolVal: ok})
+		case opElem:
+			m.push(m.pop().ListVal.Elems[in.a])
+		case opRest:
+			rest := &List{}
+			for _, elem := range m.pop().ListVal.Elems[in.a:] {
+				e := *elem
+				rest.Elems = append(rest.Elems, &e)
+			}
+			m.push(&Var{Type: LIST, ListVal: rest})
+		case opIsMap:
+			m.push(&Var{Type: BOOL, BoolVal: m.top().Type == MAP})
+		case opMapLookup:
+			k := m.pop()
+			v, err := m.pop().MapVal.Get(k)
+			if err != nil {
+				c.fail(node, err)
+			}
+			if v == nil {
+				m.push(&Var{Type: NIL})
+			} else {
+				m.push(v)
+			}
+			m.push(&Var{Type: BOOL, BoolVal: v != nil})
+		default:
+			panic("unknown opcode")
+		}
+	}
+}
+
+// compare applies the comparison op to l and r at node.
+func (m *vm) compare(node ir.Node, op syntax.Op, l, r *Var) bool {
+	if l.Type == NUM && r.Type == NUM {
+		switch op {
+		case syntax.OpEQ:
+			return l.NumVal == r.NumVal
+		case syntax.OpNEQ:
+			return l.NumVal != r.NumVal
+		case syntax.OpLT:
+			return l.NumVal < r.NumVal
+		case syntax.OpLEQ:
+			return l.NumVal <= r.NumVal
+		case syntax.OpGT:
+			return l.NumVal > r.NumVal
+		case syntax.OpGEQ:
+			return l.NumVal >= r.NumVal
+		}
+	}
+	v, err := GetBinaryOpResult(op, l, r)
+	if err != nil {
+		m.c.fail(node, err)
+	}
+	return v.BoolVal
+}
+
+// results pushes the results of a call from site, as many as want
+// says.
+func (m *vm) results(site ir.Node, results []*Var, want int) {
+	switch want {
+	case 0:
+	case 1:
+		if len(results) != 1 {
+			m.c.errorf(site, ErrValueCount, "expression has %d values, want 1", len(results))
+		}
+		m.push(results[0])
+	default:
+		m.stack = append(m.stack, results...)
+	}
+}
@@ -10,8 +10,11 @@ type irgen struct {
 
 func (irgen *irgen) VarDecl(d *syntax.VarDecl) Node {
 	declNode := new(VarDecl)
+	declNode.at(d)
+	declNode.Const = d.Const
 	declNode.Lhs = d.Lhs
-	for i := 0; i<len(d.Lhs); i++ {
+	declNode.Type = d.Type
+	for i := 0; i < len(d.Rhs); i++ {
 		declNode.Rhs = append(declNode.Rhs, irgen.Expr(d.Rhs[i]))
 	}
 	return declNode
@@ -21,26 +24,105 @@ func (irgen *irgen) Expr(e syntax.Expr) Node {
 	switch e := e.(type) {
 	case *syntax.Name:
 		n := new(Name)
+		n.at(e)
 		n.Name = e.Name
 		return n
 	case *syntax.Literal:
 		n := new(Literal)
+		n.at(e)
 		n.Type = e.Type
 		n.Val = e.Val
 		return n
+	case *syntax.UnaryExpr:
+		n := new(UnaryExpr)
+		n.at(e)
+		n.Op = e.Op
+		n.X = irgen.Expr(e.X)
+		return n
 	case *syntax.BinaryExpr:
 		n := new(BinaryExpr)
+		n.at(e)
 		n.Op = e.Op
 		n.Lhs = irgen.Expr(e.Lhs)
 		n.Rhs = irgen.Expr(e.Rhs)
 		return n
 	case *syntax.CallExpr:
 		n := new(CallExpr)
-		n.Name = e.Name
+		n.at(e)
+		n.Fun = irgen.Expr(e.Fun)
 		for _, expr := range e.Args {
 			n.Args = append(n.Args, irgen.Expr(expr))
 		}
 		return n
+	case *syntax.ListLit:
+		n := new(ListLit)
+		n.at(e)
+		for _, expr := range e.Elems {
+			n.Elems = append(n.Elems, irgen.Expr(expr))
+		}
+		return n
+	case *syntax.MapLit:
+		n := new(MapLit)
+		n.at(e)
+		for i := range e.Keys {
+			n.Keys = append(n.Keys, irgen.Expr(e.Keys[i]))
+			n.Values = append(n.Values, irgen.Expr(e.Values[i]))
+		}
+		return n
+	case *syntax.StructLit:
+		n := new(StructLit)
+		n.at(e)
+		n.Type = irgen.Expr(e.Type)
+		n.Fields = e.Fields
+		for _, expr := range e.Values {
+			n.Values = append(n.Values, irgen.Expr(expr))
+		}
+		return n
+	case *syntax.SelectorExpr:
+		n := new(SelectorExpr)
+		n.at(e)
+		n.X = irgen.Expr(e.X)
+		n.Sel = e.Sel
+		return n
+	case *syntax.IndexExpr:
+		n := new(IndexExpr)
+		n.at(e)
+		n.X = irgen.Expr(e.X)
+		n.Index = irgen.Expr(e.Index)
+		return n
+	case *syntax.SliceExpr:
+		n := new(SliceExpr)
+		n.at(e)
+		n.X = irgen.Expr(e.X)
+		if e.Lo != nil {
+			n.Lo = irgen.Expr(e.Lo)
+		}
+		if e.Hi != nil {
+			n.Hi = irgen.Expr(e.Hi)
+		}
+		return n
+	case *syntax.ListPattern:
+		n := new(ListPattern)
+		n.at(e)
+		for _, expr := range e.Elems {
+			n.Elems = append(n.Elems, irgen.Expr(expr))
+		}
+		n.Rest = e.Rest
+		return n
+	case *syntax.MapPattern:
+		n := new(MapPattern)
+		n.at(e)
+		for i := range e.Keys {
+			n.Keys = append(n.Keys, irgen.Expr(e.Keys[i]))
+			n.Values = append(n.Values, irgen.Expr(e.Values[i]))
+		}
+		return n
+	case *syntax.FuncLit:
+		n := new(FuncLit)
+		n.at(e)
+		n.Func = irgen.Func(e, "", e.Args, e.Variadic, e.Body)
+		n.Func.ArgPos, n.Func.ArgTypes, n.Func.Results = e.ArgPos, e.ArgTypes, e.Results
+		return n
 	default:
 		panic("unknown expr type")
 	}
@@ -48,10 +130,27 @@ func (irgen *irgen) Expr(e syntax.Expr) Node {
 
 
 func (irgen *irgen) FuncDecl(f *syntax.FuncDecl) Node {
+	funcNode := irgen.Func(f, f.FuncName, f.Args, f.Variadic, f.Body)
+	funcNode.Recv, funcNode.RecvType = f.Recv, f.RecvType
+	funcNode.ArgPos, funcNode.ArgTypes, funcNode.Results = f.ArgPos, f.ArgTypes, f.Results
+	return funcNode
+}
+
+func (irgen *irgen) TypeDecl(d *syntax.TypeDecl) Node {
+	typeNode := new(TypeDecl)
+	typeNode.at(d)
+	typeNode.Name = d.Name
+	typeNode.Fields = d.Fields
+	return typeNode
+}
+
+func (irgen *irgen) Func(from syntax.Node, name string, args []string, variadic bool, body []syntax.Stmt) *Func {
 	funcNode := new(Func)
-	funcNode.FuncName = f.FuncName
-	funcNode.Args = f.Args
-	for _, stmt := range f.Body {
+	funcNode.at(from)
+	funcNode.FuncName = name
+	funcNode.Args = args
+	funcNode.Variadic = variadic
+	for _, stmt := range body {
 		funcNode.Body = append(funcNode.Body, irgen.Stmt(stmt))
 	}
 	return funcNode
@@ -60,53 +159,138 @@ func (irgen *irgen) FuncDecl(f *syntax.FuncDecl) Node {
 func(irgen *irgen) Stmt(stmt syntax.Stmt) Node {
 	switch stmt := stmt.(type) {
 	case *syntax.DeclStmt:
-		node := irgen.VarDecl(stmt.Decl.(*syntax.VarDecl))
-		return node
+		switch d := stmt.Decl.(type) {
+		case *syntax.VarDecl:
+			return irgen.VarDecl(d)
+		case *syntax.FuncDecl:
+			// a nested func is a local variable holding a closure;
+			// the closure sees its own name since it is defined in
+			// the scope it captures.
+			node := new(VarDecl)
+			node.at(stmt)
+			node.Lhs = []string{d.FuncName}
+			lit := new(FuncLit)
+			lit.at(d)
+			lit.Func = irgen.Func(d, d.FuncName, d.Args, d.Variadic, d.Body)
+			lit.Func.ArgPos, lit.Func.ArgTypes, lit.Func.Results = d.ArgPos, d.ArgTypes, d.Results
+			node.Rhs = []Node{lit}
+			return node
+		}
+		panic("unknown decl")
 	case *syntax.AssignStmt:
 		node := new(AssignStmt)
-		node.Lhs = stmt.Lhs
+		node.at(stmt)
+		node.Op = stmt.Op
+		for _, expr := range stmt.Lhs {
+			node.Lhs = append(node.Lhs, irgen.Expr(expr))
+		}
 		for _, expr := range stmt.Rhs {
 			node.Rhs = append(node.Rhs, irgen.Expr(expr))
 		}
+		if stmt.Rhs == nil {
+			// x++ and x-- are x += 1 and x -= 1.
+			one := new(Literal)
+			one.at(stmt)
+			one.Type, one.Val = syntax.TNUM, "1"
+			node.Rhs = []Node{one}
+		}
 		return node
 	case *syntax.IfStmt:
 		node := new(IfStmt)
+		node.at(stmt)
 		node.Cond = irgen.Expr(stmt.Cond)
 		node.Body = irgen.Stmt(stmt.Body)
 		node.Else = irgen.Stmt(stmt.Else)
 		return node
 	case *syntax.ForStmt:
+		if stmt.Init == nil && stmt.Post == nil {
+			if stmt.Cond == nil {
+				node := new(LoopStmt)
+				node.at(stmt)
+				node.Body = irgen.Stmt(stmt.Body)
+				return node
+			}
+			node := new(WhileStmt)
+			node.at(stmt)
+			node.Cond = irgen.Expr(stmt.Cond)
+			node.Body = irgen.Stmt(stmt.Body)
+			return node
+		}
 		node := new(ForStmt)
+		node.at(stmt)
 		node.Init = irgen.Stmt(stmt.Init)
-		node.Cond = irgen.Expr(stmt.Cond)
+		if stmt.Cond != nil {
+			node.Cond = irgen.Expr(stmt.Cond)
+		}
 		node.Post = irgen.Stmt(stmt.Post)
 		node.Body = irgen.Stmt(stmt.Body)
 		return node
+	case *syntax.SwitchStmt:
+		node := new(SwitchStmt)
+		node.at(stmt)
+		if stmt.Tag != nil {
+			node.Tag = irgen.Expr(stmt.Tag)
+		}
+		for _, clause := range stmt.Cases {
+			cc := new(CaseClause)
+			cc.at(clause)
+			for _, expr := range clause.Values {
+				cc.Values = append(cc.Values, irgen.Expr(expr))
+			}
+			for _, s := range clause.Body {
+				cc.Body = append(cc.Body, irgen.Stmt(s))
+			}
+			cc.Fallthrough = clause.Fallthrough
+			node.Cases = append(node.Cases, cc)
+		}
+		return node
+	case *syntax.RangeStmt:
+		node := new(RangeStmt)
+		node.at(stmt)
+		node.Key, node.Value = stmt.Key, stmt.Value
+		node.X = irgen.Expr(stmt.X)
+		node.Body = irgen.Stmt(stmt.Body)
+		return node
 	case *syntax.BlockStmt:
 		node := new(BlockStmt)
+		node.at(stmt)
 		for _, oneStmt := range stmt.Stmts {
 			node.Stmts = append(node.Stmts, irgen.Stmt(oneStmt))
 		}
 		return node
 	case *syntax.ReturnStmt:
 		node := new(ReturnStmt)
+		node.at(stmt)
 		for _, oneExpr := range stmt.Returns {
 			node.Returns = append(node.Returns, irgen.Expr(oneExpr))
 		}
 		return node
 	case *syntax.CallStmt:
-		node := new(CallExpr)
-		callExpr := stmt.Call.(*syntax.CallExpr)
-		node.Name = callExpr.Name
-		for _, expr := range callExpr.Args {
-			node.Args = append(node.Args, irgen.Expr(expr))
-		}
-		return node
+		return irgen.Expr(stmt.Call)
 	case *syntax.BreakStmt:
 		node := new(BreakStmt)
+		node.at(stmt)
+		node.Label = stmt.Label
 		return node
 	case *syntax.ContinueStmt:
 		node := new(ContinueStmt)
+		node.at(stmt)
+		node.Label = stmt.Label
+		return node
+	case *syntax.LabeledStmt:
+		node := irgen.Stmt(stmt.Stmt)
+		switch node := node.(type) {
+		case *ForStmt:
+			node.Label = stmt.Label
+		case *WhileStmt:
+			node.Label = stmt.Label
+		case *LoopStmt:
+			node.Label = stmt.Label
+		case *RangeStmt:
+			node.Label = stmt.Label
+		case *SwitchStmt:
+			node.Label = stmt.Label
+		}
 		return node
 	}
 	return nil
@@ -121,6 +305,8 @@ func GenAst(file *syntax.File)[]Node {
 			nodes = append(nodes, irgen.VarDecl(d))
 		case *syntax.FuncDecl:
 			nodes = append(nodes, irgen.FuncDecl(d))
+		case *syntax.TypeDecl:
+			nodes = append(nodes, irgen.TypeDecl(d))
 		default:
 			panic("unknown decl")
 		}
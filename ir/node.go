@@ -2,81 +2,260 @@ package ir
 
 import "github.com/cuiweixie/toylang/syntax"
 
+// Node is implemented by every IR node. Pos and End delimit the
+// source the node was generated from.
 type Node interface {
+	Pos() syntax.Pos
+	End() syntax.Pos
 	aNode()
 }
 
+type node struct {
+	pos, end syntax.Pos
+}
+
+func (n *node) Pos() syntax.Pos {
+	return n.pos
+}
+
+func (n *node) End() syntax.Pos {
+	return n.end
+}
+
+func (*node) aNode() {}
+
+// at gives n the source span of from.
+func (n *node) at(from syntax.Node) {
+	n.pos, n.end = from.Pos(), from.End()
+}
+
 type VarDecl struct {
+	Const bool
 	Lhs []string
+	// Type is the type given to Lhs, empty when there is none.
+	Type string
 	Rhs []Node
-	Node
+	// Slots are the slots Resolve gave Lhs in the frame, or the
+	// globals for a top level declaration.
+	Slots []int
+	node
+}
+
+type TypeDecl struct {
+	Name   string
+	Fields []string
+	node
 }
 
 type Func struct {
-	FuncName string
-	Args   []string
+	// Recv and RecvType are set for a method.
+	Recv, RecvType string
+	FuncName       string
+	Args           []string
+	// ArgPos are the positions of Args.
+	ArgPos []syntax.Pos
+	// ArgTypes and Results are the types given to Args and the
+	// results, as in syntax.FuncDecl.
+	ArgTypes []string
+	Variadic bool
+	Results  []string
 	Body   []Node
-	Node
+	// Frame is the size of the frame every call runs in. Recv,
+	// then Args, have its first slots.
+	Frame int
+	node
 }
 
 type Name struct {
 	Name string
-	Node
+	// Ref is the variable Resolve bound the name to.
+	Ref Ref
+	node
 }
 
 type Literal struct {
 	Val  string
 	Type syntax.LiteralType
-	Node
+	node
+}
+
+type UnaryExpr struct {
+	Op syntax.Op
+	X  Node
+	node
 }
 
 type BinaryExpr struct {
 	Op syntax.Op
 	Lhs, Rhs Node
-	Node
+	node
 }
 
+// AssignStmt assigns to names, list or map elements or struct
+// fields; each of Lhs is a *Name, an *IndexExpr or a *SelectorExpr.
+// With Op set it is Lhs[0] op= Rhs[0].
 type AssignStmt struct {
-	Lhs []string
+	Op  syntax.Op
+	Lhs []Node
 	Rhs  []Node
-	Node
+	node
+}
+
+type ListLit struct {
+	Elems []Node
+	node
+}
+
+type MapLit struct {
+	Keys, Values []Node
+	node
+}
+
+type StructLit struct {
+	Type Node
+	// Fields is nil when Values gives every field in order.
+	Fields []string
+	Values []Node
+	node
+}
+
+type SelectorExpr struct {
+	X   Node
+	Sel string
+	node
+}
+
+type IndexExpr struct {
+	X, Index Node
+	node
+}
+
+// SliceExpr is X[Lo:Hi]. Lo and Hi are nil when omitted.
+type SliceExpr struct {
+	X, Lo, Hi Node
+	node
 }
 
 type CallExpr struct {
-	Name string
+	Fun  Node
 	Args []Node
-	Node
+	node
+}
+
+// FuncLit evaluates to a closure over the scope it is evaluated in.
+type FuncLit struct {
+	Func *Func
+	node
 }
 
+// IfStmt runs Body, or Else, in a scope of its own, whose frames
+// have BodyFrame and ElseFrame slots.
 type IfStmt struct {
 	Cond Node
 	Body Node
 	Else Node
-	Node
+	BodyFrame, ElseFrame int
+	node
 }
 
-type ForStmt struct{
-	Init Node
-	Cond Node
-	Post Node
-	Body Node
-	Node
+// ForStmt is the three clause loop. Init, Cond and Post may be nil;
+// a nil Cond is true. They run in one frame of Frame slots, inside
+// which each iteration runs Body in a new frame of BodyFrame slots.
+type ForStmt struct {
+	Init             Node
+	Cond             Node
+	Post             Node
+	Body             Node
+	Label            string
+	Frame, BodyFrame int
+	node
+}
+
+// WhileStmt is a loop with only a condition: for Cond { Body }.
+// Each iteration runs in a new frame of Frame slots.
+type WhileStmt struct {
+	Cond  Node
+	Body  Node
+	Label string
+	Frame int
+	node
+}
+
+// LoopStmt loops until a break or return: for { Body }.
+type LoopStmt struct {
+	Body  Node
+	Label string
+	Frame int
+	node
+}
+
+// RangeStmt is for Key, Value in X { Body }; Key is empty when
+// there is only one variable. Each iteration runs in a new frame of
+// Frame slots, holding Key and then Value, inside which Body runs in
+// a frame of BodyFrame slots.
+type RangeStmt struct {
+	Key, Value       string
+	X                Node
+	Body             Node
+	Label            string
+	Frame, BodyFrame int
+	node
+}
+
+// SwitchStmt runs the first case matching Tag or, when Tag is nil,
+// whose value is true.
+type SwitchStmt struct {
+	Tag   Node
+	Cases []*CaseClause
+	Label string
+	node
+}
+
+// CaseClause is a case, or the default when Values is nil. With
+// Fallthrough set, the body of the next case runs after Body. Each
+// value is matched in a new frame of Frame slots, which holds the
+// names its pattern binds and which the body runs in.
+type CaseClause struct {
+	Values      []Node
+	Body        []Node
+	Fallthrough bool
+	Frame       int
+	node
+}
+
+// ListPattern matches a list of len(Elems) elements, or more when
+// Rest is set. Names in patterns bind what they match; the list of
+// the rest goes in RestSlot.
+type ListPattern struct {
+	Elems    []Node
+	Rest     string
+	RestSlot int
+	node
+}
+
+type MapPattern struct {
+	Keys, Values []Node
+	node
 }
 
+// BreakStmt and ContinueStmt jump out of the innermost loop or
+// switch, or the one whose Label is Label.
 type BreakStmt struct {
-	Node
+	Label string
+	node
 }
 
 type ContinueStmt struct {
-	Node
+	Label string
+	node
 }
 
 type BlockStmt struct {
 	Stmts []Node
-	Node
+	node
 }
 
 type ReturnStmt struct {
 	Returns []Node
-	Node
+	node
 }
\ No newline at end of file
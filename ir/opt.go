@@ -0,0 +1,411 @@
+package ir
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// literal returns a literal of type typ with the value val, in the
+// place of the node at.
+func literal(at Node, typ syntax.LiteralType, val string) *Literal {
+	lit := &Literal{Type: typ, Val: val}
+	lit.pos, lit.end = at.Pos(), at.End()
+	return lit
+}
+
+// number returns the value of a NUM literal, and false when node
+// is not one.
+func number(node Node) (float64, bool) {
+	lit, ok := node.(*Literal)
+	if !ok || lit.Type != syntax.TNUM {
+		return 0, false
+	}
+	// the scanner reported the literals ParseNum fails for.
+	num, _ := syntax.ParseNum(lit.Val)
+	return num, true
+}
+
+// numLit returns a NUM literal of num, and false when no literal
+// holds it, as for the infinities a division by zero gives.
+func numLit(at Node, num float64) (Node, bool) {
+	if math.IsInf(num, 0) || math.IsNaN(num) {
+		return nil, false
+	}
+	return literal(at, syntax.TNUM, strconv.FormatFloat(num, 'g', -1, 64)), true
+}
+
+func boolLit(at Node, b bool) Node {
+	return literal(at, syntax.TBOOL, strconv.FormatBool(b))
+}
+
+// isBool reports whether node is the bool literal b.
+func isBool(node Node, b bool) bool {
+	lit, ok := node.(*Literal)
+	return ok && lit.Type == syntax.TBOOL && lit.Val == strconv.FormatBool(b)
+}
+
+// Fold replaces the unary and binary expressions over literals by
+// the literal they evaluate to. Those that would fail when run, such
+// as 1 + "a", are left to fail, and so are those giving a number no
+// literal holds, such as 1 / 0.
+func Fold(nodes []Node) []Node {
+	rw := &rewriter{node: fold}
+	return rw.program(nodes)
+}
+
+func fold(node Node) Node {
+	switch node := node.(type) {
+	case *UnaryExpr:
+		if x, ok := number(node.X); ok && node.Op != syntax.OpNOT {
+			if node.Op == syntax.OpMINUS {
+				x = -x
+			}
+			if lit, ok := numLit(node, x); ok {
+				return lit
+			}
+		}
+		if node.Op == syntax.OpNOT && (isBool(node.X, true) || isBool(node.X, false)) {
+			return boolLit(node, isBool(node.X, false))
+		}
+	case *BinaryExpr:
+		if result := foldBinary(node); result != nil {
+			return result
+		}
+	}
+	return node
+}
+
+// foldBinary returns the literal node evaluates to, or nil.
+func foldBinary(node *BinaryExpr) Node {
+	l, ok := node.Lhs.(*Literal)
+	if !ok {
+		return nil
+	}
+	if node.Op == syntax.OpAND || node.Op == syntax.OpOR {
+		// the right operand is only checked when the left one
+		// does not decide.
+		switch {
+		case l.Type != syntax.TBOOL:
+			return nil
+		case isBool(l, node.Op == syntax.OpOR):
+			return boolLit(node, node.Op == syntax.OpOR)
+		case isBool(node.Rhs, true), isBool(node.Rhs, false):
+			return boolLit(node, isBool(node.Rhs, true))
+		}
+		return nil
+	}
+	r, ok := node.Rhs.(*Literal)
+	if !ok {
+		return nil
+	}
+	x, xok := number(l)
+	y, yok := number(r)
+	if l.Type == syntax.TNUM && !xok || r.Type == syntax.TNUM && !yok {
+		return nil
+	}
+	switch node.Op {
+	case syntax.OpEQ, syntax.OpNEQ:
+		equal := l.Type == r.Type && l.Val == r.Val
+		if l.Type == syntax.TNUM && r.Type == syntax.TNUM {
+			equal = x == y
+		}
+		return boolLit(node, equal == (node.Op == syntax.OpEQ))
+	}
+	if l.Type != r.Type {
+		return nil
+	}
+	switch l.Type {
+	case syntax.TNUM:
+		var num float64
+		switch node.Op {
+		case syntax.OpPLUS:
+			num = x + y
+		case syntax.OpMINUS:
+			num = x - y
+		case syntax.OpMUL:
+			num = x * y
+		case syntax.OpDiv:
+			num = x / y
+		case syntax.OpMOD:
+			num = math.Mod(x, y)
+		default:
+			cmp := 0
+			switch {
+			case x < y:
+				cmp = -1
+			case x > y:
+				cmp = 1
+			}
+			return compare(node, cmp)
+		}
+		if lit, ok := numLit(node, num); ok {
+			return lit
+		}
+	case syntax.TSTRING:
+		if node.Op == syntax.OpPLUS {
+			return literal(node, syntax.TSTRING, l.Val+r.Val)
+		}
+		return compare(node, strings.Compare(l.Val, r.Val))
+	}
+	return nil
+}
+
+// compare folds the comparison node of operands that compare as cmp
+// does to 0. It returns nil for the operators that are no comparison.
+func compare(node *BinaryExpr, cmp int) Node {
+	switch node.Op {
+	case syntax.OpLT:
+		return boolLit(node, cmp < 0)
+	case syntax.OpLEQ:
+		return boolLit(node, cmp <= 0)
+	case syntax.OpGT:
+		return boolLit(node, cmp > 0)
+	case syntax.OpGEQ:
+		return boolLit(node, cmp >= 0)
+	}
+	return nil
+}
+
+// Concat joins the string literals added next to each other, so
+// x + "a" + "b" becomes x + "ab". When x is no string, both fail the
+// same way at the same place.
+func Concat(nodes []Node) []Node {
+	rw := &rewriter{node: concat}
+	return rw.program(nodes)
+}
+
+func concat(node Node) Node {
+	be, ok := node.(*BinaryExpr)
+	if !ok || be.Op != syntax.OpPLUS {
+		return node
+	}
+	r, ok := be.Rhs.(*Literal)
+	if !ok || r.Type != syntax.TSTRING {
+		return node
+	}
+	lhs, ok := be.Lhs.(*BinaryExpr)
+	if !ok || lhs.Op != syntax.OpPLUS {
+		return node
+	}
+	if l, ok := lhs.Rhs.(*Literal); ok && l.Type == syntax.TSTRING {
+		joined := literal(l, syntax.TSTRING, l.Val+r.Val)
+		joined.end = r.end
+		lhs.Rhs = joined
+		lhs.end = be.end
+		return lhs
+	}
+	return node
+}
+
+// Prune drops the branch of an if with a bool literal condition that
+// never runs. The branch that does takes the place of the if when it
+// declares no names, which would then be in the scope around it;
+// otherwise it stays the body of an if true.
+func Prune(nodes []Node) []Node {
+	rw := &rewriter{node: pruneElse, stmts: prune}
+	return rw.program(nodes)
+}
+
+// taken returns the branch of if that runs, and false when its
+// condition is no bool literal.
+func taken(node *IfStmt) (Node, bool) {
+	switch {
+	case isBool(node.Cond, true):
+		return node.Body, true
+	case isBool(node.Cond, false):
+		return node.Else, true
+	}
+	return nil, false
+}
+
+// pruneElse replaces an else if with a literal condition by the
+// branch it takes, which the else's scope holds.
+func pruneElse(node Node) Node {
+	if stmt, ok := node.(*IfStmt); ok {
+		for {
+			elif, ok := stmt.Else.(*IfStmt)
+			if !ok {
+				break
+			}
+			branch, ok := taken(elif)
+			if !ok {
+				break
+			}
+			stmt.Else = branch
+		}
+	}
+	return node
+}
+
+func prune(stmts []Node) []Node {
+	var out []Node
+	for _, stmt := range stmts {
+		node, ok := stmt.(*IfStmt)
+		if !ok {
+			out = append(out, stmt)
+			continue
+		}
+		branch, ok := taken(node)
+		if !ok {
+			out = append(out, stmt)
+			continue
+		}
+		switch branch := branch.(type) {
+		case nil:
+		case *BlockStmt:
+			if declares(branch.Stmts) {
+				node.Cond = boolLit(node.Cond, true)
+				node.Body, node.Else = branch, nil
+				out = append(out, node)
+				break
+			}
+			out = append(out, branch.Stmts...)
+		default:
+			out = append(out, branch)
+		}
+	}
+	return out
+}
+
+// declares reports whether stmts declare names in the scope they run
+// in, which blocks do not open one of their own.
+func declares(stmts []Node) bool {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *VarDecl:
+			return true
+		case *BlockStmt:
+			if declares(stmt.Stmts) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeadCode drops the statements after a return, break or continue,
+// which never run. Declarations stay, since a closure created above
+// them may refer to their names.
+func DeadCode(nodes []Node) []Node {
+	rw := &rewriter{stmts: deadCode}
+	return rw.program(nodes)
+}
+
+func deadCode(stmts []Node) []Node {
+	for i, stmt := range stmts {
+		switch stmt.(type) {
+		case *ReturnStmt, *BreakStmt, *ContinueStmt:
+			out := stmts[:i+1]
+			for _, dead := range stmts[i+1:] {
+				if _, ok := dead.(*VarDecl); ok {
+					out = append(out, dead)
+				}
+			}
+			return out
+		}
+	}
+	return stmts
+}
+
+// Unused drops the local declarations of names a func never refers
+// to, when the values they are given are evaluated without effects
+// or errors. Globals stay, since a host may get them.
+func Unused(nodes []Node) []Node {
+	rw := &rewriter{node: func(node Node) Node {
+		if lit, ok := node.(*FuncLit); ok {
+			dropUnused(lit.Func)
+		}
+		return node
+	}}
+	rw.program(nodes)
+	for _, node := range nodes {
+		if f, ok := node.(*Func); ok {
+			dropUnused(f)
+		}
+	}
+	return nodes
+}
+
+// dropUnused drops the unused declarations of f until there are
+// none, since dropping a closure may leave names it used unused.
+func dropUnused(f *Func) {
+	for {
+		uses := make(map[string]int)
+		countNames(uses, f.Body)
+		changed := false
+		rw := &rewriter{stmts: func(stmts []Node) []Node {
+			for i, stmt := range stmts {
+				decl, ok := stmt.(*VarDecl)
+				if !ok || len(decl.Lhs) != len(decl.Rhs) {
+					continue
+				}
+				var lhs []string
+				var rhs []Node
+				for j, name := range decl.Lhs {
+					if uses[name] == 0 && pure(decl.Rhs[j]) {
+						changed = true
+						continue
+					}
+					lhs = append(lhs, name)
+					rhs = append(rhs, decl.Rhs[j])
+				}
+				if lhs == nil {
+					stmts[i] = nil
+				} else {
+					decl.Lhs, decl.Rhs = lhs, rhs
+				}
+			}
+			return stmts
+		}}
+		f.Body = rw.block(f.Body)
+		if !changed {
+			return
+		}
+	}
+}
+
+// countNames counts the names referred to by nodes, in closures too.
+func countNames(uses map[string]int, nodes []Node) {
+	rw := &rewriter{node: func(node Node) Node {
+		if name, ok := node.(*Name); ok {
+			uses[name.Name]++
+		}
+		return node
+	}}
+	for _, node := range nodes {
+		rw.rewrite(node)
+	}
+}
+
+// pure reports whether evaluating node has no effects and cannot fail.
+func pure(node Node) bool {
+	switch node := node.(type) {
+	case *Literal:
+		if node.Type == syntax.TNUM {
+			_, ok := number(node)
+			return ok
+		}
+		return true
+	case *FuncLit:
+		return true
+	case *ListLit:
+		for _, elem := range node.Elems {
+			if !pure(elem) {
+				return false
+			}
+		}
+		return true
+	case *MapLit:
+		for i, key := range node.Keys {
+			lit, ok := key.(*Literal)
+			if !ok || lit.Type == syntax.TNIL || !pure(key) || !pure(node.Values[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
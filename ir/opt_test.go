@@ -0,0 +1,69 @@
+package ir
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// TestPasses runs each pass alone over a program and compares what
+// it leaves, as Fprint prints it.
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		pass     Pass
+		src, out string
+	}{
+		{
+			Pass{"fold", Fold},
+			`func f(x) { return 1 + 2 * 3, -(4), !true, "a" + "b", 1 < 2, x + 1 * 2 }`,
+			"func f(x) {\n\treturn 7, -4, false, \"ab\", true, x + 2\n}\n",
+		},
+		{
+			Pass{"fold", Fold},
+			`func f() { return 1 / 0, 1 + "a", 2 == 2 }`,
+			"func f() {\n\treturn 1 / 0, 1 + \"a\", true\n}\n",
+		},
+		{
+			Pass{"concat", Concat},
+			`func f(x) { return x + "a" + "b", "a" + x + "b" }`,
+			"func f(x) {\n\treturn x + \"ab\", (\"a\" + x) + \"b\"\n}\n",
+		},
+		{
+			Pass{"prune", Prune},
+			`func f(x) { if true { x = 1 } else { x = 2 }; if false { x = 3 }; if false { x = 4 } else if x { x = 5 } }`,
+			"func f(x) {\n\tx = 1\n\tif x {\n\t\tx = 5\n\t}\n}\n",
+		},
+		{
+			Pass{"prune", Prune},
+			`func f() { if true { var y = 1; print(y) } }`,
+			"func f() {\n\tif true {\n\t\tvar y = 1\n\t\tprint(y)\n\t}\n}\n",
+		},
+		{
+			Pass{"deadcode", DeadCode},
+			`func f(x) { for { break; x = 1 }; return x; print(x); var g = 1 }`,
+			"func f(x) {\n\tfor {\n\t\tbreak\n\t}\n\treturn x\n\tvar g = 1\n}\n",
+		},
+		{
+			Pass{"unused", Unused},
+			`func f(x) { var a = 1; var b = x(); var c = [a]; var d = [2 {"k": 3}]; return }`,
+			"func f(x) {\n\tvar a = 1\n\tvar b = x()\n\tvar c = [a]\n\treturn\n}\n",
+		},
+		{
+			Pass{"unused", Unused},
+			"var g = 1\nfunc f() { var h = func() { var k = 2 } }",
+			"var g = 1\n\nfunc f() {}\n",
+		},
+	}
+	for _, test := range tests {
+		file, err := syntax.Parse("x.toy", []byte(test.src))
+		if err != nil {
+			t.Fatalf("%q: %v", test.src, err)
+		}
+		var buf bytes.Buffer
+		Fprint(&buf, NewPassManager(test.pass).Run(GenAst(file)))
+		if out := buf.String(); out != test.out {
+			t.Errorf("%s %q:\ngot\n%s\nwant\n%s", test.pass.Name, test.src, out, test.out)
+		}
+	}
+}
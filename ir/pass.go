@@ -0,0 +1,187 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+)
+
+// Pass transforms the nodes of a program, which it may change in
+// place, and returns the transformed nodes. A pass must keep what
+// the program does, including the errors it fails with.
+type Pass struct {
+	Name string
+	Run  func(nodes []Node) []Node
+}
+
+// Passes are the optimizations a PassManager runs by default, in
+// the order they are run.
+var Passes = []Pass{
+	{"fold", Fold},
+	{"concat", Concat},
+	{"prune", Prune},
+	{"deadcode", DeadCode},
+	{"unused", Unused},
+}
+
+// PassManager runs passes over a program in order. With Dump set,
+// the program is printed to it before and after each pass.
+type PassManager struct {
+	Passes []Pass
+	Dump   io.Writer
+}
+
+// NewPassManager returns a PassManager running passes, or Passes
+// if there are none.
+func NewPassManager(passes ...Pass) *PassManager {
+	if len(passes) == 0 {
+		passes = Passes
+	}
+	return &PassManager{Passes: append([]Pass(nil), passes...)}
+}
+
+// Add adds p to the passes run, after the others.
+func (pm *PassManager) Add(p Pass) {
+	pm.Passes = append(pm.Passes, p)
+}
+
+// Run runs the passes over nodes and returns the result.
+func (pm *PassManager) Run(nodes []Node) []Node {
+	for _, p := range pm.Passes {
+		pm.dump("before", p, nodes)
+		nodes = p.Run(nodes)
+		pm.dump("after", p, nodes)
+	}
+	return nodes
+}
+
+func (pm *PassManager) dump(when string, p Pass, nodes []Node) {
+	if pm.Dump == nil {
+		return
+	}
+	fmt.Fprintf(pm.Dump, "--- %s %s ---\n", when, p.Name)
+	Fprint(pm.Dump, nodes)
+}
+
+// rewriter rewrites a tree bottom up. The children of a node are
+// rewritten first; then node, if set, is called on it and its result
+// takes its place. stmts, if set, is called on every list of
+// statements once they are rewritten; a nil it leaves in a list is
+// dropped.
+type rewriter struct {
+	node  func(Node) Node
+	stmts func([]Node) []Node
+}
+
+// program rewrites the top level nodes, which stay in place.
+func (rw *rewriter) program(nodes []Node) []Node {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *VarDecl:
+			rw.list(node.Rhs)
+		case *Func:
+			rw.fn(node)
+		}
+	}
+	return nodes
+}
+
+func (rw *rewriter) fn(f *Func) {
+	f.Body = rw.block(f.Body)
+}
+
+// list rewrites the nodes of a list of expressions in place.
+func (rw *rewriter) list(nodes []Node) {
+	for i, node := range nodes {
+		nodes[i] = rw.rewrite(node)
+	}
+}
+
+// block rewrites a list of statements.
+func (rw *rewriter) block(stmts []Node) []Node {
+	rw.list(stmts)
+	if rw.stmts != nil {
+		stmts = rw.stmts(stmts)
+	}
+	out := stmts[:0]
+	for _, stmt := range stmts {
+		if stmt != nil {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+func (rw *rewriter) rewrite(node Node) Node {
+	switch node := node.(type) {
+	case nil:
+		return nil
+	case *VarDecl:
+		rw.list(node.Rhs)
+	case *AssignStmt:
+		rw.list(node.Lhs)
+		rw.list(node.Rhs)
+	case *ReturnStmt:
+		rw.list(node.Returns)
+	case *BlockStmt:
+		node.Stmts = rw.block(node.Stmts)
+	case *IfStmt:
+		node.Cond = rw.rewrite(node.Cond)
+		node.Body = rw.rewrite(node.Body)
+		node.Else = rw.rewrite(node.Else)
+	case *ForStmt:
+		node.Init = rw.rewrite(node.Init)
+		node.Cond = rw.rewrite(node.Cond)
+		node.Post = rw.rewrite(node.Post)
+		node.Body = rw.rewrite(node.Body)
+	case *WhileStmt:
+		node.Cond = rw.rewrite(node.Cond)
+		node.Body = rw.rewrite(node.Body)
+	case *LoopStmt:
+		node.Body = rw.rewrite(node.Body)
+	case *RangeStmt:
+		node.X = rw.rewrite(node.X)
+		node.Body = rw.rewrite(node.Body)
+	case *SwitchStmt:
+		node.Tag = rw.rewrite(node.Tag)
+		for _, clause := range node.Cases {
+			rw.list(clause.Values)
+			clause.Body = rw.block(clause.Body)
+		}
+	case *FuncLit:
+		rw.fn(node.Func)
+	case *CallExpr:
+		node.Fun = rw.rewrite(node.Fun)
+		rw.list(node.Args)
+	case *UnaryExpr:
+		node.X = rw.rewrite(node.X)
+	case *BinaryExpr:
+		node.Lhs = rw.rewrite(node.Lhs)
+		node.Rhs = rw.rewrite(node.Rhs)
+	case *ListLit:
+		rw.list(node.Elems)
+	case *MapLit:
+		rw.list(node.Keys)
+		rw.list(node.Values)
+	case *StructLit:
+		node.Type = rw.rewrite(node.Type)
+		rw.list(node.Values)
+	case *SelectorExpr:
+		node.X = rw.rewrite(node.X)
+	case *IndexExpr:
+		node.X = rw.rewrite(node.X)
+		node.Index = rw.rewrite(node.Index)
+	case *SliceExpr:
+		node.X = rw.rewrite(node.X)
+		node.Lo = rw.rewrite(node.Lo)
+		node.Hi = rw.rewrite(node.Hi)
+	case *ListPattern:
+		rw.list(node.Elems)
+	case *MapPattern:
+		rw.list(node.Keys)
+		rw.list(node.Values)
+	}
+	if rw.node != nil {
+		return rw.node(node)
+	}
+	return node
+}
@@ -0,0 +1,339 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// Fprint writes the nodes of a program to w in toylang syntax. Nested
+// binary expressions are parenthesized, so the order the passes left
+// them in shows.
+func Fprint(w io.Writer, nodes []Node) error {
+	var p printer
+	for i, node := range nodes {
+		if i > 0 {
+			p.buf.WriteByte('\n')
+		}
+		p.stmt(node)
+		p.buf.WriteByte('\n')
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&p.buf, format, args...)
+}
+
+// line starts a new line at the current indent.
+func (p *printer) line() {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat("\t", p.indent))
+}
+
+// block prints stmts in braces, one per line.
+func (p *printer) block(stmts []Node) {
+	p.buf.WriteByte('{')
+	p.indent++
+	for _, stmt := range stmts {
+		p.line()
+		p.stmt(stmt)
+	}
+	p.indent--
+	if len(stmts) > 0 {
+		p.line()
+	}
+	p.buf.WriteByte('}')
+}
+
+// body prints the body of an if or a loop, a block or one statement.
+func (p *printer) body(node Node) {
+	if b, ok := node.(*BlockStmt); ok {
+		p.block(b.Stmts)
+		return
+	}
+	p.block([]Node{node})
+}
+
+func (p *printer) label(label string) {
+	if label != "" {
+		p.printf("%s: ", label)
+	}
+}
+
+func (p *printer) stmt(node Node) {
+	switch node := node.(type) {
+	case *VarDecl:
+		kw := "var"
+		if node.Const {
+			kw = "const"
+		}
+		p.printf("%s %s", kw, strings.Join(node.Lhs, ", "))
+		if node.Type != "" {
+			p.printf(" %s", node.Type)
+		}
+		p.buf.WriteString(" = ")
+		p.exprs(node.Rhs)
+	case *TypeDecl:
+		p.printf("type %s struct { %s }", node.Name, strings.Join(node.Fields, " "))
+	case *Func:
+		p.buf.WriteString("func ")
+		if node.Recv != "" {
+			p.printf("(%s %s) ", node.Recv, node.RecvType)
+		}
+		p.buf.WriteString(node.FuncName)
+		p.funcRest(node)
+	case *AssignStmt:
+		p.exprs(node.Lhs)
+		if node.Op != 0 {
+			p.printf(" %v= ", node.Op)
+		} else {
+			p.buf.WriteString(" = ")
+		}
+		p.exprs(node.Rhs)
+	case *ReturnStmt:
+		p.buf.WriteString("return")
+		if len(node.Returns) > 0 {
+			p.buf.WriteByte(' ')
+			p.exprs(node.Returns)
+		}
+	case *BreakStmt:
+		p.buf.WriteString(strings.TrimSpace("break " + node.Label))
+	case *ContinueStmt:
+		p.buf.WriteString(strings.TrimSpace("continue " + node.Label))
+	case *BlockStmt:
+		p.block(node.Stmts)
+	case *IfStmt:
+		p.buf.WriteString("if ")
+		p.expr(node.Cond)
+		p.buf.WriteByte(' ')
+		p.body(node.Body)
+		switch e := node.Else.(type) {
+		case nil:
+		case *IfStmt:
+			p.buf.WriteString(" else ")
+			p.stmt(e)
+		default:
+			p.buf.WriteString(" else ")
+			p.body(e)
+		}
+	case *ForStmt:
+		p.label(node.Label)
+		p.buf.WriteString("for ")
+		if node.Init != nil {
+			p.stmt(node.Init)
+		}
+		p.buf.WriteString("; ")
+		if node.Cond != nil {
+			p.expr(node.Cond)
+		}
+		p.buf.WriteString("; ")
+		if node.Post != nil {
+			p.stmt(node.Post)
+		}
+		p.buf.WriteByte(' ')
+		p.body(node.Body)
+	case *WhileStmt:
+		p.label(node.Label)
+		p.buf.WriteString("for ")
+		p.expr(node.Cond)
+		p.buf.WriteByte(' ')
+		p.body(node.Body)
+	case *LoopStmt:
+		p.label(node.Label)
+		p.buf.WriteString("for ")
+		p.body(node.Body)
+	case *RangeStmt:
+		p.label(node.Label)
+		p.buf.WriteString("for ")
+		if node.Key != "" {
+			p.printf("%s, ", node.Key)
+		}
+		p.printf("%s in ", node.Value)
+		p.expr(node.X)
+		p.buf.WriteByte(' ')
+		p.body(node.Body)
+	case *SwitchStmt:
+		p.label(node.Label)
+		p.buf.WriteString("switch ")
+		if node.Tag != nil {
+			p.expr(node.Tag)
+			p.buf.WriteByte(' ')
+		}
+		p.buf.WriteByte('{')
+		for _, clause := range node.Cases {
+			p.line()
+			if clause.Values == nil {
+				p.buf.WriteString("default:")
+			} else {
+				p.buf.WriteString("case ")
+				p.exprs(clause.Values)
+				p.buf.WriteByte(':')
+			}
+			p.indent++
+			for _, stmt := range clause.Body {
+				p.line()
+				p.stmt(stmt)
+			}
+			if clause.Fallthrough {
+				p.line()
+				p.buf.WriteString("fallthrough")
+			}
+			p.indent--
+		}
+		p.line()
+		p.buf.WriteByte('}')
+	default:
+		p.expr(node)
+	}
+}
+
+// funcRest prints the parameters, result types and body of f.
+func (p *printer) funcRest(f *Func) {
+	args := append([]string(nil), f.Args...)
+	if f.Variadic {
+		args[len(args)-1] += "..."
+	}
+	for i, t := range f.ArgTypes {
+		if t != "" {
+			args[i] += " " + t
+		}
+	}
+	p.printf("(%s) ", strings.Join(args, ", "))
+	switch len(f.Results) {
+	case 0:
+	case 1:
+		p.printf("%s ", f.Results[0])
+	default:
+		p.printf("(%s) ", strings.Join(f.Results, ", "))
+	}
+	p.block(f.Body)
+}
+
+func (p *printer) exprs(nodes []Node) {
+	for i, node := range nodes {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.expr(node)
+	}
+}
+
+// operand prints the operand of an operator, in parentheses when it
+// is a binary expression itself.
+func (p *printer) operand(node Node) {
+	if _, ok := node.(*BinaryExpr); ok {
+		p.buf.WriteByte('(')
+		p.expr(node)
+		p.buf.WriteByte(')')
+		return
+	}
+	p.expr(node)
+}
+
+func (p *printer) expr(node Node) {
+	switch node := node.(type) {
+	case *Name:
+		p.buf.WriteString(node.Name)
+	case *Literal:
+		if node.Type == syntax.TSTRING {
+			p.buf.WriteString(strconv.Quote(node.Val))
+			break
+		}
+		p.buf.WriteString(node.Val)
+	case *FuncLit:
+		p.buf.WriteString("func")
+		p.funcRest(node.Func)
+	case *CallExpr:
+		p.expr(node.Fun)
+		p.buf.WriteByte('(')
+		p.exprs(node.Args)
+		p.buf.WriteByte(')')
+	case *UnaryExpr:
+		p.printf("%v", node.Op)
+		p.operand(node.X)
+	case *BinaryExpr:
+		p.operand(node.Lhs)
+		p.printf(" %v ", node.Op)
+		p.operand(node.Rhs)
+	case *ListLit:
+		p.buf.WriteByte('[')
+		p.exprs(node.Elems)
+		p.buf.WriteByte(']')
+	case *MapLit:
+		p.buf.WriteByte('{')
+		p.pairs(node.Keys, node.Values)
+		p.buf.WriteByte('}')
+	case *StructLit:
+		p.expr(node.Type)
+		p.buf.WriteByte('{')
+		if node.Fields == nil {
+			p.exprs(node.Values)
+		} else {
+			for i, field := range node.Fields {
+				if i > 0 {
+					p.buf.WriteString(", ")
+				}
+				p.printf("%s: ", field)
+				p.expr(node.Values[i])
+			}
+		}
+		p.buf.WriteByte('}')
+	case *SelectorExpr:
+		p.expr(node.X)
+		p.printf(".%s", node.Sel)
+	case *IndexExpr:
+		p.expr(node.X)
+		p.buf.WriteByte('[')
+		p.expr(node.Index)
+		p.buf.WriteByte(']')
+	case *SliceExpr:
+		p.expr(node.X)
+		p.buf.WriteByte('[')
+		if node.Lo != nil {
+			p.expr(node.Lo)
+		}
+		p.buf.WriteByte(':')
+		if node.Hi != nil {
+			p.expr(node.Hi)
+		}
+		p.buf.WriteByte(']')
+	case *ListPattern:
+		p.buf.WriteByte('[')
+		p.exprs(node.Elems)
+		if node.Rest != "" {
+			if len(node.Elems) > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.printf("%s...", node.Rest)
+		}
+		p.buf.WriteByte(']')
+	case *MapPattern:
+		p.buf.WriteByte('{')
+		p.pairs(node.Keys, node.Values)
+		p.buf.WriteByte('}')
+	default:
+		p.printf("<%T>", node)
+	}
+}
+
+func (p *printer) pairs(keys, values []Node) {
+	for i := range keys {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.expr(keys[i])
+		p.buf.WriteString(": ")
+		p.expr(values[i])
+	}
+}
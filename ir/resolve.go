@@ -0,0 +1,361 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// Ref is a variable a Name refers to: slot Slot of the frame Depth
+// frames out from the innermost one, or the global Slot when Global
+// is set.
+type Ref struct {
+	Global      bool
+	Depth, Slot int
+}
+
+// object is a name declared in a scope.
+type object struct {
+	slot    int
+	isConst bool
+}
+
+// scope is where the names declared by a func, a statement running
+// in a scope of its own or the program are. A call always gets a
+// frame at run time, so the closures it creates differ from those of
+// other calls; any other scope only does when it declares names.
+// frame is set to the size of it.
+type scope struct {
+	parent *scope
+	global bool
+	call   bool
+	names  map[string]object
+	frame  *int
+}
+
+// use is a Name bound to the local name of scope to, whose Depth is
+// counted once every scope has been resolved and it is known which
+// of them have frames.
+type use struct {
+	name     *Name
+	from, to *scope
+}
+
+// closure is a func literal whose body is resolved once the rest of
+// the program is, so it sees every name declared in the scopes it
+// closes over, as it would when called.
+type closure struct {
+	f     *Func
+	scope *scope
+}
+
+type resolver struct {
+	scope    *scope
+	globals  []string
+	uses     []use
+	closures []closure
+	errs     syntax.ErrorList
+}
+
+// Resolve binds the names of the program nodes to the variables they
+// refer to and gives every declaration its slot, reporting undefined
+// and duplicate names and assignments to constants. The globals are
+// predeclared, such as the builtins, followed by the top level
+// declarations; the returned names are indexed by their slots. The
+// errors are returned as a syntax.ErrorList.
+func Resolve(nodes []Node, predeclared []string) ([]string, error) {
+	r := &resolver{}
+	r.scope = &scope{global: true, names: make(map[string]object)}
+	for _, name := range predeclared {
+		r.global(name, false)
+	}
+	// every top level name is declared before any is used, so
+	// funcs and vars can refer to the ones below them. Those of
+	// the program win over predeclared names they redeclare.
+	declared := make(map[string]bool)
+	top := func(node Node, name string, isConst bool) int {
+		if declared[name] {
+			r.errorf(node, syntax.ErrDuplicate, "%s already declared", name)
+		}
+		declared[name] = true
+		return r.global(name, isConst)
+	}
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *TypeDecl:
+			top(node, node.Name, false)
+		case *Func:
+			if node.RecvType == "" {
+				top(node, node.FuncName, false)
+			}
+		case *VarDecl:
+			node.Slots = make([]int, len(node.Lhs))
+			for i, name := range node.Lhs {
+				node.Slots[i] = top(node, name, node.Const)
+			}
+		}
+	}
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *VarDecl:
+			r.exprs(node.Rhs)
+		case *Func:
+			r.funcBody(node)
+		}
+	}
+	for len(r.closures) > 0 {
+		c := r.closures[0]
+		r.closures = r.closures[1:]
+		r.scope = c.scope
+		r.funcBody(c.f)
+	}
+	for _, u := range r.uses {
+		for s := u.from; s != u.to; s = s.parent {
+			if s.call || len(s.names) > 0 {
+				u.name.Ref.Depth++
+			}
+		}
+	}
+	r.errs.Sort()
+	return r.globals, r.errs.Err()
+}
+
+func (r *resolver) errorf(node Node, code syntax.ErrorCode, format string, args ...interface{}) {
+	r.errs.Add(node.Pos(), code, fmt.Sprintf(format, args...))
+}
+
+// global declares the global name, which keeps its slot if it is
+// declared again.
+func (r *resolver) global(name string, isConst bool) int {
+	obj, ok := r.scope.names[name]
+	if !ok {
+		obj.slot = len(r.globals)
+		r.globals = append(r.globals, name)
+	}
+	obj.isConst = isConst
+	r.scope.names[name] = obj
+	return obj.slot
+}
+
+// open starts a scope, whose frame size goes in frame.
+func (r *resolver) open(frame *int) {
+	r.scope = &scope{parent: r.scope, names: make(map[string]object), frame: frame}
+}
+
+func (r *resolver) close() {
+	*r.scope.frame = len(r.scope.names)
+	r.scope = r.scope.parent
+}
+
+// declare declares name in the current scope at pos and returns its
+// slot. The name _ may be declared again.
+func (r *resolver) declare(pos syntax.Pos, name string, isConst bool) int {
+	if obj, ok := r.scope.names[name]; ok {
+		if name != "_" {
+			r.errs.Add(pos, syntax.ErrDuplicate, fmt.Sprintf("%s already declared", name))
+		}
+		return obj.slot
+	}
+	slot := len(r.scope.names)
+	r.scope.names[name] = object{slot: slot, isConst: isConst}
+	return slot
+}
+
+// bind declares a name a pattern binds. The patterns of the values
+// of a case share the scope, so a name may be bound by more than one
+// of them, but only once by each: bound holds the names bound by the
+// current one.
+func (r *resolver) bind(node Node, name string, bound map[string]bool) int {
+	if bound[name] {
+		r.errorf(node, syntax.ErrDuplicate, "%s bound twice in pattern", name)
+	}
+	bound[name] = true
+	if obj, ok := r.scope.names[name]; ok {
+		return obj.slot
+	}
+	return r.declare(node.Pos(), name, false)
+}
+
+// lookup binds n to the variable it refers to. When it is assigned
+// to, it must not be a constant.
+func (r *resolver) lookup(n *Name, assign bool) {
+	for s := r.scope; s != nil; s = s.parent {
+		obj, ok := s.names[n.Name]
+		if !ok {
+			continue
+		}
+		if assign && obj.isConst {
+			r.errorf(n, syntax.ErrConst, "cannot assign to %s (constant)", n.Name)
+		}
+		n.Ref = Ref{Global: s.global, Slot: obj.slot}
+		if !s.global {
+			r.uses = append(r.uses, use{name: n, from: r.scope, to: s})
+		}
+		return
+	}
+	r.errorf(n, syntax.ErrUndefined, "undefined: %s", n.Name)
+}
+
+func (r *resolver) funcBody(f *Func) {
+	r.open(&f.Frame)
+	r.scope.call = true
+	if f.Recv != "" {
+		r.declare(f.Pos(), f.Recv, false)
+	}
+	for i, arg := range f.Args {
+		r.declare(f.ArgPos[i], arg, false)
+	}
+	r.stmts(f.Body)
+	r.close()
+}
+
+func (r *resolver) stmts(stmts []Node) {
+	for _, stmt := range stmts {
+		r.stmt(stmt)
+	}
+}
+
+// scoped resolves node in a scope of its own.
+func (r *resolver) scoped(node Node, frame *int) {
+	r.open(frame)
+	r.stmt(node)
+	r.close()
+}
+
+func (r *resolver) stmt(node Node) {
+	switch node := node.(type) {
+	case nil:
+	case *VarDecl:
+		// the values are evaluated before the names are
+		// declared, so var x = x refers to an outer x.
+		r.exprs(node.Rhs)
+		node.Slots = make([]int, len(node.Lhs))
+		for i, name := range node.Lhs {
+			node.Slots[i] = r.declare(node.Pos(), name, node.Const)
+		}
+	case *AssignStmt:
+		for _, target := range node.Lhs {
+			if name, ok := target.(*Name); ok {
+				r.lookup(name, true)
+				continue
+			}
+			r.expr(target)
+		}
+		r.exprs(node.Rhs)
+	case *ReturnStmt:
+		r.exprs(node.Returns)
+	case *BreakStmt, *ContinueStmt:
+	case *BlockStmt:
+		r.stmts(node.Stmts)
+	case *IfStmt:
+		r.expr(node.Cond)
+		r.scoped(node.Body, &node.BodyFrame)
+		r.scoped(node.Else, &node.ElseFrame)
+	case *ForStmt:
+		r.open(&node.Frame)
+		r.stmt(node.Init)
+		r.expr(node.Cond)
+		r.scoped(node.Body, &node.BodyFrame)
+		r.stmt(node.Post)
+		r.close()
+	case *WhileStmt:
+		r.expr(node.Cond)
+		r.scoped(node.Body, &node.Frame)
+	case *LoopStmt:
+		r.scoped(node.Body, &node.Frame)
+	case *RangeStmt:
+		r.expr(node.X)
+		r.open(&node.Frame)
+		if node.Key != "" {
+			r.declare(node.Pos(), node.Key, false)
+		}
+		r.declare(node.Pos(), node.Value, false)
+		r.scoped(node.Body, &node.BodyFrame)
+		r.close()
+	case *SwitchStmt:
+		r.expr(node.Tag)
+		for _, clause := range node.Cases {
+			r.open(&clause.Frame)
+			for _, value := range clause.Values {
+				switch value.(type) {
+				case *ListPattern, *MapPattern:
+					r.pattern(value, make(map[string]bool))
+				default:
+					r.expr(value)
+				}
+			}
+			r.stmts(clause.Body)
+			r.close()
+		}
+	default:
+		r.expr(node)
+	}
+}
+
+// pattern resolves a case pattern, declaring the names it binds.
+func (r *resolver) pattern(node Node, bound map[string]bool) {
+	switch node := node.(type) {
+	case *Name:
+		if node.Name != "_" {
+			node.Ref = Ref{Slot: r.bind(node, node.Name, bound)}
+		}
+	case *ListPattern:
+		for _, elem := range node.Elems {
+			r.pattern(elem, bound)
+		}
+		if node.Rest != "" {
+			node.RestSlot = r.bind(node, node.Rest, bound)
+		}
+	case *MapPattern:
+		r.exprs(node.Keys)
+		for _, value := range node.Values {
+			r.pattern(value, bound)
+		}
+	default:
+		r.expr(node)
+	}
+}
+
+func (r *resolver) exprs(nodes []Node) {
+	for _, node := range nodes {
+		r.expr(node)
+	}
+}
+
+func (r *resolver) expr(node Node) {
+	switch node := node.(type) {
+	case nil:
+	case *Name:
+		r.lookup(node, false)
+	case *Literal:
+	case *FuncLit:
+		r.closures = append(r.closures, closure{f: node.Func, scope: r.scope})
+	case *CallExpr:
+		r.expr(node.Fun)
+		r.exprs(node.Args)
+	case *UnaryExpr:
+		r.expr(node.X)
+	case *BinaryExpr:
+		r.expr(node.Lhs)
+		r.expr(node.Rhs)
+	case *ListLit:
+		r.exprs(node.Elems)
+	case *MapLit:
+		r.exprs(node.Keys)
+		r.exprs(node.Values)
+	case *StructLit:
+		r.expr(node.Type)
+		r.exprs(node.Values)
+	case *SelectorExpr:
+		r.expr(node.X)
+	case *IndexExpr:
+		r.expr(node.X)
+		r.expr(node.Index)
+	case *SliceExpr:
+		r.expr(node.X)
+		r.expr(node.Lo)
+		r.expr(node.Hi)
+	default:
+		panic(fmt.Sprintf("unknown node %T", node))
+	}
+}
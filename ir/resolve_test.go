@@ -0,0 +1,72 @@
+package ir
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// resolve resolves src, with print predeclared, and returns its
+// errors.
+func resolve(t *testing.T, src string) []string {
+	file, err := syntax.Parse("x.toy", []byte(src))
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	var errs []string
+	if _, err := Resolve(GenAst(file), []string{"print"}); err != nil {
+		for _, e := range err.(syntax.ErrorList) {
+			errs = append(errs, e.Error())
+		}
+	}
+	return errs
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		src  string
+		errs []string
+	}{
+		{"func main() { print(f()) }\nfunc f() { return g }\nvar g = 1", nil},
+		{"func f(a, _, _) { var b = a; if a { var b = 2; print(b) } }", nil},
+		{"func f(n) { return func() { return n + m } }\nvar m = 1", nil},
+		{"func f() { for var i = 0; i < 3; i++ { var i = 1; print(i) } }", nil},
+		{"func f(xs) { for k, v in xs { var k = 1; var v = k; print(v) } }", nil},
+		{"func f() { print(x) }", []string{
+			"x.toy:1:18: undefined: x",
+		}},
+		{"func f() { var a = a }", []string{
+			"x.toy:1:20: undefined: a",
+		}},
+		{"func f(a, a) {}", []string{
+			"x.toy:1:11: a already declared",
+		}},
+		{"func f(a b a...) {}", []string{
+			"x.toy:1:12: a already declared",
+		}},
+		{"var g = func(x, y, x) {}", []string{
+			"x.toy:1:20: x already declared",
+		}},
+		{"func (p Point) m(p) {}\ntype Point struct { x }", []string{
+			"x.toy:1:18: p already declared",
+		}},
+		{"func f() { var a = 1; var a = 2 }", []string{
+			"x.toy:1:23: a already declared",
+		}},
+		{"func f() {}\nfunc f() {}", []string{
+			"x.toy:2:1: f already declared",
+		}},
+		{"const c = 1\nfunc f() { c = 2 }", []string{
+			"x.toy:2:12: cannot assign to c (constant)",
+		}},
+		{"func f(v) { switch v { case [a, a]: print(a) } }", []string{
+			"x.toy:1:33: a bound twice in pattern",
+		}},
+	}
+	for _, test := range tests {
+		if errs := resolve(t, test.src); !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q:\ngot  %q\nwant %q", test.src, errs, test.errs)
+		}
+	}
+}
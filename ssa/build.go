@@ -0,0 +1,648 @@
+package ssa
+
+import (
+	"fmt"
+
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// Build lowers the program nodes, whose names were bound by
+// ir.Resolve, and puts every func in SSA form. An error means the
+// result failed Verify.
+func Build(nodes []ir.Node) (*Program, error) {
+	prog := &Program{}
+	var decls []*ir.VarDecl
+	for _, node := range nodes {
+		if d, ok := node.(*ir.VarDecl); ok {
+			decls = append(decls, d)
+		}
+	}
+	if decls != nil {
+		f := &Func{Name: "init"}
+		prog.Funcs = append(prog.Funcs, f)
+		bl := &builder{prog: prog, f: f}
+		bl.b = f.newBlock()
+		for _, d := range decls {
+			for i, v := range bl.values(d, d.Rhs, len(d.Lhs)) {
+				bl.value(d, OpSetGlobal, v).Aux = d.Lhs[i]
+			}
+		}
+		bl.ret(nil)
+	}
+	for _, node := range nodes {
+		if f, ok := node.(*ir.Func); ok {
+			name := f.FuncName
+			if f.RecvType != "" {
+				name = f.RecvType + "." + name
+			}
+			buildFunc(prog, name, f, nil)
+		}
+	}
+	// a local is only known not to be captured once every closure
+	// is lowered.
+	for _, f := range prog.Funcs {
+		f.construct()
+	}
+	for _, f := range prog.Funcs {
+		if err := Verify(f); err != nil {
+			return prog, err
+		}
+	}
+	return prog, nil
+}
+
+// frame holds the locals of a frame of the walker, by slot.
+type frame struct {
+	vars []*Var
+}
+
+// branch is a loop or switch that break and continue can jump out
+// of; cont is nil for a switch.
+type branch struct {
+	label      string
+	cont, exit *Block
+}
+
+// builder lowers a func. It keeps the frames the walker would have,
+// so the Refs of names find their locals: those of the funcs the
+// closure being lowered is created in come first.
+type builder struct {
+	prog *Program
+	f    *Func
+	// b is the block being built, nil after a jump until the next
+	// block starts.
+	b        *Block
+	frames   []*frame
+	outer    int
+	branches []*branch
+	closures int
+}
+
+// buildFunc lowers fn, a closure over the frames outer when they are
+// set, to a new Func of prog.
+func buildFunc(prog *Program, name string, fn *ir.Func, outer []*frame) *Func {
+	f := &Func{Name: name, Node: fn}
+	prog.Funcs = append(prog.Funcs, f)
+	bl := &builder{prog: prog, f: f, outer: len(outer)}
+	bl.frames = append([]*frame(nil), outer...)
+	bl.b = f.newBlock()
+	// a call always gets a frame.
+	bl.frames = append(bl.frames, &frame{vars: make([]*Var, fn.Frame)})
+	params := fn.Args
+	if fn.Recv != "" {
+		params = append([]string{fn.Recv}, params...)
+	}
+	for i, name := range params {
+		p := bl.value(fn, OpParam)
+		p.Aux, p.AuxInt = name, i
+		f.Params = append(f.Params, p)
+		bl.declare(fn, i, name, p)
+	}
+	bl.stmts(fn.Body)
+	bl.ret(nil)
+	return f
+}
+
+// value adds a value to the block being built. Code after a jump is
+// put in a new block no jump goes to, which construct drops.
+func (bl *builder) value(node ir.Node, op Op, args ...*Value) *Value {
+	if bl.b == nil {
+		bl.b = bl.f.newBlock()
+	}
+	var pos syntax.Pos
+	if node != nil {
+		pos = node.Pos()
+	}
+	v := bl.f.newValue(op, pos, args...)
+	v.Block = bl.b
+	bl.b.Values = append(bl.b.Values, v)
+	return v
+}
+
+// jump ends the block being built with a jump to to.
+func (bl *builder) jump(to *Block) {
+	if bl.b == nil {
+		return
+	}
+	bl.b.Kind = BlockPlain
+	addEdge(bl.b, to)
+	bl.b = nil
+}
+
+// branchIf ends the block being built, where cond was computed, with
+// a branch on it.
+func (bl *builder) branchIf(cond *Value, then, els *Block) {
+	b := bl.b
+	b.Kind = BlockIf
+	b.Controls = []*Value{cond}
+	addEdge(b, then)
+	addEdge(b, els)
+	bl.b = nil
+}
+
+// ret ends the block being built with a return of results.
+func (bl *builder) ret(results []*Value) {
+	if bl.b == nil {
+		return
+	}
+	bl.b.Kind = BlockReturn
+	bl.b.Controls = results
+	bl.b = nil
+}
+
+// push enters a scope with a frame of n slots at run time.
+func (bl *builder) push(n int) {
+	if n > 0 {
+		bl.frames = append(bl.frames, &frame{vars: make([]*Var, n)})
+	}
+}
+
+func (bl *builder) pop(n int) {
+	if n > 0 {
+		bl.frames = bl.frames[:len(bl.frames)-1]
+	}
+}
+
+// local returns the local of slot of the frame depth frames out,
+// made when it is first referred to, which a closure may do before
+// it is declared.
+func (bl *builder) local(depth, slot int, name string) *Var {
+	i := len(bl.frames) - 1 - depth
+	fr := bl.frames[i]
+	v := fr.vars[slot]
+	if v == nil {
+		v = &Var{Name: name}
+		fr.vars[slot] = v
+	}
+	if i < bl.outer {
+		v.Captured = true
+	}
+	return v
+}
+
+// declare declares the local name of slot in the innermost frame.
+func (bl *builder) declare(node ir.Node, slot int, name string, v *Value) {
+	bl.value(node, OpDeclare, v).Aux = bl.local(0, slot, name)
+}
+
+func (bl *builder) stmts(stmts []ir.Node) {
+	for _, stmt := range stmts {
+		bl.stmt(stmt)
+	}
+}
+
+// scoped lowers node in a scope of its own, with a frame of n slots.
+func (bl *builder) scoped(node ir.Node, n int) {
+	bl.push(n)
+	bl.stmt(node)
+	bl.pop(n)
+}
+
+func (bl *builder) stmt(node ir.Node) {
+	switch node := node.(type) {
+	case nil:
+	case *ir.VarDecl:
+		for i, v := range bl.values(node, node.Rhs, len(node.Lhs)) {
+			bl.declare(node, node.Slots[i], node.Lhs[i], v)
+		}
+	case *ir.AssignStmt:
+		bl.assign(node)
+	case *ir.ReturnStmt:
+		results := []*Value{}
+		for _, expr := range node.Returns {
+			results = append(results, bl.spread(expr))
+		}
+		bl.ret(results)
+	case *ir.BreakStmt:
+		bl.jump(bl.target(node.Label, false).exit)
+	case *ir.ContinueStmt:
+		bl.jump(bl.target(node.Label, true).cont)
+	case *ir.BlockStmt:
+		bl.stmts(node.Stmts)
+	case *ir.IfStmt:
+		cond := bl.expr(node.Cond)
+		then, join := bl.f.newBlock(), bl.f.newBlock()
+		els := join
+		if node.Else != nil {
+			els = bl.f.newBlock()
+		}
+		bl.branchIf(cond, then, els)
+		bl.b = then
+		bl.scoped(node.Body, node.BodyFrame)
+		bl.jump(join)
+		if node.Else != nil {
+			bl.b = els
+			bl.scoped(node.Else, node.ElseFrame)
+			bl.jump(join)
+		}
+		bl.b = join
+	case *ir.ForStmt:
+		bl.push(node.Frame)
+		bl.stmt(node.Init)
+		header, body, post, exit := bl.f.newBlock(), bl.f.newBlock(), bl.f.newBlock(), bl.f.newBlock()
+		bl.jump(header)
+		bl.b = header
+		if node.Cond != nil {
+			bl.branchIf(bl.expr(node.Cond), body, exit)
+		} else {
+			bl.jump(body)
+		}
+		bl.b = body
+		bl.loop(node.Label, post, exit, node.Body, node.BodyFrame)
+		bl.b = post
+		bl.stmt(node.Post)
+		bl.jump(header)
+		bl.b = exit
+		bl.pop(node.Frame)
+	case *ir.WhileStmt:
+		header, body, exit := bl.f.newBlock(), bl.f.newBlock(), bl.f.newBlock()
+		bl.jump(header)
+		bl.b = header
+		bl.branchIf(bl.expr(node.Cond), body, exit)
+		bl.b = body
+		bl.loop(node.Label, header, exit, node.Body, node.Frame)
+		bl.b = exit
+	case *ir.LoopStmt:
+		body, exit := bl.f.newBlock(), bl.f.newBlock()
+		bl.jump(body)
+		bl.b = body
+		bl.loop(node.Label, body, exit, node.Body, node.Frame)
+		bl.b = exit
+	case *ir.RangeStmt:
+		it := bl.value(node, OpIter, bl.expr(node.X))
+		header, body, exit := bl.f.newBlock(), bl.f.newBlock(), bl.f.newBlock()
+		bl.jump(header)
+		bl.b = header
+		bl.branchIf(bl.value(node, OpNext, it), body, exit)
+		bl.b = body
+		bl.push(node.Frame)
+		slot := 0
+		if node.Key != "" {
+			bl.declare(node, slot, node.Key, bl.value(node, OpKey, it))
+			slot++
+		}
+		bl.declare(node, slot, node.Value, bl.value(node, OpElemValue, it))
+		bl.loop(node.Label, header, exit, node.Body, node.BodyFrame)
+		bl.pop(node.Frame)
+		bl.b = exit
+	case *ir.SwitchStmt:
+		bl.switchStmt(node)
+	case *ir.CallExpr:
+		// the results of a call statement are dropped, however many.
+		bl.call(node, -1)
+	default:
+		bl.expr(node)
+	}
+}
+
+// loop lowers the body of a loop in a new frame of n slots, then
+// jumps to cont, where continue goes too.
+func (bl *builder) loop(label string, cont, exit *Block, body ir.Node, n int) {
+	bl.branches = append(bl.branches, &branch{label: label, cont: cont, exit: exit})
+	bl.scoped(body, n)
+	bl.branches = bl.branches[:len(bl.branches)-1]
+	bl.jump(cont)
+}
+
+// target returns the loop or switch a break or continue jumps out of.
+func (bl *builder) target(label string, isContinue bool) *branch {
+	for i := len(bl.branches) - 1; i >= 0; i-- {
+		b := bl.branches[i]
+		if label != "" && b.label == label || label == "" && (b.cont != nil || !isContinue) {
+			return b
+		}
+	}
+	panic("jump without target")
+}
+
+// switchStmt lowers node like the walker runs it: each case value is
+// tried in the frame of its case, which holds the names its pattern
+// binds and which the body of the case then runs in.
+func (bl *builder) switchStmt(node *ir.SwitchStmt) {
+	var tag *Value
+	if node.Tag != nil {
+		tag = bl.expr(node.Tag)
+	}
+	frames := make([]*frame, len(node.Cases))
+	bodies := make([]*Block, len(node.Cases))
+	for i, clause := range node.Cases {
+		frames[i] = &frame{vars: make([]*Var, clause.Frame)}
+		bodies[i] = bl.f.newBlock()
+	}
+	exit := bl.f.newBlock()
+	// with frames, as push would.
+	with := func(i int, lower func()) {
+		if node.Cases[i].Frame > 0 {
+			bl.frames = append(bl.frames, frames[i])
+		}
+		lower()
+		bl.pop(node.Cases[i].Frame)
+	}
+	def := -1
+	for i, clause := range node.Cases {
+		if clause.Values == nil {
+			def = i
+		}
+		for _, value := range clause.Values {
+			next := bl.f.newBlock()
+			with(i, func() {
+				_, isList := value.(*ir.ListPattern)
+				_, isMap := value.(*ir.MapPattern)
+				switch {
+				case tag != nil && (isList || isMap):
+					bl.match(value, tag, next)
+				case tag != nil:
+					eq := bl.value(value, OpBinary, tag, bl.expr(value))
+					eq.Aux = syntax.OpEQ
+					bl.test(eq, next)
+				default:
+					bl.test(bl.expr(value), next)
+				}
+			})
+			bl.jump(bodies[i])
+			bl.b = next
+		}
+	}
+	if def >= 0 {
+		bl.jump(bodies[def])
+	} else {
+		bl.jump(exit)
+	}
+	bl.branches = append(bl.branches, &branch{label: node.Label, exit: exit})
+	for i, clause := range node.Cases {
+		bl.b = bodies[i]
+		with(i, func() {
+			bl.stmts(clause.Body)
+		})
+		if clause.Fallthrough && i+1 < len(node.Cases) {
+			bl.jump(bodies[i+1])
+			continue
+		}
+		bl.jump(exit)
+	}
+	bl.branches = bl.branches[:len(bl.branches)-1]
+	bl.b = exit
+}
+
+// test goes on in a new block when cond is true, and to fail when it
+// is false.
+func (bl *builder) test(cond *Value, fail *Block) {
+	ok := bl.f.newBlock()
+	bl.branchIf(cond, ok, fail)
+	bl.b = ok
+}
+
+// match lowers matching pattern against x, declaring the names it
+// binds; a failed match goes to fail.
+func (bl *builder) match(pattern ir.Node, x *Value, fail *Block) {
+	switch pattern := pattern.(type) {
+	case *ir.Name:
+		if pattern.Name != "_" {
+			bl.declare(pattern, pattern.Ref.Slot, pattern.Name, x)
+		}
+	case *ir.ListPattern:
+		ok := bl.value(pattern, OpMatchList, x)
+		ok.Aux, ok.AuxInt = pattern.Rest != "", len(pattern.Elems)
+		bl.test(ok, fail)
+		for i, elem := range pattern.Elems {
+			e := bl.value(elem, OpElem, x)
+			e.AuxInt = i
+			bl.match(elem, e, fail)
+		}
+		if pattern.Rest != "" {
+			rest := bl.value(pattern, OpRest, x)
+			rest.AuxInt = len(pattern.Elems)
+			bl.declare(pattern, pattern.RestSlot, pattern.Rest, rest)
+		}
+	case *ir.MapPattern:
+		bl.test(bl.value(pattern, OpIsMap, x), fail)
+		for i, key := range pattern.Keys {
+			lookup := bl.value(key, OpLookup, x, bl.expr(key))
+			found := bl.value(key, OpResult, lookup)
+			found.AuxInt = 1
+			bl.test(found, fail)
+			bl.match(pattern.Values[i], bl.value(key, OpResult, lookup), fail)
+		}
+	default:
+		eq := bl.value(pattern, OpBinary, x, bl.expr(pattern))
+		eq.Aux = syntax.OpEQ
+		bl.test(eq, fail)
+	}
+}
+
+// values lowers the right hand side exprs of an assignment to n
+// variables.
+func (bl *builder) values(node ir.Node, exprs []ir.Node, n int) []*Value {
+	if len(exprs) == 1 {
+		if call, ok := exprs[0].(*ir.CallExpr); ok {
+			return bl.results(node, bl.call(call, n), n)
+		}
+	}
+	var vals []*Value
+	for _, expr := range exprs {
+		vals = append(vals, bl.expr(expr))
+	}
+	if len(vals) == n {
+		return vals
+	}
+	unpack := bl.value(node, OpUnpack, vals...)
+	unpack.AuxInt = n
+	return bl.results(node, unpack, n)
+}
+
+// results returns the first n elements of the tuple t.
+func (bl *builder) results(node ir.Node, t *Value, n int) []*Value {
+	vals := make([]*Value, n)
+	for i := range vals {
+		vals[i] = bl.value(node, OpResult, t)
+		vals[i].AuxInt = i
+	}
+	return vals
+}
+
+// assign lowers node like the walker's target: the operands of every
+// target are evaluated and checked before the values.
+func (bl *builder) assign(node *ir.AssignStmt) {
+	if node.Op != 0 {
+		target := node.Lhs[0]
+		operands := bl.operands(target)
+		var old *Value
+		switch target := target.(type) {
+		case *ir.Name:
+			old = bl.expr(target)
+		case *ir.IndexExpr:
+			old = bl.value(target, OpIndex, operands...)
+		case *ir.SelectorExpr:
+			old = bl.value(target, OpField, operands...)
+			old.Aux = target.Sel
+		}
+		v := bl.value(node, OpBinary, old, bl.expr(node.Rhs[0]))
+		v.Aux = node.Op
+		bl.store(target, operands, v)
+		return
+	}
+	operands := make([][]*Value, len(node.Lhs))
+	for i, target := range node.Lhs {
+		operands[i] = bl.operands(target)
+	}
+	for i, v := range bl.values(node, node.Rhs, len(node.Lhs)) {
+		bl.store(node.Lhs[i], operands[i], v)
+	}
+}
+
+// operands lowers the operands of target and checks them.
+func (bl *builder) operands(target ir.Node) []*Value {
+	switch target := target.(type) {
+	case *ir.Name:
+		return nil
+	case *ir.IndexExpr:
+		x, k := bl.expr(target.X), bl.expr(target.Index)
+		bl.value(target, OpCheckIndex, x, k)
+		return []*Value{x, k}
+	case *ir.SelectorExpr:
+		x := bl.expr(target.X)
+		bl.value(target, OpCheckField, x).Aux = target.Sel
+		return []*Value{x}
+	}
+	panic("unknown assign target")
+}
+
+// store sets target, whose operands are given, to v.
+func (bl *builder) store(target ir.Node, operands []*Value, v *Value) {
+	switch target := target.(type) {
+	case *ir.Name:
+		if target.Ref.Global {
+			bl.value(target, OpSetGlobal, v).Aux = target.Name
+			return
+		}
+		bl.value(target, OpStore, v).Aux = bl.local(target.Ref.Depth, target.Ref.Slot, target.Name)
+	case *ir.IndexExpr:
+		bl.value(target, OpSetIndex, operands[0], operands[1], v)
+	case *ir.SelectorExpr:
+		bl.value(target, OpSetField, operands[0], v).Aux = target.Sel
+	}
+}
+
+// spread lowers node as an expression that may have any number of
+// values, as a call argument or return value.
+func (bl *builder) spread(node ir.Node) *Value {
+	if call, ok := node.(*ir.CallExpr); ok {
+		return bl.call(call, -1)
+	}
+	return bl.expr(node)
+}
+
+// call lowers a call keeping want of its results, or all of them
+// when it is -1.
+func (bl *builder) call(node *ir.CallExpr, want int) *Value {
+	args := []*Value{bl.expr(node.Fun)}
+	for _, arg := range node.Args {
+		args = append(args, bl.spread(arg))
+	}
+	call := bl.value(node, OpCall, args...)
+	call.AuxInt = want
+	return call
+}
+
+// expr lowers node as an expression with exactly one value.
+func (bl *builder) expr(node ir.Node) *Value {
+	switch node := node.(type) {
+	case *ir.Name:
+		if node.Ref.Global {
+			v := bl.value(node, OpGlobal)
+			v.Aux = node.Name
+			return v
+		}
+		v := bl.value(node, OpLoad)
+		v.Aux = bl.local(node.Ref.Depth, node.Ref.Slot, node.Name)
+		return v
+	case *ir.Literal:
+		v := bl.value(node, OpConst)
+		v.Aux = node
+		return v
+	case *ir.FuncLit:
+		bl.closures++
+		name := fmt.Sprintf("%s.func%d", bl.f.Name, bl.closures)
+		if node.Func.FuncName != "" {
+			name = bl.f.Name + "." + node.Func.FuncName
+		}
+		v := bl.value(node, OpClosure)
+		v.Aux = buildFunc(bl.prog, name, node.Func, bl.frames)
+		return v
+	case *ir.CallExpr:
+		return bl.results(node, bl.call(node, 1), 1)[0]
+	case *ir.UnaryExpr:
+		v := bl.value(node, OpUnary, bl.expr(node.X))
+		v.Aux = node.Op
+		return v
+	case *ir.BinaryExpr:
+		if node.Op == syntax.OpAND || node.Op == syntax.OpOR {
+			return bl.logic(node)
+		}
+		v := bl.value(node, OpBinary, bl.expr(node.Lhs), bl.expr(node.Rhs))
+		v.Aux = node.Op
+		return v
+	case *ir.ListLit:
+		var elems []*Value
+		for _, elem := range node.Elems {
+			elems = append(elems, bl.expr(elem))
+		}
+		return bl.value(node, OpList, elems...)
+	case *ir.MapLit:
+		var pairs []*Value
+		for i := range node.Keys {
+			pairs = append(pairs, bl.expr(node.Keys[i]), bl.expr(node.Values[i]))
+		}
+		return bl.value(node, OpMap, pairs...)
+	case *ir.StructLit:
+		args := []*Value{bl.expr(node.Type)}
+		for _, value := range node.Values {
+			args = append(args, bl.expr(value))
+		}
+		v := bl.value(node, OpStruct, args...)
+		if node.Fields != nil {
+			v.Aux = node.Fields
+		}
+		return v
+	case *ir.SelectorExpr:
+		v := bl.value(node, OpField, bl.expr(node.X))
+		v.Aux = node.Sel
+		return v
+	case *ir.IndexExpr:
+		return bl.value(node, OpIndex, bl.expr(node.X), bl.expr(node.Index))
+	case *ir.SliceExpr:
+		args := []*Value{bl.expr(node.X)}
+		parts := 0
+		if node.Lo != nil {
+			args = append(args, bl.expr(node.Lo))
+			parts |= 1
+		}
+		if node.Hi != nil {
+			args = append(args, bl.expr(node.Hi))
+			parts |= 2
+		}
+		v := bl.value(node, OpSlice, args...)
+		v.AuxInt = parts
+		return v
+	}
+	panic(fmt.Sprintf("unknown expr %T", node))
+}
+
+// logic lowers && and ||, whose right operand only runs when the left
+// one does not decide, to a branch and a phi of the two.
+func (bl *builder) logic(node *ir.BinaryExpr) *Value {
+	l := bl.expr(node.Lhs)
+	rhs, join := bl.f.newBlock(), bl.f.newBlock()
+	if node.Op == syntax.OpAND {
+		bl.branchIf(l, rhs, join)
+	} else {
+		bl.branchIf(l, join, rhs)
+	}
+	bl.b = rhs
+	r := bl.value(node.Rhs, OpCheckBool, bl.expr(node.Rhs))
+	r.Aux = node.Op
+	bl.jump(join)
+	bl.b = join
+	return bl.value(node, OpPhi, l, r)
+}
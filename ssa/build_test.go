@@ -0,0 +1,138 @@
+package ssa
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cuiweixie/toylang/eval"
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// TestBuild lowers the programs the eval tests run, as they are and
+// optimized, and checks that every func is well formed. The programs
+// testing errors reported before they run are left out.
+func TestBuild(t *testing.T) {
+	files, err := filepath.Glob("../eval/testdata/*.toy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		for _, optimize := range []bool{false, true} {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in, err := eval.New(file, src)
+			if err != nil {
+				continue
+			}
+			if optimize {
+				in.SetPasses(ir.NewPassManager())
+			}
+			nodes, err := in.IR()
+			if err != nil {
+				continue
+			}
+			prog, err := Build(nodes)
+			if err != nil {
+				t.Errorf("%s, optimized %v: %v", file, optimize, err)
+				continue
+			}
+			for _, f := range prog.Funcs {
+				if err := Verify(f); err != nil {
+					t.Errorf("%s, optimized %v: %v", file, optimize, err)
+				}
+			}
+		}
+	}
+}
+
+// diamond returns the func
+//
+//	b0: v0 = param, if v0 -> b1 b2
+//	b1: jump b3
+//	b2: jump b3
+//	b3: v1 = phi v0 v0, ret v1
+func diamond() *Func {
+	f := &Func{Name: "f"}
+	var b [4]*Block
+	for i := range b {
+		b[i] = f.newBlock()
+	}
+	p := add(b[0], OpParam)
+	b[0].Kind, b[0].Controls = BlockIf, []*Value{p}
+	addEdge(b[0], b[1])
+	addEdge(b[0], b[2])
+	for _, pred := range b[1:3] {
+		pred.Kind = BlockPlain
+		addEdge(pred, b[3])
+	}
+	phi := add(b[3], OpPhi, p, p)
+	b[3].Kind, b[3].Controls = BlockReturn, []*Value{phi}
+	return f
+}
+
+func add(b *Block, op Op, args ...*Value) *Value {
+	v := b.Func.newValue(op, syntax.Pos{}, args...)
+	v.Block = b
+	b.Values = append(b.Values, v)
+	return v
+}
+
+// TestVerify breaks a well formed func in the ways Verify reports.
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(f *Func)
+		err    string
+	}{
+		{"ok", func(f *Func) {}, ""},
+		{"entry pred", func(f *Func) {
+			addEdge(f.Blocks[3], f.Blocks[0])
+		}, "entry b0 has 1 preds"},
+		{"unreachable", func(f *Func) {
+			f.newBlock().Kind = BlockReturn
+		}, "b4 is unreachable"},
+		{"succs", func(f *Func) {
+			f.Blocks[1].Kind = BlockIf
+		}, "if b1 has 1 succs"},
+		{"phi args", func(f *Func) {
+			phi := f.Blocks[3].Values[0]
+			phi.Args = phi.Args[:1]
+		}, "v1 has 1 args for 2 preds"},
+		{"phi after value", func(f *Func) {
+			b := f.Blocks[3]
+			c := add(b, OpConst)
+			b.Values = []*Value{c, b.Values[0]}
+		}, "v1 in b3 follows a value that is not a phi"},
+		{"copy", func(f *Func) {
+			add(f.Blocks[1], OpCopy, f.Blocks[0].Values[0])
+		}, "v2 is a copy"},
+		{"use before def", func(f *Func) {
+			b := f.Blocks[0]
+			p := b.Values[0]
+			b.Values = []*Value{add(b, OpUnary, p), p}
+		}, "v2 uses v0 before it is defined"},
+		{"not dominated", func(f *Func) {
+			c := add(f.Blocks[1], OpConst)
+			f.Blocks[3].Controls[0] = c
+		}, "the end of b3 uses v2, whose b1 does not dominate b3"},
+		{"uncaptured local", func(f *Func) {
+			add(f.Blocks[1], OpLoad).Aux = &Var{Name: "x"}
+		}, "v2 of a local that is not captured"},
+	}
+	for _, test := range tests {
+		f := diamond()
+		test.mutate(f)
+		err := Verify(f)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
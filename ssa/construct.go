@@ -0,0 +1,258 @@
+package ssa
+
+import "github.com/cuiweixie/toylang/syntax"
+
+// construct puts f in SSA form. The blocks no path from the entry
+// reaches are dropped; then the loads of every local no closure
+// captures become the value last stored to it, with phis where paths
+// storing different ones join, placed at the iterated dominance
+// frontiers of the stores by the algorithm of Cytron et al.
+func (f *Func) construct() {
+	f.removeUnreachable()
+	f.Dominators()
+	f.placePhis()
+	f.rename(f.Entry(), make(map[*Var][]*Value))
+	f.removeCopies()
+	f.simplifyPhis()
+}
+
+// promoted returns the local v loads, stores or is a phi of, when
+// it is to be an SSA value.
+func promoted(v *Value) (*Var, bool) {
+	switch v.Op {
+	case OpDeclare, OpLoad, OpStore, OpPhi:
+		x, ok := v.Aux.(*Var)
+		return x, ok && !x.Captured
+	}
+	return nil, false
+}
+
+func (f *Func) removeUnreachable() {
+	reachable := make(map[*Block]bool)
+	for _, b := range postorder(f) {
+		reachable[b] = true
+	}
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if !reachable[b] {
+			continue
+		}
+		var preds []*Block
+		for _, p := range b.Preds {
+			if reachable[p] {
+				preds = append(preds, p)
+			}
+		}
+		// the phis of && and || have an argument for every pred.
+		for _, v := range b.Values {
+			if v.Op != OpPhi {
+				continue
+			}
+			var args []*Value
+			for i, p := range b.Preds {
+				if reachable[p] {
+					args = append(args, v.Args[i])
+				}
+			}
+			v.Args = args
+		}
+		b.Preds = preds
+		blocks = append(blocks, b)
+	}
+	f.Blocks = blocks
+}
+
+// placePhis adds an empty phi of every promoted local to the blocks
+// of the iterated dominance frontier of those storing to it.
+func (f *Func) placePhis() {
+	var vars []*Var
+	defs := make(map[*Var][]*Block)
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			x, ok := promoted(v)
+			if !ok || v.Op == OpLoad || v.Op == OpPhi {
+				continue
+			}
+			if _, ok := defs[x]; !ok {
+				vars = append(vars, x)
+			}
+			if n := len(defs[x]); n == 0 || defs[x][n-1] != b {
+				defs[x] = append(defs[x], b)
+			}
+		}
+	}
+	df := frontiers(f)
+	for _, x := range vars {
+		has := make(map[*Block]bool)
+		work := append([]*Block(nil), defs[x]...)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, d := range df[b] {
+				if has[d] {
+					continue
+				}
+				has[d] = true
+				phi := f.newValue(OpPhi, syntax.Pos{}, make([]*Value, len(d.Preds))...)
+				phi.Aux, phi.Block = x, d
+				d.Values = append([]*Value{phi}, d.Values...)
+				work = append(work, d)
+			}
+		}
+	}
+}
+
+// rename walks the dominator tree from b, turning every load of a
+// promoted local into a copy of the value on top of its stack in
+// stacks, and filling in the phis of the successors.
+func (f *Func) rename(b *Block, stacks map[*Var][]*Value) {
+	top := func(x *Var) *Value {
+		if s := stacks[x]; len(s) > 0 {
+			return s[len(s)-1]
+		}
+		return f.undef(x)
+	}
+	var pushed []*Var
+	for _, v := range b.Values {
+		x, ok := promoted(v)
+		if !ok {
+			continue
+		}
+		switch v.Op {
+		case OpPhi:
+			stacks[x] = append(stacks[x], v)
+		case OpDeclare, OpStore:
+			stacks[x] = append(stacks[x], v.Args[0])
+		case OpLoad:
+			v.Op, v.Args, v.Aux = OpCopy, []*Value{top(x)}, nil
+			continue
+		}
+		pushed = append(pushed, x)
+	}
+	for _, s := range b.Succs {
+		for i, p := range s.Preds {
+			if p != b {
+				continue
+			}
+			for _, phi := range s.Values {
+				if x, ok := promoted(phi); ok && phi.Op == OpPhi {
+					phi.Args[i] = top(x)
+				}
+			}
+		}
+	}
+	for _, d := range b.Dominees {
+		f.rename(d, stacks)
+	}
+	for _, x := range pushed {
+		stacks[x] = stacks[x][:len(stacks[x])-1]
+	}
+}
+
+// copyOf returns the value v is a copy of, or v.
+func copyOf(v *Value) *Value {
+	for v.Op == OpCopy {
+		v = v.Args[0]
+	}
+	return v
+}
+
+// removeCopies makes every use of a copy use the value copied, and
+// drops the copies and the declarations and stores of the promoted
+// locals, which no load is left to read.
+func (f *Func) removeCopies() {
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			for i, a := range v.Args {
+				v.Args[i] = copyOf(a)
+			}
+		}
+		for i, c := range b.Controls {
+			b.Controls[i] = copyOf(c)
+		}
+	}
+	for _, b := range f.Blocks {
+		values := b.Values[:0]
+		for _, v := range b.Values {
+			if _, ok := promoted(v); ok && v.Op != OpPhi || v.Op == OpCopy {
+				continue
+			}
+			values = append(values, v)
+		}
+		b.Values = values
+	}
+}
+
+// simplifyPhis turns the phis that are of one value, but for
+// themselves, into that value until there are none, then drops the
+// phis and undefs no other value needs, however they refer to one
+// another.
+func (f *Func) simplifyPhis() {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for _, phi := range b.Values {
+				if phi.Op != OpPhi {
+					continue
+				}
+				var same *Value
+				trivial := true
+				for _, a := range phi.Args {
+					a = copyOf(a)
+					if a == phi || a == same {
+						continue
+					}
+					if same != nil {
+						trivial = false
+						break
+					}
+					same = a
+				}
+				if trivial && same != nil {
+					phi.Op, phi.Args, phi.Aux = OpCopy, []*Value{same}, nil
+					changed = true
+				}
+			}
+		}
+		f.removeCopies()
+	}
+	live := make(map[*Value]bool)
+	var work []*Value
+	mark := func(v *Value) {
+		if !live[v] {
+			live[v] = true
+			work = append(work, v)
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if v.Op != OpPhi && v.Op != OpUndef {
+				for _, a := range v.Args {
+					mark(a)
+				}
+			}
+		}
+		for _, c := range b.Controls {
+			mark(c)
+		}
+	}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		if v.Op == OpPhi {
+			for _, a := range v.Args {
+				mark(a)
+			}
+		}
+	}
+	for _, b := range f.Blocks {
+		values := b.Values[:0]
+		for _, v := range b.Values {
+			if (v.Op == OpPhi || v.Op == OpUndef) && !live[v] {
+				continue
+			}
+			values = append(values, v)
+		}
+		b.Values = values
+	}
+}
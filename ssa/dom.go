@@ -0,0 +1,122 @@
+package ssa
+
+// postorder returns the blocks of f reachable from its entry, each
+// after the blocks it leads to but for those of back edges.
+func postorder(f *Func) []*Block {
+	type item struct {
+		b    *Block
+		next int
+	}
+	seen := map[*Block]bool{f.Entry(): true}
+	stack := []item{{b: f.Entry()}}
+	var order []*Block
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next == len(top.b.Succs) {
+			order = append(order, top.b)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		s := top.b.Succs[top.next]
+		top.next++
+		if !seen[s] {
+			seen[s] = true
+			stack = append(stack, item{b: s})
+		}
+	}
+	return order
+}
+
+// idoms returns the immediate dominator of every block reachable from
+// the entry of f, which is its own, by the algorithm of Cooper, Harvey
+// and Kennedy.
+func idoms(f *Func) map[*Block]*Block {
+	order := postorder(f)
+	num := make(map[*Block]int, len(order))
+	for i, b := range order {
+		num[b] = i
+	}
+	entry := f.Entry()
+	idom := map[*Block]*Block{entry: entry}
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for num[a] < num[b] {
+				a = idom[a]
+			}
+			for num[b] < num[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		// in reverse postorder, but for the entry, which is last.
+		for i := len(order) - 2; i >= 0; i-- {
+			b := order[i]
+			var d *Block
+			for _, p := range b.Preds {
+				if idom[p] == nil {
+					continue
+				}
+				if d == nil {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if idom[b] != d {
+				idom[b] = d
+				changed = true
+			}
+		}
+	}
+	return idom
+}
+
+// Dominators computes the dominator tree of f, setting the Idom and
+// Dominees of its blocks, all of which must be reachable from the
+// entry. It is run again after a change to the graph.
+func (f *Func) Dominators() {
+	idom := idoms(f)
+	for _, b := range f.Blocks {
+		b.Idom, b.Dominees = nil, nil
+	}
+	for _, b := range f.Blocks {
+		if d := idom[b]; d != nil && d != b {
+			b.Idom = d
+			d.Dominees = append(d.Dominees, b)
+		}
+	}
+}
+
+// Dominates reports whether every path from the entry to b goes
+// through a, by the tree Dominators computed. A block dominates
+// itself.
+func (a *Block) Dominates(b *Block) bool {
+	for ; b != nil; b = b.Idom {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// frontiers returns the dominance frontier of every block: the blocks
+// where its dominance ends, as one of their preds is not dominated.
+func frontiers(f *Func) map[*Block][]*Block {
+	df := make(map[*Block][]*Block)
+	for _, b := range f.Blocks {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			for r := p; r != nil && r != b.Idom; r = r.Idom {
+				if n := len(df[r]); n == 0 || df[r][n-1] != b {
+					df[r] = append(df[r], b)
+				}
+			}
+		}
+	}
+	return df
+}
@@ -0,0 +1,222 @@
+// Package ssa lowers the resolved ir tree of a program to control
+// flow graphs of basic blocks in SSA form, the shared backbone of
+// analyses, optimizers and code generators.
+//
+// Build lowers every func to blocks ending in explicit jumps,
+// branches and returns, where the locals are read and written by
+// load and store values. It then promotes the locals no closure
+// captures to SSA values, placing phis with the dominator tree, and
+// checks the result with Verify. A captured local stays in memory,
+// since a closure may change it during any call.
+package ssa
+
+import (
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// Program is the lowered program: the code of the top level var
+// declarations, named init, the funcs and methods and the closures
+// they create, each after the func it is created in.
+type Program struct {
+	Funcs []*Func
+}
+
+// Func is the control flow graph of a func. Its entry block is
+// Blocks[0].
+type Func struct {
+	Name string
+	// Node is nil for init.
+	Node   *ir.Func
+	Blocks []*Block
+	// Params are the values of the receiver and the arguments, in
+	// the order a call binds them.
+	Params []*Value
+
+	nextValue, nextBlock int
+	undefs               map[*Var]*Value
+}
+
+// Entry returns the block f starts in.
+func (f *Func) Entry() *Block {
+	return f.Blocks[0]
+}
+
+//go:generate stringer -type BlockKind -linecomment func.go
+type BlockKind int
+
+const (
+	_ BlockKind = iota
+	// BlockPlain jumps to Succs[0].
+	BlockPlain // jump
+	// BlockIf goes to Succs[0] when Controls[0] is true and to
+	// Succs[1] when it is false.
+	BlockIf // if
+	// BlockReturn returns Controls from the func; a call with
+	// AuxInt -1 among them returns all its results.
+	BlockReturn // ret
+)
+
+// Block is a basic block: values that run in order, then the jump,
+// branch or return its Kind says.
+type Block struct {
+	ID     int
+	Kind   BlockKind
+	Func   *Func
+	Values []*Value
+	// Controls are the values the end of the block uses.
+	Controls     []*Value
+	Succs, Preds []*Block
+	// Idom is the immediate dominator of the block, nil for the
+	// entry, and Dominees the blocks it is the one of. Dominators
+	// sets both.
+	Idom     *Block
+	Dominees []*Block
+}
+
+// Value is an instruction of a block and the value it computes. Op
+// says what it does with Args, Aux and AuxInt.
+type Value struct {
+	ID     int
+	Op     Op
+	Args   []*Value
+	Aux    interface{}
+	AuxInt int
+	Block  *Block
+	Pos    syntax.Pos
+}
+
+// Var is a local variable, the Aux of the loads and stores of it.
+// Captured is set when a closure refers to it.
+type Var struct {
+	Name     string
+	Captured bool
+}
+
+//go:generate stringer -type Op -linecomment func.go
+type Op int
+
+const (
+	_ Op = iota
+	// OpParam is the parameter AuxInt, whose name is Aux.
+	OpParam // param
+	// OpConst is the *ir.Literal Aux.
+	OpConst // const
+	// OpUndef is the local Aux read where it has no value, as
+	// the walker fails to.
+	OpUndef // undef
+	// OpPhi is Args[i] when the block is entered from Preds[i].
+	OpPhi // phi
+	// OpCopy is Args[0]. It only exists while building.
+	OpCopy // copy
+	// OpGlobal is the global named Aux.
+	OpGlobal // global
+	// OpSetGlobal sets the global named Aux to Args[0].
+	OpSetGlobal // setglobal
+	// OpDeclare declares a new variable for the local Aux, whose
+	// value is Args[0]; a closure created before keeps the old one.
+	OpDeclare // declare
+	// OpLoad is the value of the local Aux.
+	OpLoad // load
+	// OpStore sets the local Aux to Args[0].
+	OpStore // store
+	// OpClosure is a closure of the *Func Aux over the locals it
+	// captures.
+	OpClosure // closure
+	// OpUnary applies the syntax.Op Aux to Args[0].
+	OpUnary // unary
+	// OpBinary applies the syntax.Op Aux to Args[0] and Args[1].
+	OpBinary // binary
+	// OpCheckBool is Args[0], the right operand of the && or ||
+	// Aux, which must be a bool.
+	OpCheckBool // checkbool
+	// OpCall calls Args[0] with Args[1:], an argument that is a
+	// call with AuxInt -1 passing all its results. It is the tuple
+	// of the results, which must number AuxInt unless it is -1.
+	OpCall // call
+	// OpUnpack is the tuple of Args, spread like those of a call,
+	// which must number AuxInt.
+	OpUnpack // unpack
+	// OpResult is element AuxInt of the tuple Args[0].
+	OpResult // result
+	// OpList is a list of Args.
+	OpList // list
+	// OpMap is a map from each even one of Args to the next.
+	OpMap // map
+	// OpStruct is a struct of the type Args[0], whose fields Aux,
+	// a []string, or all fields in order when it is nil, are set to
+	// Args[1:].
+	OpStruct // struct
+	// OpField is the field or method Aux of Args[0].
+	OpField // field
+	// OpIndex is Args[0][Args[1]].
+	OpIndex // index
+	// OpSlice is Args[0][lo:hi]: AuxInt has bit 1 set when lo,
+	// and bit 2 when hi, is in the Args that follow.
+	OpSlice // slice
+	// OpCheckIndex checks that Args[0][Args[1]] can be assigned.
+	OpCheckIndex // checkindex
+	// OpCheckField checks that Args[0] has the field Aux.
+	OpCheckField // checkfield
+	// OpSetIndex sets Args[0][Args[1]] to Args[2].
+	OpSetIndex // setindex
+	// OpSetField sets the field Aux of Args[0] to Args[1].
+	OpSetField // setfield
+	// OpIter starts a for-in loop over Args[0].
+	OpIter // iter
+	// OpNext moves the iterator Args[0] on, and is false when the
+	// loop is over.
+	OpNext // next
+	// OpKey and OpElemValue are the key and the value the iterator
+	// Args[0] is at.
+	OpKey       // key
+	OpElemValue // value
+	// OpMatchList is whether Args[0] is a list of AuxInt elements,
+	// or more when Aux is true.
+	OpMatchList // matchlist
+	// OpElem is element AuxInt of the list Args[0].
+	OpElem // elem
+	// OpRest is a list of the elements of Args[0] from AuxInt on.
+	OpRest // rest
+	// OpIsMap is whether Args[0] is a map.
+	OpIsMap // ismap
+	// OpLookup is the tuple of the value of the key Args[1] in the
+	// map Args[0] and whether it was found.
+	OpLookup // lookup
+)
+
+func (f *Func) newBlock() *Block {
+	b := &Block{ID: f.nextBlock, Func: f}
+	f.nextBlock++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+func (f *Func) newValue(op Op, pos syntax.Pos, args ...*Value) *Value {
+	v := &Value{ID: f.nextValue, Op: op, Args: args, Pos: pos}
+	f.nextValue++
+	return v
+}
+
+// addEdge makes to a successor of from.
+func addEdge(from, to *Block) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// undef returns the value of v where it has none, made once at the
+// start of the entry block.
+func (f *Func) undef(v *Var) *Value {
+	if u, ok := f.undefs[v]; ok {
+		return u
+	}
+	if f.undefs == nil {
+		f.undefs = make(map[*Var]*Value)
+	}
+	entry := f.Entry()
+	u := f.newValue(OpUndef, syntax.Pos{})
+	u.Aux, u.Block = v, entry
+	entry.Values = append([]*Value{u}, entry.Values...)
+	f.undefs[v] = u
+	return u
+}
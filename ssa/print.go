@@ -0,0 +1,127 @@
+package ssa
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// Fprint writes the funcs of prog to w, one block per paragraph:
+//
+//	func f(n)
+//	b0:
+//		v0 = param <n> [0]
+//		v1 = const <0>
+//		v2 = binary <<> v0 v1
+//		if v2 -> b1 b2
+//	b1: <- b0 (idom b0)
+//		...
+func Fprint(w io.Writer, prog *Program) error {
+	var buf bytes.Buffer
+	for i, f := range prog.Funcs {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		f.fprint(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (f *Func) String() string {
+	var buf bytes.Buffer
+	f.fprint(&buf)
+	return buf.String()
+}
+
+func (f *Func) fprint(buf *bytes.Buffer) {
+	var params []string
+	for _, p := range f.Params {
+		params = append(params, fmt.Sprint(p.Aux))
+	}
+	fmt.Fprintf(buf, "func %s(%s)\n", f.Name, strings.Join(params, ", "))
+	for _, b := range f.Blocks {
+		fmt.Fprintf(buf, "b%d:", b.ID)
+		if len(b.Preds) > 0 {
+			buf.WriteString(" <-")
+			for _, p := range b.Preds {
+				fmt.Fprintf(buf, " b%d", p.ID)
+			}
+		}
+		if b.Idom != nil {
+			fmt.Fprintf(buf, " (idom b%d)", b.Idom.ID)
+		}
+		buf.WriteByte('\n')
+		for _, v := range b.Values {
+			fmt.Fprintf(buf, "\t%s\n", v.LongString())
+		}
+		fmt.Fprintf(buf, "\t%s\n", b.LongString())
+	}
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// LongString returns the end of b: "jump b1", "if v3 -> b1 b2" or
+// "ret v4 v5".
+func (b *Block) LongString() string {
+	s := b.Kind.String()
+	for _, c := range b.Controls {
+		s += " " + c.String()
+	}
+	for i, succ := range b.Succs {
+		if i == 0 && b.Kind == BlockIf {
+			s += " ->"
+		}
+		s += " " + succ.String()
+	}
+	return s
+}
+
+func (v *Value) String() string {
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+// LongString returns v with what it computes, as in
+// "v3 = binary <+> v1 v2" or "v5 = call v4 v1 [1]".
+func (v *Value) LongString() string {
+	s := v.String() + " = " + v.Op.String()
+	if v.Aux != nil {
+		s += " <" + auxString(v.Aux) + ">"
+	}
+	for _, a := range v.Args {
+		if a == nil {
+			s += " nil"
+			continue
+		}
+		s += " " + a.String()
+	}
+	switch v.Op {
+	case OpParam, OpCall, OpUnpack, OpResult, OpSlice, OpMatchList, OpElem, OpRest:
+		s += " [" + strconv.Itoa(v.AuxInt) + "]"
+	}
+	return s
+}
+
+func auxString(aux interface{}) string {
+	switch aux := aux.(type) {
+	case *Var:
+		return aux.Name
+	case *Func:
+		return aux.Name
+	case *ir.Literal:
+		if aux.Type == syntax.TSTRING {
+			return strconv.Quote(aux.Val)
+		}
+		return aux.Val
+	case []string:
+		return strings.Join(aux, " ")
+	}
+	return fmt.Sprint(aux)
+}
@@ -0,0 +1,152 @@
+package ssa
+
+import "fmt"
+
+// Verify checks that f is a well formed graph in SSA form: every
+// block is reachable, ends as its Kind says and agrees with its
+// neighbours about the edges between them, phis come first with an
+// argument for every pred, the only locals left in memory are
+// captured ones, and every value is defined before it is used, on
+// every path.
+func Verify(f *Func) error {
+	if len(f.Blocks) == 0 {
+		return fmt.Errorf("%s: no blocks", f.Name)
+	}
+	if n := len(f.Entry().Preds); n > 0 {
+		return fmt.Errorf("%s: entry b%d has %d preds", f.Name, f.Entry().ID, n)
+	}
+	idom := idoms(f)
+	blocks := make(map[*Block]bool)
+	index := make(map[*Value]int)
+	for _, b := range f.Blocks {
+		if blocks[b] {
+			return fmt.Errorf("%s: b%d listed twice", f.Name, b.ID)
+		}
+		blocks[b] = true
+		if b.Func != f {
+			return fmt.Errorf("%s: b%d belongs to another func", f.Name, b.ID)
+		}
+		if idom[b] == nil {
+			return fmt.Errorf("%s: b%d is unreachable", f.Name, b.ID)
+		}
+		for i, v := range b.Values {
+			if _, ok := index[v]; ok {
+				return fmt.Errorf("%s: %v listed twice", f.Name, v)
+			}
+			index[v] = i
+		}
+	}
+	ids := make(map[int]bool)
+	for _, b := range f.Blocks {
+		succs, controls := 0, -1
+		switch b.Kind {
+		case BlockPlain:
+			succs, controls = 1, 0
+		case BlockIf:
+			succs, controls = 2, 1
+		case BlockReturn:
+		default:
+			return fmt.Errorf("%s: b%d has kind %v", f.Name, b.ID, b.Kind)
+		}
+		if len(b.Succs) != succs {
+			return fmt.Errorf("%s: %v b%d has %d succs", f.Name, b.Kind, b.ID, len(b.Succs))
+		}
+		if controls >= 0 && len(b.Controls) != controls {
+			return fmt.Errorf("%s: %v b%d has %d controls", f.Name, b.Kind, b.ID, len(b.Controls))
+		}
+		for _, s := range b.Succs {
+			if !blocks[s] {
+				return fmt.Errorf("%s: b%d goes to b%d of no func", f.Name, b.ID, s.ID)
+			}
+			if count(s.Preds, b) != count(b.Succs, s) {
+				return fmt.Errorf("%s: edge b%d -> b%d is not in the preds", f.Name, b.ID, s.ID)
+			}
+		}
+		for _, p := range b.Preds {
+			if !blocks[p] || count(p.Succs, b) != count(b.Preds, p) {
+				return fmt.Errorf("%s: edge b%d -> b%d is not in the succs", f.Name, p.ID, b.ID)
+			}
+		}
+		phis := true
+		for _, v := range b.Values {
+			if ids[v.ID] {
+				return fmt.Errorf("%s: v%d defined twice", f.Name, v.ID)
+			}
+			ids[v.ID] = true
+			if v.Block != b {
+				return fmt.Errorf("%s: %v is in b%d, not its block", f.Name, v, b.ID)
+			}
+			switch v.Op {
+			case OpPhi:
+				if !phis {
+					return fmt.Errorf("%s: %v in b%d follows a value that is not a phi", f.Name, v, b.ID)
+				}
+				if len(v.Args) != len(b.Preds) {
+					return fmt.Errorf("%s: %v has %d args for %d preds", f.Name, v, len(v.Args), len(b.Preds))
+				}
+			case OpCopy:
+				return fmt.Errorf("%s: %v is a copy", f.Name, v)
+			case OpDeclare, OpLoad, OpStore:
+				if x, ok := v.Aux.(*Var); !ok || !x.Captured {
+					return fmt.Errorf("%s: %v of a local that is not captured", f.Name, v)
+				}
+				phis = false
+			default:
+				phis = false
+			}
+			for i, a := range v.Args {
+				use := b
+				if v.Op == OpPhi {
+					use = b.Preds[i]
+				}
+				if err := defined(f, idom, index, a, use, v, v.Op != OpPhi); err != nil {
+					return err
+				}
+			}
+		}
+		for _, c := range b.Controls {
+			if err := defined(f, idom, index, c, b, nil, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// defined checks that a, an argument of v or a control of the block
+// when v is nil, is defined where it is used: at the end of use, or
+// before v when before is set.
+func defined(f *Func, idom map[*Block]*Block, index map[*Value]int, a *Value, use *Block, v *Value, before bool) error {
+	user := "the end of b" + fmt.Sprint(use.ID)
+	if v != nil {
+		user = v.String()
+	}
+	if a == nil {
+		return fmt.Errorf("%s: %s uses nil", f.Name, user)
+	}
+	if _, ok := index[a]; !ok || a.Block.Func != f {
+		return fmt.Errorf("%s: %s uses %v, which is in no block of the func", f.Name, user, a)
+	}
+	if a.Block == use {
+		if before && index[a] >= index[v] {
+			return fmt.Errorf("%s: %s uses %v before it is defined", f.Name, user, a)
+		}
+		return nil
+	}
+	for b := use; b != idom[b]; b = idom[b] {
+		if idom[b] == a.Block {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s uses %v, whose b%d does not dominate b%d", f.Name, user, a, a.Block.ID, use.ID)
+}
+
+func count(blocks []*Block, b *Block) int {
+	n := 0
+	for _, c := range blocks {
+		if c == b {
+			n++
+		}
+	}
+	return n
+}
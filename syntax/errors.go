@@ -0,0 +1,85 @@
+package syntax
+
+import (
+	"fmt"
+	"sort"
+)
+
+//go:generate stringer -type ErrorCode -linecomment errors.go
+type ErrorCode int
+
+const (
+	_                      ErrorCode = iota
+	ErrSyntax                        // syntax error
+	ErrIllegalChar                   // illegal character
+	ErrUnterminatedString            // unterminated string
+	ErrUnterminatedComment           // unterminated comment
+	ErrMalformedNumber               // malformed number
+	ErrInvalidEscape                 // invalid escape sequence
+	// ir.Resolve reports these.
+	ErrUndefined // undefined name
+	ErrDuplicate // duplicate declaration
+	ErrConst     // assignment to constant
+	// types.Check reports this.
+	ErrType // type error
+)
+
+// Error is a diagnostic found while scanning or parsing a file, or
+// resolving the names in it and checking their types.
+type Error struct {
+	Pos  Pos
+	Code ErrorCode
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos.String(), e.Msg)
+}
+
+// ErrorHandler is called by the Scanner for every error it finds.
+type ErrorHandler func(err *Error)
+
+// ErrorList collects every diagnostic of a file in source order.
+type ErrorList []*Error
+
+func (l *ErrorList) Add(pos Pos, code ErrorCode, msg string) {
+	*l = append(*l, &Error{Pos: pos, Code: code, Msg: msg})
+}
+
+func (l ErrorList) Len() int {
+	return len(l)
+}
+
+func (l ErrorList) Less(i, j int) bool {
+	if l[i].Pos.line != l[j].Pos.line {
+		return l[i].Pos.line < l[j].Pos.line
+	}
+	return l[i].Pos.col < l[j].Pos.col
+}
+
+func (l ErrorList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+func (l ErrorList) Sort() {
+	sort.Stable(l)
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0].Error(), len(l)-1)
+}
+
+// Err returns nil for an empty list, so a list can be returned
+// as an error without the typed nil pitfall.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
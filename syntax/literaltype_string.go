@@ -11,11 +11,12 @@ func _() {
 	_ = x[TNUM-1]
 	_ = x[TSTRING-2]
 	_ = x[TNIL-3]
+	_ = x[TBOOL-4]
 }
 
-const _LiteralType_name = "TNUMTSTRINGTNIL"
+const _LiteralType_name = "TNUMTSTRINGTNILTBOOL"
 
-var _LiteralType_index = [...]uint8{0, 4, 11, 15}
+var _LiteralType_index = [...]uint8{0, 4, 11, 15, 20}
 
 func (i LiteralType) String() string {
 	i -= 1
@@ -1,30 +1,137 @@
 package syntax
 
+import "strings"
+
 
 type File struct {
 	Decl []Decl
+	// Comments lists every comment of the file in source order. It
+	// is only filled in when parsing with ParseComments.
+	Comments []*CommentGroup
 }
 
+// Node is implemented by every syntax tree node. Pos is where the
+// node starts and End is just past its last token.
 type Node interface {
+	Pos() Pos
+	End() Pos
 	aNode()
 }
 
+type node struct {
+	pos, end Pos
+}
+
+func (n *node) Pos() Pos {
+	return n.pos
+}
+
+func (n *node) End() Pos {
+	return n.end
+}
+
+func (*node) aNode() {}
+
 type Decl interface {
 	Node
 	aDecl()
 }
 
+type decl struct{ node }
+
+func (*decl) aDecl() {}
+
+// VarDecl is var Lhs Type = Rhs, or const Lhs Type = Rhs when Const
+// is set. Type is empty when it is not given.
 type VarDecl struct {
-	Lhs []string
-	Rhs []Expr
-	Decl
+	Doc   *CommentGroup
+	Const bool
+	Lhs   []string
+	Type  string
+	Rhs   []Expr
+	decl
+}
+
+// TypeDecl declares a struct type: type Point struct { x y }.
+type TypeDecl struct {
+	Doc    *CommentGroup
+	Name   string
+	Fields []string
+	decl
 }
 
 type FuncDecl struct {
+	Doc *CommentGroup
+	// Recv and RecvType are the receiver of a method,
+	// func (Recv RecvType) FuncName(...), and empty for a func.
+	Recv     string
+	RecvType string
 	FuncName string
 	Args []string
+	// ArgPos are the positions of Args.
+	ArgPos []Pos
+	// ArgTypes are the types given to Args, empty for an arg without
+	// one, and nil when none has one. The type of a variadic arg is
+	// that of the arguments it collects.
+	ArgTypes []string
+	// Variadic reports whether the last arg is declared as `name...`
+	// and collects all the remaining call arguments into a list.
+	Variadic bool
+	// Results are the types of the results, nil when not given.
+	Results []string
 	Body []Stmt
-	Decl
+	decl
+}
+
+// Comment is a single // or /* */ comment, Text includes the
+// comment markers.
+type Comment struct {
+	Text string
+	node
+}
+
+// CommentGroup is a run of comments with no tokens and no blank
+// line between them.
+type CommentGroup struct {
+	List []*Comment
+}
+
+func (g *CommentGroup) Pos() Pos {
+	return g.List[0].Pos()
+}
+
+func (g *CommentGroup) End() Pos {
+	return g.List[len(g.List)-1].End()
+}
+
+// Text returns the text of the comments without the comment markers,
+// one line per line of comment.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range g.List {
+		text := c.Text
+		if strings.HasPrefix(text, "//") {
+			lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(text, "//"), " "))
+			continue
+		}
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
 }
 
 type Expr interface {
@@ -32,15 +139,24 @@ type Expr interface {
 	aExpr()
 }
 
+type expr struct{ node }
+
+func (*expr) aExpr() {}
+
+// BadExpr stands in for an expression with syntax errors.
+type BadExpr struct {
+	expr
+}
+
 type Name struct {
 	Name string
-	Expr
+	expr
 }
 
 type Literal struct {
 	Val string
 	Type LiteralType
-	Expr
+	expr
 }
 
 //go:generate stringer -type LiteralType -linecomment node.go
@@ -49,26 +165,40 @@ const (
 	TNUM LiteralType = iota + 1
 	TSTRING
 	TNIL
+	TBOOL
 )
+
+// UnaryExpr is a prefix operation: -x, +x or !x.
+type UnaryExpr struct {
+	Op Op
+	X  Expr
+	expr
+}
+
 type BinaryExpr struct {
 	Op Op
 	Lhs, Rhs Expr
-	Expr
+	expr
 }
 //go:generate stringer -type Op -linecomment node.go
 type Op int
 
 const (
-	_ Op = iota
-	OpPLUS
-	OpMINUS
-	OpMUL
-	OpDiv
-	OpEQ
-	OpLT
-	OpGT
-	OpLEQ
-	OpGEQ
+	_       Op = iota
+	OpPLUS     // +
+	OpMINUS    // -
+	OpMUL      // *
+	OpDiv      // /
+	OpEQ       // ==
+	OpLT       // <
+	OpGT       // >
+	OpLEQ      // <=
+	OpGEQ      // >=
+	OpNEQ      // !=
+	OpMOD      // %
+	OpAND      // &&
+	OpOR       // ||
+	OpNOT      // !
 )
 
 type Stmt interface {
@@ -76,57 +206,187 @@ type Stmt interface {
 	aStmt()
 }
 
+type stmt struct{ node }
+
+func (*stmt) aStmt() {}
+
+// BadStmt stands in for a statement with syntax errors.
+type BadStmt struct {
+	stmt
+}
+
+// AssignStmt assigns to names, list or map elements or struct
+// fields; each of Lhs is a *Name, an *IndexExpr or a *SelectorExpr.
+// Op is set for Lhs op= Rhs, and for Lhs++ and Lhs--, where Rhs is
+// nil and Op is OpPLUS or OpMINUS.
 type AssignStmt struct {
-	Lhs [] string
+	Op  Op
+	Lhs []Expr
 	Rhs []Expr
-	Stmt
+	stmt
 }
 
 type CallExpr struct {
-	Name string
+	Fun  Expr
 	Args []Expr
-	Expr
+	expr
+}
+
+// ListLit is a list literal: [a, b, c].
+type ListLit struct {
+	Elems []Expr
+	expr
+}
+
+// MapLit is a map literal: {k: v, k2: v2}. Keys[i] maps to
+// Values[i].
+type MapLit struct {
+	Keys   []Expr
+	Values []Expr
+	expr
+}
+
+// StructLit constructs a struct: Point{x: 1, y: 2}, or with the
+// values of all fields in order, Point{1 2}. Fields is nil then.
+type StructLit struct {
+	Type   *Name
+	Fields []string
+	Values []Expr
+	expr
+}
+
+// SelectorExpr is X.Sel, a field or a method.
+type SelectorExpr struct {
+	X   Expr
+	Sel string
+	expr
+}
+
+// IndexExpr is X[Index].
+type IndexExpr struct {
+	X     Expr
+	Index Expr
+	expr
+}
+
+// SliceExpr is X[Lo:Hi]. Lo and Hi are nil when omitted.
+type SliceExpr struct {
+	X      Expr
+	Lo, Hi Expr
+	expr
+}
+
+// FuncLit is an anonymous function: func(a b) { ... }. Its types
+// are given as those of a FuncDecl.
+type FuncLit struct {
+	Args     []string
+	ArgPos   []Pos
+	ArgTypes []string
+	Variadic bool
+	Results  []string
+	Body     []Stmt
+	expr
 }
 
 type DeclStmt struct {
 	Decl Decl
-	Stmt
+	stmt
 }
 
 type CallStmt struct {
 	Call Expr
-	Stmt
+	stmt
 }
 
 type ReturnStmt struct {
 	Returns [] Expr
-	Stmt
+	stmt
 }
 
 type BlockStmt struct {
 	Stmts []Stmt
-	Stmt
+	stmt
 }
 
 type IfStmt struct {
 	Cond Expr
 	Body Stmt
 	Else Stmt
-	Stmt
+	stmt
 }
 
+// ForStmt is for Init; Cond; Post { Body }, for Cond { Body } or
+// for { Body }. The parts left out are nil.
 type ForStmt struct {
 	Init Stmt
 	Cond Expr
 	Post Stmt
 	Body Stmt
-	Stmt
+	stmt
+}
+
+// RangeStmt is for Value in X { Body } or for Key, Value in X
+// { Body }; Key is empty in the first form.
+type RangeStmt struct {
+	Key, Value string
+	X          Expr
+	Body       *BlockStmt
+	stmt
+}
+
+// SwitchStmt runs the first case whose value equals Tag or, when
+// Tag is nil, whose value is true. A break leaves the switch.
+type SwitchStmt struct {
+	Tag   Expr
+	Cases []*CaseClause
+	stmt
 }
 
+// CaseClause is case Values: Body, or default: Body when Values is
+// nil. Fallthrough is set when Body ends in a fallthrough statement,
+// which is not kept in Body.
+type CaseClause struct {
+	Values      []Expr
+	Body        []Stmt
+	Fallthrough bool
+	node
+}
+
+// ListPattern is a case value matching a list with an element for
+// each of Elems, and any more when Rest names a list to collect them
+// in: [a, 1, rest...]. A name in a pattern is bound to the element
+// it matches, any other expression must equal it.
+type ListPattern struct {
+	Elems []Expr
+	Rest  string
+	expr
+}
+
+// MapPattern is a case value matching a map holding each of Keys,
+// with the value matching the pattern in Values: {"k": v}.
+type MapPattern struct {
+	Keys   []Expr
+	Values []Expr
+	expr
+}
+
+// BreakStmt leaves the innermost loop or switch, or the one
+// labelled Label.
 type BreakStmt struct {
-	Stmt
+	Label string
+	stmt
 }
 
+// ContinueStmt starts the next iteration of the innermost loop, or
+// of the one labelled Label.
 type ContinueStmt struct {
-	Stmt
+	Label string
+	stmt
+}
+
+// LabeledStmt is Label: Stmt, where Stmt is a for or a switch.
+type LabeledStmt struct {
+	Label string
+	Stmt  Stmt
+	stmt
 }
\ No newline at end of file
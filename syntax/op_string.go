@@ -17,11 +17,16 @@ func _() {
 	_ = x[OpGT-7]
 	_ = x[OpLEQ-8]
 	_ = x[OpGEQ-9]
+	_ = x[OpNEQ-10]
+	_ = x[OpMOD-11]
+	_ = x[OpAND-12]
+	_ = x[OpOR-13]
+	_ = x[OpNOT-14]
 }
 
-const _Op_name = "OpPLUSOpMINUSOpMULOpDivOpEQOpLTOpGTOpLEQOpGEQ"
+const _Op_name = "+-*/==<><=>=!=%&&||!"
 
-var _Op_index = [...]uint8{0, 6, 13, 18, 23, 27, 31, 35, 40, 45}
+var _Op_index = [...]uint8{0, 1, 2, 3, 4, 6, 7, 8, 10, 12, 14, 15, 17, 19, 20}
 
 func (i Op) String() string {
 	i -= 1
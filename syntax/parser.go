@@ -8,113 +8,378 @@ import (
 type Parser struct {
 	*Scanner
 	*File
+	errors    ErrorList
+	maxErrors int
+	mode      Mode
+	// lastGroup is the comment group seen last, until a token other
+	// than a newline closes it.
+	lastGroup   *CommentGroup
+	groupClosed bool
+	// lastTrailing is set when lastGroup started on the line of the
+	// token before it, so it comments that line, not the next one.
+	lastTrailing bool
+	// exprLev is < 0 in the header of an if or for, where a { starts
+	// the body rather than a map literal, and >= 0 inside brackets.
+	exprLev int
+	// depth is the number of braces open before the current token.
+	depth int
+	// targets are the loops and switches around the current
+	// statement in the current function, innermost last.
+	targets []jumpTarget
+	// label is the label of the for or switch about to be parsed.
+	label string
 }
 
+// jumpTarget is a loop or switch a break or continue can name.
+type jumpTarget struct {
+	label string
+	loop  bool
+}
+
+// DefaultMaxErrors is the number of errors after which ParseFile
+// gives up unless told otherwise with MaxErrors.
+const DefaultMaxErrors = 10
+
+// Option configures a Parser.
+type Option func(p *Parser)
+
+// MaxErrors stops the parse once n errors were reported. n <= 0
+// reports every error in the file.
+func MaxErrors(n int) Option {
+	return func(p *Parser) {
+		p.maxErrors = n
+	}
+}
+
+// ParseComments keeps the comments of the file in File.Comments and
+// attaches doc comments to the declarations they precede.
+func ParseComments() Option {
+	return func(p *Parser) {
+		p.mode |= ScanComments
+	}
+}
+
+// bailout is raised to abandon the statement or declaration being
+// parsed once an error is reported; the parse resumes after it.
+type bailout struct{}
+
+// tooManyErrors is raised to abandon the whole file.
+type tooManyErrors struct{}
+
 func getFileContent(fileName string)([]byte, error) {
 	bs, err := ioutil.ReadFile(fileName)
 	return bs, err
 }
 
-func ParseFile(fileName string)(*File, error) {
+// ParseFile parses the named file. On syntax errors it returns the
+// partial File, with BadExpr and BadStmt in place of the broken
+// parts, and an ErrorList of every error found.
+func ParseFile(fileName string, opts ...Option) (*File, error) {
 	content, err := getFileContent(fileName)
 	if err != nil {
 		return &File{}, err
 	}
-	p := &Parser{}
-	p.Scanner = NewScanner(fileName, content)
+	return Parse(fileName, content, opts...)
+}
+
+// Parse is like ParseFile but parses src, which fileName only names
+// in positions.
+func Parse(fileName string, src []byte, opts ...Option) (*File, error) {
+	p := &Parser{maxErrors: DefaultMaxErrors}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.Scanner = NewScanner(fileName, src, p.addError, p.mode)
 	p.File = &File{}
-	err = p.fileOrNil()
+	err := p.fileOrNil()
 	return p.File, err
 }
 
 func(p *Parser) Next() {
+	switch p.tToken {
+	case LEFTBRACE:
+		p.depth++
+	case RIGHTBRACE:
+		p.depth--
+	}
+	end := p.Scanner.end
+	line := p.Scanner.start.line
 	p.Scanner.Next()
+	for p.tToken == COMMENT {
+		p.comment(line)
+		line = p.Scanner.start.line
+		p.Scanner.Next()
+	}
+	if p.tToken != SEMICOLON {
+		p.groupClosed = true
+	}
+	p.prevEnd = end
+}
+
+// comment adds the current COMMENT token to the open comment group
+// or starts a new one. prevLine is the line of the token before it.
+func (p *Parser) comment(prevLine int) {
+	c := &Comment{Text: p.Scanner.literal}
+	c.pos, c.end = p.pos(), p.Scanner.end
+	g := p.lastGroup
+	if g == nil || p.groupClosed || c.pos.line > g.End().line+1 {
+		g = &CommentGroup{}
+		p.Comments = append(p.Comments, g)
+		p.lastGroup = g
+		p.groupClosed = false
+		p.lastTrailing = c.pos.line == prevLine
+	}
+	g.List = append(g.List, c)
 }
 
-func (p *Parser) fileOrNil() error {
+// leadComment returns the comment group ending on the line before
+// pos, which documents the declaration starting at pos.
+func (p *Parser) leadComment(pos Pos) *CommentGroup {
+	g := p.lastGroup
+	if g == nil || p.lastTrailing || g.End().line+1 != pos.line {
+		return nil
+	}
+	p.lastGroup = nil
+	return g
+}
+
+func (p *Parser) fileOrNil() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(tooManyErrors); !ok {
+				panic(r)
+			}
+		}
+		p.errors.Sort()
+		err = p.errors.Err()
+	}()
+	p.Next()
 	for p.Scanner.tToken != EOF {
-		p.Next()
-		switch p.tToken {
-		case _KVAR:
-			d := p.VarDecl()
-			p.Decl = append(p.Decl, d)
-		case _KFUNC:
-			d := p.funcDecl()
+		if p.Want(SEMICOLON) {
+			p.Next()
+			continue
+		}
+		if d := p.declOrNil(); d != nil {
 			p.Decl = append(p.Decl, d)
-		case SEMICOLON:
+		}
+	}
+	return nil
+}
 
-		default:
-			p.Error()
-			return fmt.Errorf("error")
+// declOrNil parses a top level declaration. After an error it skips
+// to the next top level declaration and returns nil.
+func (p *Parser) declOrNil() (decl Decl) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			p.skipOut(0)
+			p.skipTo(_KVAR, _KCONST, _KFUNC, _KTYPE)
+			p.exprLev = 0
+			p.targets, p.label = nil, ""
+			decl = nil
 		}
+	}()
+	switch p.tToken {
+	case _KVAR, _KCONST:
+		return p.VarDecl()
+	case _KFUNC:
+		return p.funcDecl()
+	case _KTYPE:
+		return p.typeDecl()
 	}
+	p.errorf("unexpected %s, need var, const, func or type", p.tokDesc())
 	return nil
 }
 
+// stmtOrBad parses a statement. After an error it skips to the end
+// of the statement and returns a BadStmt.
+func (p *Parser) stmtOrBad() (stmt Stmt) {
+	pos := p.pos()
+	exprLev, depth, targets := p.exprLev, p.depth, p.targets
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			p.exprLev, p.targets, p.label = exprLev, targets, ""
+			p.skipOut(depth)
+			p.skipTo(SEMICOLON, RIGHTBRACE)
+			if p.pos() == pos {
+				p.Next()
+			}
+			bad := &BadStmt{}
+			bad.pos, bad.end = pos, p.prevEnd
+			stmt = bad
+		}
+	}()
+	return p.Stmt()
+}
+
+// skipOut advances past the closing braces of those opened since
+// the brace depth was depth.
+func (p *Parser) skipOut(depth int) {
+	for p.depth > depth && !p.Want(EOF) {
+		p.Next()
+	}
+}
+
+// skipTo advances to the first of the given tokens that is not
+// nested in braces opened while skipping, or to EOF.
+func (p *Parser) skipTo(follow ...TokenType) {
+	depth := 0
+	for !p.Want(EOF) {
+		if depth == 0 {
+			for _, t := range follow {
+				if p.Want(t) {
+					return
+				}
+			}
+		}
+		switch p.tToken {
+		case LEFTBRACE:
+			depth++
+		case RIGHTBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+		p.Next()
+	}
+}
+
 func (p *Parser) VarDecl() Decl {
 	var varDecl VarDecl
-	for {
+	varDecl.pos = p.pos()
+	varDecl.Doc = p.leadComment(varDecl.pos)
+	varDecl.Const = p.Want(_KCONST)
+	p.Next()
+	// commas is set when a comma separates names, spaced when the
+	// last name follows the one before it without one.
+	comma, commas, spaced := false, false, false
+	for p.Want(IDENT) {
+		spaced = len(varDecl.Lhs) > 0 && !comma
+		varDecl.Lhs = append(varDecl.Lhs, p.Scanner.literal)
 		p.Next()
-		if p.Scanner.tToken != IDENT {
-			break
+		comma = p.Want(COMMA)
+		if comma {
+			commas = true
+			p.Next()
+			if !p.Want(IDENT) {
+				p.errorf("need name here")
+			}
 		}
-		varDecl.Lhs = append(varDecl.Lhs, p.Scanner.literal)
+	}
+	if len(varDecl.Lhs) == 0 {
+		p.errorf("need name here")
+	}
+	switch {
+	case p.Want(_KFUNC):
+		varDecl.Type = p.typeName()
+	case spaced && commas:
+		// var a, b num: the name after names separated by commas
+		// is their type.
+		varDecl.Type = varDecl.Lhs[len(varDecl.Lhs)-1]
+		varDecl.Lhs = varDecl.Lhs[:len(varDecl.Lhs)-1]
 	}
 
 	if !p.Want(ASSIGN) {
-		panic(fmt.Sprintf("%v need assign op here", p.Scanner.Pos))
+		p.errorf("need assign op here")
 	}
 
 	p.Next()
-	for i := 0; i< len(varDecl.Lhs); i++ {
-		expr := p.BinaryExpr(0)
-		if i == len(varDecl.Lhs) - 1 {
-			if !p.Want(SEMICOLON) {
-				panic(fmt.Sprintf("%v need ;  here", p.Scanner.Pos))
-			}
-		} else {
-			if !p.Want(COMMA) {
-				panic(fmt.Sprintf("%v need , here", p.Scanner.Pos))
-			}
+	pos := p.pos()
+	varDecl.Rhs = p.exprList()
+	// of names separated by spaces, the last is the type of the
+	// others when it has no value of its own: var s string = "" is
+	// a string, but var a b = 1, 2 and var q r = divmod(7 2), whose
+	// call may give a value to each, are two vars.
+	if n := len(varDecl.Lhs); spaced && !commas && varDecl.Type == "" && len(varDecl.Rhs) == n-1 && !isCall(varDecl.Rhs[0]) {
+		varDecl.Type = varDecl.Lhs[n-1]
+		varDecl.Lhs = varDecl.Lhs[:n-1]
+	}
+	p.checkValues(pos, len(varDecl.Lhs), len(varDecl.Rhs))
+	if !p.atStmtEnd() {
+		p.errorf("need ; here")
+	}
+	varDecl.end = p.prevEnd
+	return &varDecl
+}
+
+// rhsList parses the values assigned to n variables: n expressions,
+// or one yielding all n values, like a call.
+func (p *Parser) rhsList(n int) []Expr {
+	pos := p.pos()
+	rhs := p.exprList()
+	p.checkValues(pos, n, len(rhs))
+	return rhs
+}
+
+// exprList parses expressions separated by commas.
+func (p *Parser) exprList() []Expr {
+	var list []Expr
+	for {
+		list = append(list, p.Expr())
+		if !p.Want(COMMA) {
+			return list
 		}
-		varDecl.Rhs = append(varDecl.Rhs, expr)
+		p.Next()
 	}
+}
 
-	return &varDecl
+// checkValues reports the values at pos when n variables cannot be
+// given them: there must be n, or one, which may have n results.
+func (p *Parser) checkValues(pos Pos, n, values int) {
+	if values != 1 && values != n {
+		p.addError(&Error{Pos: pos, Code: ErrSyntax, Msg: fmt.Sprintf("assignment mismatch: %s but %s", plural(n, "variable"), plural(values, "value"))})
+	}
 }
 
-func getOpFromTToken(t TokenType) Op {
-	switch t {
-	case PLUS:
-		return OpPLUS
-	case MINUS:
-		return OpMINUS
-	case MUL:
-		return OpMUL
-	case DIV:
-		return OpDiv
-	case LT:
-		return OpLT
-	case LEQ:
-		return OpLEQ
-	case GT:
-		return OpGT
-	case GEQ:
-		return OpGEQ
-	case EQUAL:
-		return OpEQ
-	}
-	return 0
+func isCall(x Expr) bool {
+	_, ok := x.(*CallExpr)
+	return ok
+}
+
+// Expr parses an expression that must be present. A missing one is
+// reported and replaced by a BadExpr without abandoning the statement.
+func (p *Parser) Expr() Expr {
+	x := p.BinaryExpr(0)
+	if x == nil {
+		p.reportf("need expression, found %s", p.tokDesc())
+		return p.badExpr()
+	}
+	return x
+}
+
+// listExpr parses an element of an expression list. A missing
+// element abandons the statement, since the list cannot go on.
+func (p *Parser) listExpr() Expr {
+	x := p.Expr()
+	if _, ok := x.(*BadExpr); ok {
+		panic(bailout{})
+	}
+	return x
 }
 
 func (p *Parser) BinaryExpr(prec Prec) Expr {
-	x := p.UnaryExpr()
-	for p.Scanner.isBinaryOp && p.Scanner.Prec > prec {
-		op := getOpFromTToken(p.tToken)
+	return p.binaryExpr(p.UnaryExpr(), prec)
+}
+
+// binaryExpr parses the rest of a binary expression whose first
+// operand x was already parsed.
+func (p *Parser) binaryExpr(x Expr, prec Prec) Expr {
+	for x != nil && p.Scanner.isBinaryOp && p.Scanner.Prec > prec {
+		op := binaryOps[p.tToken].op
 		prec := p.Scanner.Prec
 		p.Next()
 		y := p.BinaryExpr(prec)
+		if y == nil {
+			p.reportf("need operand after %v, found %s", op, p.tokDesc())
+			y = p.badExpr()
+		}
 		be := &BinaryExpr{}
+		be.pos, be.end = x.Pos(), p.prevEnd
 		be.Op = op
 		be.Lhs = x
 		be.Rhs = y
@@ -124,42 +389,232 @@ func (p *Parser) BinaryExpr(prec Prec) Expr {
 	return x
 }
 
-func (p *Parser) CallExpr(name string) Expr {
+func (p *Parser) CallExpr(fun Expr) Expr {
 	var callExpr CallExpr
-	callExpr.Name = name
-	for {
+	callExpr.pos = fun.Pos()
+	callExpr.Fun = fun
+	p.Next()
+	p.exprLev++
+	for !p.Want(RIGHTPAREN) && !p.atStmtEnd() {
+		callExpr.Args = append(callExpr.Args, p.listExpr())
+		if p.Want(COMMA) {
+			p.Next()
+		}
+	}
+	if !p.Want(RIGHTPAREN) {
+		p.errorf(") need here")
+	}
+	p.exprLev--
+	p.Next()
+	callExpr.end = p.prevEnd
+	return &callExpr
+}
+
+// postfixExpr parses the call, index, slice and selector suffixes
+// following an operand, so they can be chained: f(1)(2), a[i].x.
+func (p *Parser) postfixExpr(x Expr) Expr {
+	for x != nil {
+		switch p.tToken {
+		case LEFTPAREN:
+			x = p.CallExpr(x)
+		case LEFTBRACKET:
+			x = p.indexOrSlice(x)
+		case DOT:
+			p.Next()
+			if !p.Want(IDENT) {
+				p.errorf("field name need here")
+			}
+			sel := &SelectorExpr{X: x, Sel: p.Scanner.literal}
+			p.Next()
+			sel.pos, sel.end = x.Pos(), p.prevEnd
+			x = sel
+		default:
+			return x
+		}
+	}
+	return x
+}
+
+// indexOrSlice parses the x[i] or x[lo:hi] suffix of x.
+func (p *Parser) indexOrSlice(x Expr) Expr {
+	p.Next()
+	p.exprLev++
+	var lo Expr
+	if !p.Want(COLON) {
+		lo = p.listExpr()
+	}
+	if !p.Want(COLON) {
+		if !p.Want(RIGHTBRACKET) {
+			p.errorf("] need here")
+		}
+		p.exprLev--
 		p.Next()
-		if expr := p.BinaryExpr(0); expr != nil {
-			callExpr.Args = append(callExpr.Args, expr)
-		} else {
+		index := &IndexExpr{X: x, Index: lo}
+		index.pos, index.end = x.Pos(), p.prevEnd
+		return index
+	}
+	p.Next()
+	slice := &SliceExpr{X: x, Lo: lo}
+	if !p.Want(RIGHTBRACKET) {
+		slice.Hi = p.listExpr()
+	}
+	if !p.Want(RIGHTBRACKET) {
+		p.errorf("] need here")
+	}
+	p.exprLev--
+	p.Next()
+	slice.pos, slice.end = x.Pos(), p.prevEnd
+	return slice
+}
+
+// ListLit parses [a, b, c]. As in calls the commas are optional;
+// the elements may be spread over several lines.
+func (p *Parser) ListLit() Expr {
+	list := &ListLit{}
+	list.pos = p.pos()
+	p.Next()
+	p.exprLev++
+	for {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(RIGHTBRACKET) || p.Want(EOF) {
 			break
 		}
-		if p.Scanner.tToken == RIGHTPAREN {
+		list.Elems = append(list.Elems, p.listExpr())
+		if p.Want(COMMA) {
+			p.Next()
+		}
+	}
+	if !p.Want(RIGHTBRACKET) {
+		p.errorf("] need here")
+	}
+	p.exprLev--
+	p.Next()
+	list.end = p.prevEnd
+	return list
+}
+
+// StructLit parses the braced values following the type name of a
+// struct construction, either all keyed by field or none.
+func (p *Parser) StructLit(typ *Name) Expr {
+	lit := &StructLit{Type: typ}
+	lit.pos = typ.Pos()
+	p.Next()
+	p.exprLev++
+	for {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(RIGHTBRACE) || p.Want(EOF) {
 			break
 		}
+		x := p.listExpr()
+		if p.Want(COLON) {
+			name, ok := x.(*Name)
+			if !ok {
+				p.addError(&Error{Pos: x.Pos(), Code: ErrSyntax, Msg: "invalid field name"})
+				panic(bailout{})
+			}
+			if len(lit.Fields) != len(lit.Values) {
+				p.errorf("mixture of field:value and value elements")
+			}
+			lit.Fields = append(lit.Fields, name.Name)
+			p.Next()
+			x = p.listExpr()
+		} else if len(lit.Fields) > 0 {
+			p.errorf("mixture of field:value and value elements")
+		}
+		lit.Values = append(lit.Values, x)
+		if p.Want(COMMA) {
+			p.Next()
+		}
 	}
-	if !p.Want(RIGHTPAREN) {
-		panic(fmt.Sprintf("%v: ) need here", p.Scanner.Pos))
+	if !p.Want(RIGHTBRACE) {
+		p.errorf("} need here")
 	}
+	p.exprLev--
 	p.Next()
-	return &callExpr
+	lit.end = p.prevEnd
+	return lit
+}
+
+// MapLit parses {k: v, k2: v2}. Like in lists the commas are
+// optional and the entries may be spread over several lines.
+func (p *Parser) MapLit() Expr {
+	m := &MapLit{}
+	m.pos = p.pos()
+	p.Next()
+	p.exprLev++
+	for {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(RIGHTBRACE) || p.Want(EOF) {
+			break
+		}
+		m.Keys = append(m.Keys, p.listExpr())
+		if !p.Want(COLON) {
+			p.errorf(": need here")
+		}
+		p.Next()
+		m.Values = append(m.Values, p.listExpr())
+		if p.Want(COMMA) {
+			p.Next()
+		}
+	}
+	if !p.Want(RIGHTBRACE) {
+		p.errorf("} need here")
+	}
+	p.exprLev--
+	p.Next()
+	m.end = p.prevEnd
+	return m
 }
 
 func (p *Parser) UnaryExpr() Expr {
+	var op Op
+	switch p.tToken {
+	case MINUS:
+		op = OpMINUS
+	case PLUS:
+		op = OpPLUS
+	case NOT:
+		op = OpNOT
+	default:
+		return p.postfixExpr(p.operand())
+	}
+	unary := &UnaryExpr{Op: op}
+	unary.pos = p.pos()
+	p.Next()
+	unary.X = p.UnaryExpr()
+	if unary.X == nil {
+		p.reportf("need operand after %v, found %s", op, p.tokDesc())
+		unary.X = p.badExpr()
+	}
+	unary.end = p.prevEnd
+	return unary
+}
+
+func (p *Parser) operand() Expr {
+	pos := p.pos()
 	if p.Scanner.tToken == IDENT {
 		expr := &Name{Name:p.Scanner.literal}
 		p.Next()
-		if p.Scanner.tToken != LEFTPAREN {
-			return expr
+		expr.pos, expr.end = pos, p.prevEnd
+		if p.Want(LEFTBRACE) && p.exprLev >= 0 {
+			return p.StructLit(expr)
 		}
-		callExpr := p.CallExpr(expr.Name)
-		return callExpr
+		return expr
 	}
 	if p.Scanner.tToken == LEFTPAREN {
-		expr := p.BinaryExpr(0)
+		p.Next()
+		p.exprLev++
+		expr := p.Expr()
 		if !p.Want(RIGHTPAREN) {
-			panic(fmt.Sprintf("%v need ) here", p.Scanner.Pos))
+			p.errorf("need ) here")
 		}
+		p.exprLev--
 		p.Next()
 		return expr
 	}
@@ -169,6 +624,7 @@ func (p *Parser) UnaryExpr() Expr {
 			Type: TNUM,
 		}
 		p.Next()
+		expr.pos, expr.end = pos, p.prevEnd
 		return &expr
 	}
 	if p.Scanner.tToken == STRING {
@@ -177,93 +633,366 @@ func (p *Parser) UnaryExpr() Expr {
 			Type: TSTRING,
 		}
 		p.Next()
+		expr.pos, expr.end = pos, p.prevEnd
 		return &expr
 	}
-
-	if p.Scanner.tToken == SEMICOLON {
+	if p.Scanner.tToken == _KTRUE || p.Scanner.tToken == _KFALSE {
+		expr := Literal{
+			Val:  p.Scanner.literal,
+			Type: TBOOL,
+		}
 		p.Next()
-		return nil
+		expr.pos, expr.end = pos, p.prevEnd
+		return &expr
+	}
+	if p.Scanner.tToken == _KNIL {
+		expr := Literal{
+			Val:  p.Scanner.literal,
+			Type: TNIL,
+		}
+		p.Next()
+		expr.pos, expr.end = pos, p.prevEnd
+		return &expr
+	}
+	if p.Scanner.tToken == LEFTBRACKET {
+		return p.ListLit()
+	}
+	if p.Scanner.tToken == LEFTBRACE && p.exprLev >= 0 {
+		return p.MapLit()
+	}
+	if p.Scanner.tToken == _KFUNC {
+		return p.FuncLit()
 	}
 	return nil
 }
 
-
 func (p *Parser)Want(t TokenType) bool {
 	return p.Scanner.tToken == t
 }
 
 func (p *Parser) funcDecl() Decl {
+	pos := p.pos()
 	p.Next()
+	var funcDecl FuncDecl
+	if p.Want(LEFTPAREN) {
+		funcDecl.Recv, funcDecl.RecvType = p.receiver()
+	}
 	if !p.Want(IDENT) {
-		panic(fmt.Sprintf("%v: func name need here", p.Scanner.Pos))
+		p.errorf("func name need here")
 	}
-	var funcDecl FuncDecl
+	funcDecl.pos = pos
+	funcDecl.Doc = p.leadComment(pos)
 	funcDecl.FuncName = p.Scanner.literal
 	p.Next()
-	if !p.Want(LEFTPAREN) {
-		panic(fmt.Sprintf("%v: ( need here", p.Scanner.Pos))
+	funcDecl.Args, funcDecl.ArgPos, funcDecl.ArgTypes, funcDecl.Variadic = p.funcParams()
+	funcDecl.Results = p.funcResults()
+	funcDecl.Body = p.funcBody()
+	funcDecl.end = p.prevEnd
+	return &funcDecl
+}
+
+// receiver parses the (name Type) receiver of a method.
+func (p *Parser) receiver() (name, typ string) {
+	p.Next()
+	if !p.Want(IDENT) {
+		p.errorf("receiver name need here")
+	}
+	name = p.Scanner.literal
+	p.Next()
+	if !p.Want(IDENT) {
+		p.errorf("receiver type need here")
+	}
+	typ = p.Scanner.literal
+	p.Next()
+	if !p.Want(RIGHTPAREN) {
+		p.errorf(") need here")
+	}
+	p.Next()
+	return name, typ
+}
+
+// typeDecl parses type Name struct { field... }. Fields are
+// separated by spaces, commas or newlines.
+func (p *Parser) typeDecl() Decl {
+	var typeDecl TypeDecl
+	typeDecl.pos = p.pos()
+	typeDecl.Doc = p.leadComment(typeDecl.pos)
+	p.Next()
+	if !p.Want(IDENT) {
+		p.errorf("type name need here")
 	}
+	typeDecl.Name = p.Scanner.literal
+	p.Next()
+	if !p.Want(_KSTRUCT) {
+		p.errorf("struct need here")
+	}
+	p.Next()
+	if !p.Want(LEFTBRACE) {
+		p.errorf("{ need here")
+	}
+	p.Next()
+	seen := make(map[string]bool)
 	for {
+		for p.Want(SEMICOLON) || p.Want(COMMA) {
+			p.Next()
+		}
+		if !p.Want(IDENT) {
+			break
+		}
+		field := p.Scanner.literal
+		if seen[field] {
+			p.reportf("duplicate field %s", field)
+		}
+		seen[field] = true
+		typeDecl.Fields = append(typeDecl.Fields, field)
 		p.Next()
-		if p.Scanner.tToken == IDENT {
-			funcDecl.Args = append(funcDecl.Args, p.Scanner.literal)
-		} else {
+	}
+	if !p.Want(RIGHTBRACE) {
+		p.errorf("} need here")
+	}
+	p.Next()
+	typeDecl.end = p.prevEnd
+	return &typeDecl
+}
+
+func (p *Parser) FuncLit() Expr {
+	var funcLit FuncLit
+	funcLit.pos = p.pos()
+	p.Next()
+	funcLit.Args, funcLit.ArgPos, funcLit.ArgTypes, funcLit.Variadic = p.funcParams()
+	funcLit.Results = p.funcResults()
+	funcLit.Body = p.funcBody()
+	funcLit.end = p.prevEnd
+	return &funcLit
+}
+
+// funcParams parses the parameters of a func, each of which may be
+// followed by its type, and returns them with their positions. The
+// types are nil when none is given, and empty for a parameter without
+// one.
+//
+// A name after a param is its type only when the params are
+// separated by commas, as in func(a num, b num) or, for a single
+// one, func(a num,); func(a b) has two params. The type of a
+// variadic param, func(a, rest... string), and func, which is never
+// a name, need no comma.
+func (p *Parser) funcParams() (args []string, pos []Pos, types []string, variadic bool) {
+	if !p.Want(LEFTPAREN) {
+		p.errorf("( need here")
+	}
+	p.Next()
+	commas := false
+	for p.Scanner.tToken == IDENT {
+		args = append(args, p.Scanner.literal)
+		pos = append(pos, p.pos())
+		p.Next()
+		if p.Scanner.tToken == ELLIPSIS {
+			variadic = true
+			p.Next()
+		}
+		if p.Want(_KFUNC) || p.Want(IDENT) && (commas || variadic) {
+			for len(types) < len(args)-1 {
+				types = append(types, "")
+			}
+			types = append(types, p.typeName())
+		}
+		if variadic {
 			break
 		}
+		if p.Scanner.tToken == COMMA {
+			// until the first comma it was not known that two
+			// names before it are a param and its type.
+			if !commas && len(args) == 2 && types == nil {
+				types = []string{args[1]}
+				args, pos = args[:1], pos[:1]
+			}
+			commas = true
+			p.Next()
+		}
 	}
 	if !p.Want(RIGHTPAREN) {
-		panic(fmt.Sprintf("%v: ) need here", p.Scanner.Pos))
+		p.errorf(") need here")
 	}
 	p.Next()
-	stmts := p.funcBody()
-	funcDecl.Body = stmts
-	return &funcDecl
+	for types != nil && len(types) < len(args) {
+		types = append(types, "")
+	}
+	return args, pos, types, variadic
+}
+
+// funcResults parses the result types of a func, none, one or a
+// parenthesized list.
+func (p *Parser) funcResults() []string {
+	if p.atType() {
+		return []string{p.typeName()}
+	}
+	if !p.Want(LEFTPAREN) {
+		return nil
+	}
+	p.Next()
+	var results []string
+	for p.atType() {
+		results = append(results, p.typeName())
+		if !p.Want(COMMA) {
+			break
+		}
+		p.Next()
+	}
+	if len(results) == 0 {
+		p.errorf("result type need here")
+	}
+	if !p.Want(RIGHTPAREN) {
+		p.errorf(") need here")
+	}
+	p.Next()
+	return results
 }
 
+// atType reports whether a type is next: a name, whatever it is,
+// or func. Whether the name is that of a type is for types.Check to
+// say.
+func (p *Parser) atType() bool {
+	return p.Want(IDENT) || p.Want(_KFUNC)
+}
+
+func (p *Parser) typeName() string {
+	name := p.Scanner.literal
+	if p.Want(_KFUNC) {
+		name = "func"
+	}
+	p.Next()
+	return name
+}
+
+// funcBody parses the body of a func, which break and continue
+// cannot leave.
 func (p *Parser) funcBody() []Stmt {
-	var stmts []Stmt
+	targets := p.targets
+	p.targets = nil
+	body := p.BlockStmt().Stmts
+	p.targets = targets
+	return body
+}
+
+// loopBody parses the body of a loop labelled label, which may be
+// empty.
+func (p *Parser) loopBody(label string) *BlockStmt {
+	p.targets = append(p.targets, jumpTarget{label: label, loop: true})
+	body := p.BlockStmt()
+	p.targets = p.targets[:len(p.targets)-1]
+	return body
+}
+
+// checkJump reports a break or continue at pos that has no loop or
+// switch to jump out of.
+func (p *Parser) checkJump(pos Pos, keyword string, label string) {
+	var msg string
+	if label == "" {
+		for i := len(p.targets) - 1; i >= 0; i-- {
+			if keyword == "break" || p.targets[i].loop {
+				return
+			}
+		}
+		msg = "break is not in a loop or switch"
+		if keyword == "continue" {
+			msg = "continue is not in a loop"
+		}
+	} else {
+		for i := len(p.targets) - 1; i >= 0; i-- {
+			if p.targets[i].label != label {
+				continue
+			}
+			if keyword == "break" || p.targets[i].loop {
+				return
+			}
+			msg = fmt.Sprintf("invalid continue label %s", label)
+			break
+		}
+		if msg == "" {
+			msg = fmt.Sprintf("%s label not defined: %s", keyword, label)
+		}
+	}
+	p.addError(&Error{Pos: pos, Code: ErrSyntax, Msg: msg})
+}
+
+// LabeledStmt parses label: followed by the for or switch it names.
+func (p *Parser) LabeledStmt(label *Name) Stmt {
+	p.Next()
+	for p.Want(SEMICOLON) {
+		p.Next()
+	}
+	for _, t := range p.targets {
+		if t.label == label.Name {
+			p.addError(&Error{Pos: label.Pos(), Code: ErrSyntax, Msg: fmt.Sprintf("label %s already defined", label.Name)})
+		}
+	}
+	if !p.Want(_KFOR) && !p.Want(_KSWITCH) {
+		p.errorf("label %s must be followed by for or switch", label.Name)
+	}
+	labeledStmt := &LabeledStmt{Label: label.Name}
+	p.label = label.Name
+	labeledStmt.Stmt = p.Stmt()
+	labeledStmt.pos, labeledStmt.end = label.Pos(), p.prevEnd
+	return labeledStmt
+}
+
+// BlockStmt parses a braced statement list. Like every statement it
+// leaves the scanner on the first token after the statement.
+func (p *Parser) BlockStmt() *BlockStmt {
+	pos := p.pos()
 	if !p.Want(LEFTBRACE) {
-		panic(fmt.Sprintf("%v: { need here", p.Scanner.Pos))
+		p.errorf("{ need here")
 	}
 	p.Next()
+	var stmts []Stmt
 	for {
-		for  p.Scanner.tToken == SEMICOLON {
+		for p.Want(SEMICOLON) {
 			p.Next()
 		}
 		if p.Want(RIGHTBRACE) {
 			break
 		}
-		stmts = append(stmts, p.Stmt())
-		p.Next()
+		if p.Want(EOF) {
+			p.errorf("} need here")
+		}
+		stmts = append(stmts, p.stmtOrBad())
 	}
 	p.Next()
-	return stmts
+	block := &BlockStmt{Stmts: stmts}
+	block.pos, block.end = pos, p.prevEnd
+	return block
 }
 
 
 func (p *Parser) SimpleStmt(isFor bool) Stmt {
+	pos := p.pos()
 	switch p.Scanner.tToken {
-	case _KVAR:
+	case _KVAR, _KCONST:
 		decl := p.VarDecl()
 		var declStmt DeclStmt
+		declStmt.pos, declStmt.end = decl.Pos(), decl.End()
 		declStmt.Decl = decl
 		return &declStmt
 	case IDENT:
-		x := p.Scanner.literal
-		p.Next()
-		if p.Scanner.tToken == LEFTPAREN {
-			return &CallStmt{
-				Call: p.CallExpr(x),
+		x := p.postfixExpr(p.operand())
+		if name, ok := x.(*Name); ok && p.Want(COLON) && !isFor {
+			return p.LabeledStmt(name)
+		}
+		if _, ok := x.(*CallExpr); ok && !p.Want(ASSIGN) {
+			callStmt := &CallStmt{
+				Call: x,
 			}
+			callStmt.pos, callStmt.end = pos, p.prevEnd
+			return callStmt
 		}
-		return p.AssignStmt(x, isFor)
+		return p.AssignStmt(pos, x, isFor)
 	}
 	return nil
 }
 
 func (p *Parser) Stmt() Stmt {
 	switch p.Scanner.tToken {
-	case _KVAR:
+	case _KVAR, _KCONST:
 		return p.SimpleStmt(false)
 	case IDENT:
 		return p.SimpleStmt(false)
@@ -271,150 +1000,484 @@ func (p *Parser) Stmt() Stmt {
 		return p.IfStmt()
 	case _KFOR:
 		return p.ForStmt()
+	case _KSWITCH:
+		return p.SwitchStmt()
+	case _KFALLTHROUGH:
+		p.errorf("fallthrough statement out of place")
 	case _KRETURN:
 		return p.ReturnStmt()
+	case _KFUNC:
+		pos := p.pos()
+		declStmt := &DeclStmt{Decl: p.funcDecl()}
+		if declStmt.Decl.(*FuncDecl).Recv != "" {
+			p.addError(&Error{Pos: pos, Code: ErrSyntax, Msg: "method must be declared at top level"})
+		}
+		declStmt.pos, declStmt.end = declStmt.Decl.Pos(), declStmt.Decl.End()
+		return declStmt
 	case _KBREAK:
-		return &BreakStmt{}
+		breakStmt := &BreakStmt{}
+		breakStmt.pos, breakStmt.end = p.pos(), p.end
+		p.Next()
+		if p.Want(IDENT) {
+			breakStmt.Label, breakStmt.end = p.Scanner.literal, p.end
+			p.Next()
+		}
+		p.checkJump(breakStmt.pos, "break", breakStmt.Label)
+		return breakStmt
 	case _KCONTINUE:
-		return &ContinueStmt{}
+		continueStmt := &ContinueStmt{}
+		continueStmt.pos, continueStmt.end = p.pos(), p.end
+		p.Next()
+		if p.Want(IDENT) {
+			continueStmt.Label, continueStmt.end = p.Scanner.literal, p.end
+			p.Next()
+		}
+		p.checkJump(continueStmt.pos, "continue", continueStmt.Label)
+		return continueStmt
 	}
+	p.errorf("unexpected %s", p.tokDesc())
 	return nil
 }
 
 
 func (p *Parser) ReturnStmt() Stmt {
-	p.Next()
 	var returnStmt ReturnStmt
-	for {
-		expr := p.BinaryExpr(0)
-		if expr != nil {
-			returnStmt.Returns = append(returnStmt.Returns, expr)
+	returnStmt.pos = p.pos()
+	p.Next()
+	for !p.atStmtEnd() {
+		returnStmt.Returns = append(returnStmt.Returns, p.listExpr())
+		if p.Want(COMMA) {
+			p.Next()
+		}
+	}
+	returnStmt.end = p.prevEnd
+	return &returnStmt
+}
+
+func (p *Parser) IfStmt() Stmt {
+	var ifStmt IfStmt
+	ifStmt.pos = p.pos()
+	p.Next()
+	outer := p.exprLev
+	p.exprLev = -1
+	expr := p.Expr()
+	p.exprLev = outer
+	ifStmt.Cond = expr
+	ifStmt.Body = p.BlockStmt()
+	if p.Scanner.tToken == _KELSE {
+		p.Next()
+		if p.Scanner.tToken == _KIF {
+			ifStmt.Else = p.IfStmt()
 		} else {
+			ifStmt.Else = p.BlockStmt()
+		}
+	}
+	ifStmt.end = p.prevEnd
+	return &ifStmt
+}
+
+// ForStmt parses the for loops: the three clause form, the forms
+// with only a condition or with nothing, and the range loops.
+func (p *Parser) ForStmt() Stmt {
+	var forStmt ForStmt
+	forStmt.pos = p.pos()
+	label := p.label
+	p.label = ""
+	p.Next()
+	outer := p.exprLev
+	p.exprLev = -1
+	var init Stmt
+	var cond Expr
+	switch p.tToken {
+	case LEFTBRACE, SEMICOLON:
+	case _KVAR:
+		init = p.SimpleStmt(false)
+	case IDENT:
+		// the first name of a range loop, the start of an init
+		// statement, or the start of the condition.
+		pos := p.pos()
+		x := p.postfixExpr(p.operand())
+		if p.Want(COMMA) || p.Want(_KIN) {
+			p.exprLev = outer
+			return p.RangeStmt(forStmt.pos, x, label)
+		}
+		if p.atAssignOp() || p.Want(IDENT) {
+			init = p.AssignStmt(pos, x, false)
 			break
 		}
-		if p.Scanner.tToken == SEMICOLON {
+		if _, ok := x.(*CallExpr); ok && p.Want(SEMICOLON) {
+			callStmt := &CallStmt{Call: x}
+			callStmt.pos, callStmt.end = pos, p.prevEnd
+			init = callStmt
 			break
 		}
+		cond = p.binaryExpr(x, 0)
+	default:
+		cond = p.Expr()
+	}
+	if init != nil || p.Want(SEMICOLON) {
+		if cond != nil || !p.Want(SEMICOLON) {
+			p.errorf("need ; here")
+		}
 		p.Next()
+		if !p.Want(SEMICOLON) {
+			cond = p.Expr()
+		}
+		if !p.Want(SEMICOLON) {
+			p.errorf("need ; here")
+		}
+		p.Next()
+		if !p.Want(LEFTBRACE) {
+			forStmt.Post = p.SimpleStmt(true)
+		}
 	}
-
-	return &returnStmt
+	p.exprLev = outer
+	forStmt.Cond = cond
+	forStmt.Init = init
+	forStmt.Body = p.loopBody(label)
+	forStmt.end = p.prevEnd
+	return &forStmt
 }
 
-func (p *Parser) IfStmt() Stmt {
-	var ifStmt IfStmt
+func (p *Parser) SwitchStmt() Stmt {
+	var switchStmt SwitchStmt
+	switchStmt.pos = p.pos()
+	label := p.label
+	p.label = ""
 	p.Next()
-	expr := p.BinaryExpr(0)
 	if !p.Want(LEFTBRACE) {
-		panic(fmt.Sprintf("%v: need {", p.Scanner.Pos))
+		outer := p.exprLev
+		p.exprLev = -1
+		switchStmt.Tag = p.Expr()
+		p.exprLev = outer
 	}
-	ifStmt.Cond = expr
-	var stmts []Stmt
+	if !p.Want(LEFTBRACE) {
+		p.errorf("{ need here")
+	}
+	p.Next()
+	p.targets = append(p.targets, jumpTarget{label: label})
+	hasDefault := false
 	for {
-		p.Next()
-		if p.Want(RIGHTBRACE) {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(RIGHTBRACE) || p.Want(EOF) {
 			break
 		}
-		stmts = append(stmts, p.Stmt())
+		clause := p.caseClause(switchStmt.Tag != nil)
+		if clause.Values == nil {
+			if hasDefault {
+				p.addError(&Error{Pos: clause.Pos(), Code: ErrSyntax, Msg: "multiple defaults in switch"})
+			}
+			hasDefault = true
+		}
+		switchStmt.Cases = append(switchStmt.Cases, clause)
 	}
+	if !p.Want(RIGHTBRACE) {
+		p.errorf("} need here")
+	}
+	p.targets = p.targets[:len(p.targets)-1]
 	p.Next()
-	ifStmt.Body = &BlockStmt{
-		Stmts: stmts,
+	if n := len(switchStmt.Cases); n > 0 && switchStmt.Cases[n-1].Fallthrough {
+		p.addError(&Error{Pos: switchStmt.Cases[n-1].Pos(), Code: ErrSyntax, Msg: "cannot fallthrough final case in switch"})
 	}
-	if p.Scanner.tToken == _KELSE {
+	switchStmt.end = p.prevEnd
+	return &switchStmt
+}
+
+// caseClause parses a case or default clause up to the next one.
+// Patterns need a switch value to match.
+func (p *Parser) caseClause(tagged bool) *CaseClause {
+	clause := &CaseClause{}
+	clause.pos = p.pos()
+	switch p.tToken {
+	case _KCASE:
 		p.Next()
-		if p.Scanner.tToken == _KIF {
-			ifStmt.Stmt = p.IfStmt()
-		} else {
-			if !p.Want(LEFTBRACE) {
-				panic(fmt.Sprintf("%v: need {", p.Scanner.Pos))
-			}
-			ifStmt.Cond = expr
-			var stmts []Stmt
-			for {
-				p.Next()
-				if p.Want(RIGHTBRACE) {
-					break
+		for {
+			x := p.pattern()
+			switch x.(type) {
+			case *ListPattern, *MapPattern:
+				if !tagged {
+					p.addError(&Error{Pos: x.Pos(), Code: ErrSyntax, Msg: "pattern in switch without value"})
 				}
-				stmts = append(stmts, p.Stmt())
 			}
-			ifStmt.Else = &BlockStmt{
-				Stmts: stmts,
-				Stmt:  nil,
+			clause.Values = append(clause.Values, x)
+			if !p.Want(COMMA) {
+				break
 			}
 			p.Next()
 		}
+	case _KDEFAULT:
+		p.Next()
+	default:
+		p.errorf("need case or default, found %s", p.tokDesc())
 	}
-	return &ifStmt
+	if !p.Want(COLON) {
+		p.errorf(": need here")
+	}
+	p.Next()
+	for {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(_KCASE) || p.Want(_KDEFAULT) || p.Want(RIGHTBRACE) || p.Want(EOF) {
+			break
+		}
+		if p.Want(_KFALLTHROUGH) {
+			p.Next()
+			for p.Want(SEMICOLON) {
+				p.Next()
+			}
+			if !p.Want(_KCASE) && !p.Want(_KDEFAULT) && !p.Want(RIGHTBRACE) {
+				p.errorf("fallthrough statement out of place")
+			}
+			clause.Fallthrough = true
+			break
+		}
+		clause.Body = append(clause.Body, p.stmtOrBad())
+	}
+	clause.end = p.prevEnd
+	return clause
 }
 
-func (p *Parser) ForStmt() Stmt {
+// pattern parses a case value or an element of a pattern.
+func (p *Parser) pattern() Expr {
+	switch p.tToken {
+	case LEFTBRACKET:
+		return p.listPattern()
+	case LEFTBRACE:
+		return p.mapPattern()
+	}
+	return p.listExpr()
+}
+
+func (p *Parser) listPattern() Expr {
+	list := &ListPattern{}
+	list.pos = p.pos()
 	p.Next()
-	var forStmt ForStmt
-	init := p.SimpleStmt(false)
+	for {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(RIGHTBRACKET) || p.Want(EOF) {
+			break
+		}
+		x := p.pattern()
+		if p.Want(ELLIPSIS) {
+			name, ok := x.(*Name)
+			if !ok {
+				p.addError(&Error{Pos: x.Pos(), Code: ErrSyntax, Msg: "rest of a list pattern must be a name"})
+				panic(bailout{})
+			}
+			list.Rest = name.Name
+			p.Next()
+			if !p.Want(RIGHTBRACKET) {
+				p.errorf("] need here")
+			}
+			break
+		}
+		list.Elems = append(list.Elems, x)
+		if p.Want(COMMA) {
+			p.Next()
+		}
+	}
+	if !p.Want(RIGHTBRACKET) {
+		p.errorf("] need here")
+	}
 	p.Next()
-	cond := p.BinaryExpr(0)
+	list.end = p.prevEnd
+	return list
+}
+
+func (p *Parser) mapPattern() Expr {
+	m := &MapPattern{}
+	m.pos = p.pos()
 	p.Next()
-	post := p.SimpleStmt(true)
-	if !p.Want(LEFTBRACE) {
-		panic(fmt.Sprintf("%v: need {", p.Scanner.Pos))
-	}
-	forStmt.Cond = cond
-	forStmt.Init = init
-	forStmt.Post = post
-	var stmts []Stmt
 	for {
-		p.Next()
-		if p.Want(RIGHTBRACE) {
+		for p.Want(SEMICOLON) {
+			p.Next()
+		}
+		if p.Want(RIGHTBRACE) || p.Want(EOF) {
 			break
 		}
-		stmts = append(stmts, p.Stmt())
+		m.Keys = append(m.Keys, p.listExpr())
+		if !p.Want(COLON) {
+			p.errorf(": need here")
+		}
+		p.Next()
+		m.Values = append(m.Values, p.pattern())
+		if p.Want(COMMA) {
+			p.Next()
+		}
+	}
+	if !p.Want(RIGHTBRACE) {
+		p.errorf("} need here")
 	}
-	forStmt.Body = &BlockStmt{Stmts: stmts}
 	p.Next()
-	return &forStmt
+	m.end = p.prevEnd
+	return m
+}
+
+// RangeStmt parses the rest of for k, v in x { ... } after the first
+// variable, first.
+func (p *Parser) RangeStmt(pos Pos, first Expr, label string) Stmt {
+	var rangeStmt RangeStmt
+	rangeStmt.pos = pos
+	name, ok := first.(*Name)
+	if !ok {
+		p.addError(&Error{Pos: first.Pos(), Code: ErrSyntax, Msg: "range variable must be a name"})
+		panic(bailout{})
+	}
+	rangeStmt.Value = name.Name
+	if p.Want(COMMA) {
+		p.Next()
+		if !p.Want(IDENT) {
+			p.errorf("range variable need here")
+		}
+		rangeStmt.Key, rangeStmt.Value = name.Name, p.Scanner.literal
+		p.Next()
+	}
+	if !p.Want(_KIN) {
+		p.errorf("in need here")
+	}
+	p.Next()
+	outer := p.exprLev
+	p.exprLev = -1
+	rangeStmt.X = p.Expr()
+	p.exprLev = outer
+	rangeStmt.Body = p.loopBody(label)
+	rangeStmt.end = p.prevEnd
+	return &rangeStmt
 }
 
-func (p *Parser) AssignStmt(name string, isFor bool) Stmt {
+func (p *Parser) AssignStmt(pos Pos, lhs Expr, isFor bool) Stmt {
 	var assignStmt AssignStmt
-	if name != "" {
-		assignStmt.Lhs = append(assignStmt.Lhs, name)
+	assignStmt.pos = pos
+	if lhs != nil {
+		assignStmt.Lhs = append(assignStmt.Lhs, p.target(lhs))
 	}
 	for {
+		if p.Want(COMMA) {
+			p.Next()
+			if !p.Want(IDENT) {
+				p.errorf("need assign target here")
+			}
+		}
 		if p.Scanner.tToken != IDENT {
 			break
 		}
-		assignStmt.Lhs = append(assignStmt.Lhs, p.Scanner.literal)
-		p.Next()
+		assignStmt.Lhs = append(assignStmt.Lhs, p.target(p.postfixExpr(p.operand())))
 	}
 
-	if !p.Want(ASSIGN) {
-		panic(fmt.Sprintf("%v need assign op here", p.Scanner.Pos))
+	if !p.atAssignOp() {
+		p.errorf("need assign op here")
+	}
+	if !p.Want(ASSIGN) && len(assignStmt.Lhs) != 1 {
+		p.errorf("%v needs a single variable", p.tToken)
 	}
 
-	p.Next()
-	for i := 0; i< len(assignStmt.Lhs); i++ {
-		expr := p.BinaryExpr(0)
-		if i == len(assignStmt.Lhs) - 1 {
-			if !isFor{
-				if !p.Want(SEMICOLON) {
-					panic(fmt.Sprintf("%v need ;  here", p.Scanner.Pos))
-				}
-			} else {
-				if !p.Want(LEFTBRACE) {
-					panic(fmt.Sprintf("%v need {  here", p.Scanner.Pos))
-				}
-			}
-		} else {
-			if !p.Want(COMMA) {
-				panic(fmt.Sprintf("%v need , here", p.Scanner.Pos))
-			}
+	switch p.tToken {
+	case ASSIGN:
+		p.Next()
+		assignStmt.Rhs = p.rhsList(len(assignStmt.Lhs))
+	case INC, DEC:
+		assignStmt.Op = OpPLUS
+		if p.Want(DEC) {
+			assignStmt.Op = OpMINUS
+		}
+		p.Next()
+	default:
+		assignStmt.Op = assignOps[p.tToken]
+		p.Next()
+		assignStmt.Rhs = []Expr{p.Expr()}
+	}
+	if !isFor {
+		if !p.atStmtEnd() {
+			p.errorf("need ; here")
+		}
+	} else {
+		if !p.Want(LEFTBRACE) {
+			p.errorf("need { here")
 		}
-		assignStmt.Rhs = append(assignStmt.Rhs, expr)
 	}
+	assignStmt.end = p.prevEnd
 	return &assignStmt
 }
 
-func(p *Parser) Error() {
-	panic(fmt.Sprintf("%s:%d:%d %s", p.Scanner.Pos.fileName, p.Scanner.Pos.line, p.Scanner.Pos.col, p.err.msg))
+// atAssignOp reports whether the current token is =, a compound
+// assignment operator, ++ or --.
+func (p *Parser) atAssignOp() bool {
+	_, ok := assignOps[p.tToken]
+	return ok || p.Want(ASSIGN) || p.Want(INC) || p.Want(DEC)
+}
+
+// target checks that x can be assigned to.
+func (p *Parser) target(x Expr) Expr {
+	switch x.(type) {
+	case *Name, *IndexExpr, *SelectorExpr:
+		return x
+	}
+	p.addError(&Error{Pos: x.Pos(), Code: ErrSyntax, Msg: "cannot assign to expression"})
+	panic(bailout{})
+}
+
+// plural returns n and noun, in the plural unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// addError records err unless it is on the line of the previous
+// error, where it most likely follows from that one.
+func (p *Parser) addError(err *Error) {
+	if n := len(p.errors); n > 0 && p.errors[n-1].Pos.line == err.Pos.line {
+		return
+	}
+	p.errors = append(p.errors, err)
+	if p.maxErrors > 0 && len(p.errors) >= p.maxErrors {
+		panic(tooManyErrors{})
+	}
+}
+
+// reportf reports a syntax error at the current token; the caller
+// carries on parsing.
+func (p *Parser) reportf(format string, args ...interface{}) {
+	p.addError(&Error{Pos: p.pos(), Code: ErrSyntax, Msg: fmt.Sprintf(format, args...)})
+}
+
+// errorf reports a syntax error at the current token and abandons
+// the enclosing statement or declaration.
+func (p *Parser) errorf(format string, args ...interface{}) {
+	p.reportf(format, args...)
+	panic(bailout{})
+}
+
+// pos returns where the current token starts.
+func (p *Parser) pos() Pos {
+	return p.Scanner.start
+}
+
+// badExpr returns a BadExpr at the current token.
+func (p *Parser) badExpr() *BadExpr {
+	bad := &BadExpr{}
+	bad.pos, bad.end = p.pos(), p.pos()
+	return bad
+}
+
+// atStmtEnd reports whether the current token ends a simple statement.
+func (p *Parser) atStmtEnd() bool {
+	return p.Want(SEMICOLON) || p.Want(RIGHTBRACE) || p.Want(EOF)
+}
+
+// tokDesc describes the current token for error messages.
+func (p *Parser) tokDesc() string {
+	switch p.Scanner.tToken {
+	case IDENT, NUM:
+		return fmt.Sprintf("%v %s", p.Scanner.tToken, p.Scanner.literal)
+	case STRING:
+		return fmt.Sprintf("%v %q", p.Scanner.tToken, p.Scanner.literal)
+	}
+	return p.Scanner.tToken.String()
 }
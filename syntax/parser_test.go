@@ -0,0 +1,254 @@
+package syntax
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parse parses src as the file x.toy and returns its errors, with
+// the directory the file is in left out.
+func parse(t *testing.T, src string, opts ...Option) (*File, []string) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "x.toy")
+	if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := ParseFile(name, opts...)
+	if err == nil {
+		return file, nil
+	}
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("%q: %v", src, err)
+	}
+	var errs []string
+	for _, e := range list {
+		errs = append(errs, strings.TrimPrefix(e.Error(), dir+string(filepath.Separator)))
+	}
+	return file, errs
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		errs []string
+	}{
+		{"func main() { print(1) }", nil},
+		{"func main() {\n\tvar a = 1 +\n\tprint(a)\n\tvar = 2\n\tif a { print(a) }\n}\n\nfunc f( {\n}\n", []string{
+			"x.toy:2:13: need operand after +, found ;",
+			"x.toy:4:6: need name here",
+			"x.toy:8:9: ) need here",
+		}},
+		{"var a = 1\nfunc f() { return 1 + }\nvar b = ", []string{
+			"x.toy:2:23: need operand after +, found }",
+			"x.toy:3:9: need expression, found EOF",
+		}},
+		{"func f() {\n\tvar a = 1\n\ta, a += 1\n\ta++ 3\n}", []string{
+			"x.toy:3:7: += needs a single variable",
+			"x.toy:4:6: need ; here",
+		}},
+	}
+	for _, test := range tests {
+		if _, errs := parse(t, test.src); !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q:\ngot  %q\nwant %q", test.src, errs, test.errs)
+		}
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	src := strings.Repeat("var = 1\n", 20)
+	if _, errs := parse(t, src); len(errs) != DefaultMaxErrors {
+		t.Errorf("got %d errors, want %d", len(errs), DefaultMaxErrors)
+	}
+	if _, errs := parse(t, src, MaxErrors(0)); len(errs) != 20 {
+		t.Errorf("MaxErrors(0): got %d errors, want 20", len(errs))
+	}
+}
+
+func TestSpans(t *testing.T) {
+	file, errs := parse(t, "func main() {\n\tprint(ab + 12)\n}\n")
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	f := file.Decl[0].(*FuncDecl)
+	call := f.Body[0].(*CallStmt).Call.(*CallExpr)
+	sum := call.Args[0].(*BinaryExpr)
+	tests := []struct {
+		node       Node
+		start, end string
+		offset     int
+	}{
+		{f, "1:1", "3:2", 0},
+		{call, "2:2", "2:16", 15},
+		{sum, "2:8", "2:15", 21},
+		{sum.Lhs, "2:8", "2:10", 21},
+		{sum.Rhs, "2:13", "2:15", 26},
+	}
+	for _, test := range tests {
+		pos, end := test.node.Pos(), test.node.End()
+		start := fmt.Sprintf("%d:%d", pos.Line(), pos.Col())
+		stop := fmt.Sprintf("%d:%d", end.Line(), end.Col())
+		if start != test.start || stop != test.end || pos.Offset() != test.offset {
+			t.Errorf("%T: got %s-%s at %d, want %s-%s at %d", test.node, start, stop, pos.Offset(), test.start, test.end, test.offset)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := `// Package doc, not the var's.
+
+// x is
+// documented.
+var x = 1 // trailing
+
+/* f is
+   documented too. */
+func f() {
+	print(x / /* inline */ 2) // the / is a division
+}
+
+// floating
+
+var y = 2
+`
+	file, errs := parse(t, src, ParseComments())
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if got := file.Decl[0].(*VarDecl).Doc.Text(); got != "x is\ndocumented.\n" {
+		t.Errorf("x doc %q", got)
+	}
+	if got := file.Decl[1].(*FuncDecl).Doc.Text(); got != "f is\ndocumented too.\n" {
+		t.Errorf("f doc %q", got)
+	}
+	if doc := file.Decl[2].(*VarDecl).Doc; doc != nil {
+		t.Errorf("y doc %q", doc.Text())
+	}
+	var texts []string
+	for _, g := range file.Comments {
+		for _, c := range g.List {
+			texts = append(texts, fmt.Sprintf("%d:%d %s", c.Pos().Line(), c.Pos().Col(), c.Text))
+		}
+	}
+	want := []string{
+		"1:1 // Package doc, not the var's.",
+		"3:1 // x is",
+		"4:1 // documented.",
+		"5:11 // trailing",
+		"7:1 /* f is\n   documented too. */",
+		"10:12 /* inline */",
+		"10:28 // the / is a division",
+		"13:1 // floating",
+	}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("comments:\ngot  %q\nwant %q", texts, want)
+	}
+	if _, errs := parse(t, "var a = 1 /* open"); !reflect.DeepEqual(errs, []string{"x.toy:1:11: comment not terminated"}) {
+		t.Errorf("unterminated comment: %q", errs)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{"var a = 1_000 + 0x_1f + 0b1 + 0o7 + 1.5e-3 + .5 + 1. + 0x1p-2", ""},
+		{"var a = 0x", "x.toy:1:9: hexadecimal literal has no digits"},
+		{"var a = 1e+", "x.toy:1:9: exponent has no digits"},
+		{"var a = 0x1.8", "x.toy:1:9: hexadecimal mantissa requires a 'p' exponent"},
+		{"var a = 0b102", "x.toy:1:9: invalid digit '2' in binary literal"},
+		{"var a = 12abc", "x.toy:1:9: invalid character 'a' in decimal literal"},
+		{"var a = 1__0", "x.toy:1:9: '_' must separate successive digits"},
+		{"var a = 10_", "x.toy:1:9: '_' must separate successive digits"},
+		{"var a = 1e308 + 0x1p1023", ""},
+		{"func f() { if false { return 1e400 } }", "x.toy:1:30: number 1e400 out of range"},
+		{"var a = 0x1p1024", "x.toy:1:9: number 0x1p1024 out of range"},
+		{"var a = 0b1" + strings.Repeat("0", 1024), "x.toy:1:9: number 0b1" + strings.Repeat("0", 1024) + " out of range"},
+	}
+	for _, test := range tests {
+		_, errs := parse(t, test.src)
+		var err string
+		if len(errs) > 0 {
+			err = errs[0]
+		}
+		if err != test.err {
+			t.Errorf("%q: got %q, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{`var s = "\t\"\\\x41\101é\U0001F600" + ` + "`raw \\n`", ""},
+		{`var s = "\q"`, `x.toy:1:10: unknown escape sequence`},
+		{`var s = "\x4"`, `x.toy:1:10: escape sequence is too short`},
+		{`var s = "\400"`, `x.toy:1:10: octal escape value > 255`},
+		{`var s = "\uD800"`, `x.toy:1:10: escape sequence is invalid Unicode code point`},
+		{`var s = "open`, `x.toy:1:9: string literal not terminated`},
+		{"var s = `open", "x.toy:1:9: raw string literal not terminated"},
+	}
+	for _, test := range tests {
+		_, errs := parse(t, test.src)
+		var err string
+		if len(errs) > 0 {
+			err = errs[0]
+		}
+		if err != test.err {
+			t.Errorf("%q: got %q, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestTypes(t *testing.T) {
+	tests := []struct {
+		src     string
+		names   []string
+		types   []string
+		results []string
+	}{
+		{"func f(a num, b, c P) {}", []string{"a", "b", "c"}, []string{"num", "", "P"}, nil},
+		{"func f(n num,) num {}", []string{"n"}, []string{"num"}, []string{"num"}},
+		{"func f(x Y) {}", []string{"x", "Y"}, nil, nil},
+		{"func f(a b c) {}", []string{"a", "b", "c"}, nil, nil},
+		{"func f(a, rest... string) {}", []string{"a", "rest"}, []string{"", "string"}, nil},
+		{"func f(a rest...) {}", []string{"a", "rest"}, nil, nil},
+		{"func f(g func) func {}", []string{"g"}, []string{"func"}, []string{"func"}},
+		{"func f() (num, Point) {}", nil, nil, []string{"num", "Point"}},
+		{"var s string = \"\"", []string{"s"}, []string{"string"}, nil},
+		{"var a B = 1, 2", []string{"a", "B"}, nil, nil},
+		{"var q r = divmod(7, 2)", []string{"q", "r"}, nil, nil},
+		{"var a, b num = 1, 2", []string{"a", "b"}, []string{"num"}, nil},
+		{"var a, b num = divmod(7, 2)", []string{"a", "b"}, []string{"num"}, nil},
+		{"var f func = g", []string{"f"}, []string{"func"}, nil},
+		{"const c string = \"\"", []string{"c"}, []string{"string"}, nil},
+	}
+	for _, test := range tests {
+		file, errs := parse(t, test.src)
+		if errs != nil {
+			t.Errorf("%q: %q", test.src, errs)
+			continue
+		}
+		var names, types, results []string
+		switch d := file.Decl[0].(type) {
+		case *FuncDecl:
+			names, types, results = d.Args, d.ArgTypes, d.Results
+		case *VarDecl:
+			names = d.Lhs
+			if d.Type != "" {
+				types = []string{d.Type}
+			}
+		}
+		if !reflect.DeepEqual(names, test.names) || !reflect.DeepEqual(types, test.types) || !reflect.DeepEqual(results, test.results) {
+			t.Errorf("%q: got %q %q %q, want %q %q %q", test.src, names, types, results, test.names, test.types, test.results)
+		}
+	}
+	if _, errs := parse(t, "func f() ( ) {}"); !reflect.DeepEqual(errs, []string{"x.toy:1:12: result type need here"}) {
+		t.Errorf("empty results: %q", errs)
+	}
+}
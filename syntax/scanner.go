@@ -1,42 +1,135 @@
 package syntax
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
+// Pos is a place in a source file. Line and column are 1-based,
+// the byte offset is 0-based.
 type Pos struct {
 	fileName string
 	line, col int
+	offset    int
+}
+
+func MakePos(fileName string, line, col, offset int) Pos {
+	return Pos{fileName: fileName, line: line, col: col, offset: offset}
+}
+
+func (pos Pos) Filename() string {
+	return pos.fileName
+}
+
+func (pos Pos) Line() int {
+	return pos.line
 }
 
-func(pos *Pos) String() string {
+func (pos Pos) Col() int {
+	return pos.col
+}
+
+func (pos Pos) Offset() int {
+	return pos.offset
+}
+
+func (pos Pos) String() string {
 	return fmt.Sprintf("%s:%d:%d", pos.fileName, pos.line, pos.col)
 }
 
+// IsValid reports whether pos refers to a place in a file.
+func (pos Pos) IsValid() bool {
+	return pos.line > 0
+}
+
+// Before reports whether pos comes before other in the same file.
+func (pos Pos) Before(other Pos) bool {
+	return pos.offset < other.offset
+}
+
 type Scanner struct {
 	content []byte
 	index int
+	// Pos is the position of the next unread byte.
 	Pos
-	literal string
-	tToken TokenType
-	err ScannerError
+	// start and end delimit the current token, prevEnd is the
+	// end of the token before it.
+	start, end, prevEnd Pos
+	literal             string
+	tToken              TokenType
+	errh                ErrorHandler
+	mode                Mode
+	// pendingSemi makes the next token a SEMICOLON, standing for
+	// the newlines of a block comment returned as a COMMENT.
+	pendingSemi bool
 	Prec Prec
 	isBinaryOp bool
 }
 
+// Prec is the precedence of a binary operator; a higher Prec binds
+// tighter. Unary operators, parsed by Parser.UnaryExpr, bind tighter
+// than every binary one.
 type Prec int
 const (
 	NONE Prec = iota
-	LOGICPREC
+	ORPREC
+	ANDPREC
+	CMPPREC
 	ADDPREC
 	MULPREC
 )
 
-type ScannerError struct {
-	msg string
-	pos Pos
+type binaryOp struct {
+	op   Op
+	prec Prec
+}
+
+// binaryOps is the table of binary operator tokens.
+// assignOps maps the compound assignment operators to the operator
+// they apply.
+var assignOps = map[TokenType]Op{
+	ADD_ASSIGN: OpPLUS,
+	SUB_ASSIGN: OpMINUS,
+	MUL_ASSIGN: OpMUL,
+	DIV_ASSIGN: OpDiv,
+	MOD_ASSIGN: OpMOD,
+}
+
+var binaryOps = map[TokenType]binaryOp{
+	OROR:   {OpOR, ORPREC},
+	ANDAND: {OpAND, ANDPREC},
+	EQUAL:  {OpEQ, CMPPREC},
+	NEQ:    {OpNEQ, CMPPREC},
+	LT:     {OpLT, CMPPREC},
+	LEQ:    {OpLEQ, CMPPREC},
+	GT:     {OpGT, CMPPREC},
+	GEQ:    {OpGEQ, CMPPREC},
+	PLUS:   {OpPLUS, ADDPREC},
+	MINUS:  {OpMINUS, ADDPREC},
+	MUL:    {OpMUL, MULPREC},
+	DIV:    {OpDiv, MULPREC},
+	MOD:    {OpMOD, MULPREC},
 }
 
-func NewScanner(fileName string, content []byte) *Scanner {
-	s := &Scanner{content: content}
+// Mode controls optional scanner behaviour.
+type Mode uint
+
+const (
+	// ScanComments returns comments as COMMENT tokens instead of
+	// skipping them.
+	ScanComments Mode = 1 << iota
+)
+
+// NewScanner returns a scanner over content. Errors are reported
+// to errh, which may be nil to ignore them.
+func NewScanner(fileName string, content []byte, errh ErrorHandler, mode Mode) *Scanner {
+	s := &Scanner{content: content, errh: errh, mode: mode}
 	s.Pos.fileName = fileName
 	s.Pos.line = 1
 	s.Pos.col = 1
@@ -44,8 +137,20 @@ func NewScanner(fileName string, content []byte) *Scanner {
 }
 
 func (s *Scanner) Next() {
-	s.isBinaryOp = false
+	s.prevEnd = s.end
+	defer func() {
+		s.end = s.Pos
+		bop, ok := binaryOps[s.tToken]
+		s.Prec, s.isBinaryOp = bop.prec, ok
+	}()
+	if s.pendingSemi {
+		s.pendingSemi = false
+		s.start = s.Pos
+		s.tToken = SEMICOLON
+		return
+	}
 	for {
+		s.start = s.Pos
 		ch, ok := s.nextCh()
 		if !ok {
 			s.tToken = EOF
@@ -54,51 +159,20 @@ func (s *Scanner) Next() {
 		switch ch {
 		case '\r':
 		case '\n':
-			fallthrough
-		case ';':
 			s.line++
 			s.col = 1
 			s.tToken = SEMICOLON
 			return
+		case ';':
+			s.col++
+			s.tToken = SEMICOLON
+			return
 		case '"':
-			s.tToken = STRING
-			s.col ++
-			str := ""
-			for {
-				ch, ok := s.nextCh()
-				if !ok {
-					s.err = ScannerError{
-						msg: "",
-						pos: s.Pos,
-					}
-					return
-				}
-				s.col ++
-				if ch == '\\' {
-					ch, ok := s.nextCh()
-					if !ok {
-						s.err = ScannerError{
-							msg: "",
-							pos: s.Pos,
-						}
-						return
-					}
-					s.col ++
-					if ch == 'n' {
-						str += "\n"
-					}
-					if ch == 't' {
-						str += "\t"
-					}
-				} else {
-					if ch == '"' {
-						s.literal = str
-						return
-					} else {
-						str += string(ch)
-					}
-				}
-			}
+			s.stdString()
+			return
+		case '`':
+			s.rawString()
+			return
 		case '{':
 			s.tToken = LEFTBRACE
 			s.col++
@@ -111,31 +185,52 @@ func (s *Scanner) Next() {
 			s.tToken = COMMA
 			s.col ++
 			return
-		case ' ':
+		case ' ', '\t':
 			s.col ++
 		case '+':
 			s.col ++
 			s.tToken = PLUS
-			s.isBinaryOp = true
-			s.Prec = ADDPREC
+			if s.peek() == '+' {
+				s.read()
+				s.tToken = INC
+			} else {
+				s.assignOp(ADD_ASSIGN)
+			}
 			return
 		case '-':
 			s.col ++
 			s.tToken = MINUS
-			s.isBinaryOp = true
-			s.Prec = ADDPREC
+			if s.peek() == '-' {
+				s.read()
+				s.tToken = DEC
+			} else {
+				s.assignOp(SUB_ASSIGN)
+			}
 			return
 		case '*':
 			s.col ++
 			s.tToken = MUL
-			s.isBinaryOp = true
-			s.Prec = MULPREC
+			s.assignOp(MUL_ASSIGN)
 			return
 		case '/':
+			if s.index < len(s.content) && (s.content[s.index] == '/' || s.content[s.index] == '*') {
+				// a block comment spanning lines separates
+				// statements like the newlines in it would.
+				newline := s.comment()
+				if s.mode&ScanComments != 0 {
+					s.tToken = COMMENT
+					s.pendingSemi = newline
+					return
+				}
+				if newline {
+					s.tToken = SEMICOLON
+					return
+				}
+				continue
+			}
 			s.col ++
 			s.tToken = DIV
-			s.isBinaryOp = true
-			s.Prec = MULPREC
+			s.assignOp(DIV_ASSIGN)
 			return
 		case '=':
 			ch, ok := s.nextCh()
@@ -147,14 +242,37 @@ func (s *Scanner) Next() {
 			if ch == '=' {
 				s.tToken = EQUAL
 				s.col += 2
-				s.isBinaryOp = true
-				s.Prec = LOGICPREC
 				return
 			}
 			s.unGetCh()
 			s.col++
 			s.tToken = ASSIGN
 			return
+		case '%':
+			s.col++
+			s.tToken = MOD
+			s.assignOp(MOD_ASSIGN)
+			return
+		case '!':
+			s.col++
+			s.tToken = NOT
+			if s.peek() == '=' {
+				s.read()
+				s.tToken = NEQ
+			}
+			return
+		case '&', '|':
+			if s.peek() == ch {
+				s.read()
+				s.col++
+				s.tToken = ANDAND
+				if ch == '|' {
+					s.tToken = OROR
+				}
+				return
+			}
+			s.errorAt(s.start, ErrIllegalChar, fmt.Sprintf("illegal character %q, did you mean %q?", ch, string(ch)+string(ch)))
+			s.col++
 		case '(':
 			s.tToken = LEFTPAREN
 			s.col ++
@@ -163,9 +281,34 @@ func (s *Scanner) Next() {
 			s.tToken = RIGHTPAREN
 			s.col ++
 			return
+		case '[':
+			s.tToken = LEFTBRACKET
+			s.col++
+			return
+		case ']':
+			s.tToken = RIGHTBRACKET
+			s.col++
+			return
+		case ':':
+			s.tToken = COLON
+			s.col++
+			return
+		case '.':
+			if s.index+1 < len(s.content) && s.content[s.index] == '.' && s.content[s.index+1] == '.' {
+				s.index += 2
+				s.offset = s.index
+				s.col += 3
+				s.tToken = ELLIPSIS
+				return
+			}
+			if isDigit(s.peek()) {
+				s.number(ch)
+				return
+			}
+			s.col++
+			s.tToken = DOT
+			return
 		case '<':
-			s.isBinaryOp = true
-			s.Prec = LOGICPREC
 			ch, ok := s.nextCh()
 			if !ok {
 				s.col++
@@ -182,8 +325,6 @@ func (s *Scanner) Next() {
 			s.tToken = LT
 			return
 		case '>':
-			s.isBinaryOp = true
-			s.Prec = LOGICPREC
 			ch, ok := s.nextCh()
 			if !ok {
 				s.col++
@@ -201,47 +342,241 @@ func (s *Scanner) Next() {
 			return
 		default:
 			if isDigit(ch) {
-				s.tToken = NUM
-				str := string(ch)
-				s.col++
-				for {
-					ch, ok := s.nextCh()
-					if !ok {
-						s.literal = str
-						return
-					}
-					if isDigit(ch) {
-						str += string(ch)
-						s.col ++
-					} else {
-						s.unGetCh()
-						s.literal = str
-						return
-					}
-				}
+				s.number(ch)
+				return
+			}
+			r, size := rune(ch), 1
+			if ch >= utf8.RuneSelf {
+				r, size = utf8.DecodeRune(s.content[s.index-1:])
+			}
+			if isLetter(r) {
+				s.unGetCh()
+				s.ident()
+				return
+			}
+			if r == utf8.RuneError && size == 1 {
+				s.errorAt(s.start, ErrIllegalChar, "invalid UTF-8 encoding")
 			} else {
-				if isLegalIdent(ch, true) {
-					str := string(ch)
-					s.col ++
-					for {
-						ch, ok := s.nextCh()
-						if !ok {
-							s.Ident(str)
-							return
-						}
-						if isLegalIdent(ch, false) {
-							s.col++
-							str += string(ch)
-						} else {
-							s.unGetCh()
-							s.Ident(str)
-							return
-						}
-					}
-				}
+				s.errorAt(s.start, ErrIllegalChar, fmt.Sprintf("illegal character %q", r))
+			}
+			s.index += size - 1
+			s.offset = s.index
+			s.col++
+		}
+	}
+}
+
+// ident scans a name starting at the next unread byte.
+func (s *Scanner) ident() {
+	start := s.index
+	for s.index < len(s.content) {
+		r, size := utf8.DecodeRune(s.content[s.index:])
+		if !isLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		s.index += size
+		s.col++
+	}
+	s.offset = s.index
+	s.Ident(string(s.content[start:s.index]))
+}
+
+// stdString scans a "..." string whose opening quote was read and
+// interprets its escape sequences the way Go does.
+func (s *Scanner) stdString() {
+	s.tToken = STRING
+	s.col++
+	var buf []byte
+	for {
+		at := s.Pos
+		ch, ok := s.nextCh()
+		if !ok || ch == '\n' {
+			if ok {
+				s.unGetCh()
+			}
+			s.errorAt(s.start, ErrUnterminatedString, "string literal not terminated")
+			break
+		}
+		s.advanceCol(ch)
+		if ch == '"' {
+			break
+		}
+		if ch == '\\' {
+			buf = s.escape(at, buf)
+			continue
+		}
+		buf = append(buf, ch)
+	}
+	s.literal = string(buf)
+}
+
+// escape reads the escape sequence after a backslash found at pos
+// and appends its value to buf.
+func (s *Scanner) escape(pos Pos, buf []byte) []byte {
+	ch := s.peek()
+	if ch == 0 || ch == '\n' {
+		// leave it to the caller to report the unterminated string
+		return buf
+	}
+	s.read()
+	switch ch {
+	case 'a':
+		return append(buf, '\a')
+	case 'b':
+		return append(buf, '\b')
+	case 'f':
+		return append(buf, '\f')
+	case 'n':
+		return append(buf, '\n')
+	case 'r':
+		return append(buf, '\r')
+	case 't':
+		return append(buf, '\t')
+	case 'v':
+		return append(buf, '\v')
+	case '\\', '"':
+		return append(buf, ch)
+	case 'x':
+		if v, ok := s.escapeDigits(pos, 2, 16); ok {
+			buf = append(buf, byte(v))
+		}
+		return buf
+	case 'u', 'U':
+		n := 4
+		if ch == 'U' {
+			n = 8
+		}
+		v, ok := s.escapeDigits(pos, n, 16)
+		if !ok {
+			return buf
+		}
+		if v > unicode.MaxRune || 0xD800 <= v && v < 0xE000 {
+			s.errorAt(pos, ErrInvalidEscape, "escape sequence is invalid Unicode code point")
+			return buf
+		}
+		return utf8.AppendRune(buf, rune(v))
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		s.unGetCh()
+		s.col--
+		v, ok := s.escapeDigits(pos, 3, 8)
+		if ok && v > 255 {
+			s.errorAt(pos, ErrInvalidEscape, "octal escape value > 255")
+			return buf
+		}
+		if ok {
+			buf = append(buf, byte(v))
+		}
+		return buf
+	}
+	s.errorAt(pos, ErrInvalidEscape, "unknown escape sequence")
+	return buf
+}
+
+// escapeDigits reads the n base digits of an escape sequence.
+func (s *Scanner) escapeDigits(pos Pos, n int, base uint32) (uint32, bool) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		ch := s.peek()
+		d := uint32(base)
+		switch {
+		case isDigit(ch):
+			d = uint32(ch - '0')
+		case 'a' <= lower(ch) && lower(ch) <= 'f':
+			d = uint32(lower(ch) - 'a' + 10)
+		}
+		if d >= base {
+			if ch == '"' || ch == '\n' || ch == 0 {
+				s.errorAt(pos, ErrInvalidEscape, "escape sequence is too short")
+			} else {
+				s.errorAt(pos, ErrInvalidEscape, fmt.Sprintf("invalid character %q in escape sequence", ch))
+			}
+			return 0, false
+		}
+		s.read()
+		v = v*base + d
+	}
+	return v, true
+}
+
+// rawString scans a `...` string whose opening quote was read. It
+// may span lines; carriage returns are dropped from its value.
+func (s *Scanner) rawString() {
+	s.tToken = STRING
+	s.col++
+	var buf []byte
+	for {
+		ch, ok := s.nextCh()
+		if !ok {
+			s.errorAt(s.start, ErrUnterminatedString, "raw string literal not terminated")
+			break
+		}
+		if ch == '`' {
+			s.col++
+			break
+		}
+		if ch == '\n' {
+			s.line++
+			s.col = 1
+		} else {
+			s.advanceCol(ch)
+		}
+		if ch != '\r' {
+			buf = append(buf, ch)
+		}
+	}
+	s.literal = string(buf)
+}
+
+// advanceCol moves the column past ch. Columns count characters, so
+// the continuation bytes of a UTF-8 sequence do not move it.
+func (s *Scanner) advanceCol(ch byte) {
+	if ch&0xC0 != 0x80 {
+		s.col++
+	}
+}
+
+// comment scans a // or /* comment whose leading '/' was read and
+// leaves its text in s.literal. A line comment stops before the
+// newline ending it. comment reports whether the comment contains
+// a newline.
+func (s *Scanner) comment() bool {
+	ch, _ := s.nextCh()
+	s.col += 2
+	newline := false
+	if ch == '/' {
+		for s.index < len(s.content) && s.content[s.index] != '\n' {
+			ch, _ := s.nextCh()
+			s.advanceCol(ch)
+		}
+	} else {
+		for {
+			ch, ok := s.nextCh()
+			if !ok {
+				s.errorAt(s.start, ErrUnterminatedComment, "comment not terminated")
+				break
+			}
+			if ch == '\n' {
+				s.line++
+				s.col = 1
+				newline = true
+				continue
+			}
+			s.advanceCol(ch)
+			if ch == '*' && s.index < len(s.content) && s.content[s.index] == '/' {
+				s.nextCh()
+				s.col++
+				break
 			}
 		}
 	}
+	s.literal = string(s.content[s.start.offset:s.index])
+	return newline
+}
+
+func (s *Scanner) errorAt(pos Pos, code ErrorCode, msg string) {
+	if s.errh != nil {
+		s.errh(&Error{Pos: pos, Code: code, Msg: msg})
+	}
 }
 
 func (s *Scanner) Ident(str string) {
@@ -263,10 +598,36 @@ func (s *Scanner) Ident(str string) {
 		s.tToken = _KCONTINUE
 	case "return":
 		s.tToken = _KRETURN
+	case "type":
+		s.tToken = _KTYPE
+	case "struct":
+		s.tToken = _KSTRUCT
+	case "in":
+		s.tToken = _KIN
+	case "switch":
+		s.tToken = _KSWITCH
+	case "case":
+		s.tToken = _KCASE
+	case "default":
+		s.tToken = _KDEFAULT
+	case "fallthrough":
+		s.tToken = _KFALLTHROUGH
+	case "const":
+		s.tToken = _KCONST
+	case "true":
+		s.tToken = _KTRUE
+	case "false":
+		s.tToken = _KFALSE
+	case "nil":
+		s.tToken = _KNIL
 	default:
 		s.tToken = IDENT
 	}
 }
+func isLetter(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
 func isLegalIdent(ch byte, first bool) bool {
 	if ch <= 'z' && ch >= 'a' {
 		return true
@@ -284,6 +645,179 @@ func isLegalIdent(ch byte, first bool) bool {
 }
 
 
+// number scans a numeric literal whose first byte, a digit or the
+// '.' of a fraction like .5, was read. Malformed literals are
+// reported here so the error points at them; the literal is still
+// returned as a NUM.
+func (s *Scanner) number(first byte) {
+	s.tToken = NUM
+	s.col++
+	base, kind := 10, "decimal"
+	if first == '0' {
+		switch lower(s.peek()) {
+		case 'x':
+			base, kind = 16, "hexadecimal"
+		case 'b':
+			base, kind = 2, "binary"
+		case 'o':
+			base, kind = 8, "octal"
+		}
+		if base != 10 {
+			s.read()
+		}
+	}
+	var badDigit byte
+	digits := 0
+	fraction := first == '.'
+	if first != '.' && base == 10 {
+		digits++
+	}
+	digits += s.digits(base, &badDigit)
+	if !fraction && s.peek() == '.' && s.peekAt(1) != '.' && (base == 10 || base == 16) {
+		s.read()
+		fraction = true
+	}
+	if fraction {
+		digits += s.digits(base, &badDigit)
+	}
+	var msg string
+	if digits == 0 {
+		msg = kind + " literal has no digits"
+	}
+	if e := lower(s.peek()); e == 'e' && base == 10 || e == 'p' && base == 16 {
+		s.read()
+		if c := s.peek(); c == '+' || c == '-' {
+			s.read()
+		}
+		if s.digits(10, &badDigit) == 0 && msg == "" {
+			msg = "exponent has no digits"
+		}
+	} else if fraction && base == 16 && msg == "" {
+		msg = "hexadecimal mantissa requires a 'p' exponent"
+	}
+	if badDigit != 0 && msg == "" {
+		msg = fmt.Sprintf("invalid digit %q in %s literal", badDigit, kind)
+	}
+	if isLegalIdent(s.peek(), false) {
+		// swallow the rest of something like 12abc so it is not
+		// scanned as a name right after the number.
+		if msg == "" {
+			msg = fmt.Sprintf("invalid character %q in %s literal", s.peek(), kind)
+		}
+		for isLegalIdent(s.peek(), false) {
+			s.read()
+		}
+	}
+	s.literal = string(s.content[s.start.offset:s.index])
+	if i := invalidSep(s.literal); i >= 0 && msg == "" {
+		msg = "'_' must separate successive digits"
+	}
+	if msg == "" {
+		if _, err := ParseNum(s.literal); err != nil {
+			msg = err.Error()
+		}
+	}
+	if msg != "" {
+		s.errorAt(s.start, ErrMalformedNumber, msg)
+	}
+}
+
+// ParseNum converts the text of a NUM literal to its value. It
+// fails for a malformed literal or one too large for a float64.
+func ParseNum(lit string) (float64, error) {
+	if len(lit) > 1 && lit[0] == '0' {
+		switch lit[1] {
+		case 'x', 'X':
+			if !strings.ContainsAny(lit, "pP") {
+				lit += "p0"
+			}
+		case 'b', 'B', 'o', 'O':
+			i, ok := new(big.Int).SetString(lit, 0)
+			if !ok {
+				return 0, fmt.Errorf("malformed number %s", lit)
+			}
+			num, _ := new(big.Float).SetInt(i).Float64()
+			if math.IsInf(num, 0) {
+				return 0, fmt.Errorf("number %s out of range", lit)
+			}
+			return num, nil
+		}
+	}
+	num, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("number %s out of range", lit)
+		}
+		return 0, fmt.Errorf("malformed number %s", lit)
+	}
+	return num, nil
+}
+
+// digits reads the digits of a base literal and the '_' between
+// them. Decimal digits too large for base are read as well and the
+// first one is recorded in badDigit. It returns the number of digits.
+func (s *Scanner) digits(base int, badDigit *byte) int {
+	n := 0
+	for {
+		ch := s.peek()
+		switch {
+		case ch == '_':
+		case isDigit(ch):
+			if int(ch-'0') >= base && *badDigit == 0 {
+				*badDigit = ch
+			}
+			n++
+		case base == 16 && 'a' <= lower(ch) && lower(ch) <= 'f':
+			n++
+		default:
+			return n
+		}
+		s.read()
+	}
+}
+
+// invalidSep returns the index of the first '_' in the numeric
+// literal x that does not separate two digits, or -1.
+func invalidSep(x string) int {
+	x1 := byte(' ')
+	// d is '_', '0' for a digit, or '.' for anything else.
+	d := byte('.')
+	i := 0
+	if len(x) >= 2 && x[0] == '0' {
+		x1 = lower(x[1])
+		if x1 == 'x' || x1 == 'o' || x1 == 'b' {
+			// the base prefix counts as a digit
+			d = '0'
+			i = 2
+		}
+	}
+	for ; i < len(x); i++ {
+		p := d
+		d = x[i]
+		switch {
+		case d == '_':
+			if p != '0' {
+				return i
+			}
+		case isDigit(d) || x1 == 'x' && 'a' <= lower(d) && lower(d) <= 'f':
+			d = '0'
+		default:
+			if p == '_' {
+				return i - 1
+			}
+			d = '.'
+		}
+	}
+	if d == '_' {
+		return len(x) - 1
+	}
+	return -1
+}
+
+func lower(ch byte) byte {
+	return ch | 0x20
+}
+
 func isDigit(ch byte) bool {
 	a := int(ch - '0')
 	return a >=0 && a <= 9
@@ -295,46 +829,104 @@ func (s *Scanner) nextCh() (byte, bool) {
 	}
 	ch := s.content[s.index]
 	s.index++
+	s.offset = s.index
 	return ch, true
 }
 
+// peek returns the next unread byte, or 0 at the end.
+// assignOp makes the operator just scanned the assignment operator
+// tok if a = follows it.
+func (s *Scanner) assignOp(tok TokenType) {
+	if s.peek() == '=' {
+		s.read()
+		s.tToken = tok
+	}
+}
+
+func (s *Scanner) peek() byte {
+	return s.peekAt(0)
+}
+
+func (s *Scanner) peekAt(n int) byte {
+	if s.index+n >= len(s.content) {
+		return 0
+	}
+	return s.content[s.index+n]
+}
+
+// read consumes a byte of a token that does not span lines.
+func (s *Scanner) read() {
+	s.nextCh()
+	s.col++
+}
+
 func (s *Scanner) unGetCh() {
 	s.index--
+	s.offset = s.index
 }
 
 //go:generate stringer -type TokenType -linecomment scanner.go
 type TokenType int
 
 const (
-	_ TokenType = iota
-	IDENT
-	_KVAR
-	_KFUNC
-	_KIF
-	_KELSE
-	_KFOR
-	_KBREAK
-	_KCONTINUE
-	_KRETURN
-	NUM
-	STRING
-	EOF
-	MINUS
-	PLUS
-	MUL
-	DIV
-	LT
-	LEQ
-	GT
-	GEQ
-	LEFTPAREN
-	RIGHTPAREN
-	LEFTBRACE
-	RIGHTBRACE
-	ASSIGN
-	EQUAL
-	SEMICOLON
-	COMMA
+	_             TokenType = iota
+	IDENT                   // name
+	_KVAR                   // var
+	_KFUNC                  // func
+	_KIF                    // if
+	_KELSE                  // else
+	_KFOR                   // for
+	_KBREAK                 // break
+	_KCONTINUE              // continue
+	_KRETURN                // return
+	_KTRUE                  // true
+	_KFALSE                 // false
+	_KNIL                   // nil
+	NUM                     // number
+	STRING                  // string
+	EOF                     // EOF
+	MINUS                   // -
+	PLUS                    // +
+	MUL                     // *
+	DIV                     // /
+	LT                      // <
+	LEQ                     // <=
+	GT                      // >
+	GEQ                     // >=
+	LEFTPAREN               // (
+	RIGHTPAREN              // )
+	LEFTBRACE               // {
+	RIGHTBRACE              // }
+	ASSIGN                  // =
+	EQUAL                   // ==
+	SEMICOLON               // ;
+	COMMA                   // ,
+	ELLIPSIS                // ...
+	COMMENT                 // comment
+	MOD                     // %
+	NOT                     // !
+	NEQ                     // !=
+	ANDAND                  // &&
+	OROR                    // ||
+	LEFTBRACKET             // [
+	RIGHTBRACKET            // ]
+	COLON                   // :
+	_KTYPE                  // type
+	_KSTRUCT                // struct
+	DOT                     // .
+	_KIN                    // in
+	_KSWITCH                // switch
+	_KCASE                  // case
+	_KDEFAULT               // default
+	_KFALLTHROUGH           // fallthrough
+	ADD_ASSIGN              // +=
+	SUB_ASSIGN              // -=
+	MUL_ASSIGN              // *=
+	DIV_ASSIGN              // /=
+	MOD_ASSIGN              // %=
+	INC                     // ++
+	DEC                     // --
+	_KCONST                 // const
 )
 
 
@@ -17,30 +17,59 @@ func _() {
 	_ = x[_KBREAK-7]
 	_ = x[_KCONTINUE-8]
 	_ = x[_KRETURN-9]
-	_ = x[NUM-10]
-	_ = x[STRING-11]
-	_ = x[EOF-12]
-	_ = x[MINUS-13]
-	_ = x[PLUS-14]
-	_ = x[MUL-15]
-	_ = x[DIV-16]
-	_ = x[LT-17]
-	_ = x[LEQ-18]
-	_ = x[GT-19]
-	_ = x[GEQ-20]
-	_ = x[LEFTPAREN-21]
-	_ = x[RIGHTPAREN-22]
-	_ = x[LEFTBRACE-23]
-	_ = x[RIGHTBRACE-24]
-	_ = x[ASSIGN-25]
-	_ = x[EQUAL-26]
-	_ = x[SEMICOLON-27]
-	_ = x[COMMA-28]
+	_ = x[_KTRUE-10]
+	_ = x[_KFALSE-11]
+	_ = x[_KNIL-12]
+	_ = x[NUM-13]
+	_ = x[STRING-14]
+	_ = x[EOF-15]
+	_ = x[MINUS-16]
+	_ = x[PLUS-17]
+	_ = x[MUL-18]
+	_ = x[DIV-19]
+	_ = x[LT-20]
+	_ = x[LEQ-21]
+	_ = x[GT-22]
+	_ = x[GEQ-23]
+	_ = x[LEFTPAREN-24]
+	_ = x[RIGHTPAREN-25]
+	_ = x[LEFTBRACE-26]
+	_ = x[RIGHTBRACE-27]
+	_ = x[ASSIGN-28]
+	_ = x[EQUAL-29]
+	_ = x[SEMICOLON-30]
+	_ = x[COMMA-31]
+	_ = x[ELLIPSIS-32]
+	_ = x[COMMENT-33]
+	_ = x[MOD-34]
+	_ = x[NOT-35]
+	_ = x[NEQ-36]
+	_ = x[ANDAND-37]
+	_ = x[OROR-38]
+	_ = x[LEFTBRACKET-39]
+	_ = x[RIGHTBRACKET-40]
+	_ = x[COLON-41]
+	_ = x[_KTYPE-42]
+	_ = x[_KSTRUCT-43]
+	_ = x[DOT-44]
+	_ = x[_KIN-45]
+	_ = x[_KSWITCH-46]
+	_ = x[_KCASE-47]
+	_ = x[_KDEFAULT-48]
+	_ = x[_KFALLTHROUGH-49]
+	_ = x[ADD_ASSIGN-50]
+	_ = x[SUB_ASSIGN-51]
+	_ = x[MUL_ASSIGN-52]
+	_ = x[DIV_ASSIGN-53]
+	_ = x[MOD_ASSIGN-54]
+	_ = x[INC-55]
+	_ = x[DEC-56]
+	_ = x[_KCONST-57]
 }
 
-const _TokenType_name = "IDENT_KVAR_KFUNC_KIF_KELSE_KFOR_KBREAK_KCONTINUE_KRETURNNUMSTRINGEOFMINUSPLUSMULDIVLTLEQGTGEQLEFTPARENRIGHTPARENLEFTBRACERIGHTBRACEASSIGNEQUALSEMICOLONCOMMA"
+const _TokenType_name = "namevarfuncifelseforbreakcontinuereturntruefalsenilnumberstringEOF-+*/<<=>>=(){}===;,...comment%!!=&&||[]:typestruct.inswitchcasedefaultfallthrough+=-=*=/=%=++--const"
 
-var _TokenType_index = [...]uint8{0, 5, 10, 16, 20, 26, 31, 38, 48, 56, 59, 65, 68, 73, 77, 80, 83, 85, 88, 90, 93, 102, 112, 121, 131, 137, 142, 151, 156}
+var _TokenType_index = [...]uint8{0, 4, 7, 11, 13, 17, 20, 25, 33, 39, 43, 48, 51, 57, 63, 66, 67, 68, 69, 70, 71, 73, 74, 76, 77, 78, 79, 80, 81, 83, 84, 85, 88, 95, 96, 97, 99, 101, 103, 104, 105, 106, 110, 116, 117, 119, 125, 129, 136, 147, 149, 151, 153, 155, 157, 159, 161, 166}
 
 func (i TokenType) String() string {
 	i -= 1
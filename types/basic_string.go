@@ -0,0 +1,31 @@
+// Code generated by "stringer -type Basic -linecomment types.go"; DO NOT EDIT.
+
+package types
+
+import "strconv"
+
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[Any-1]
+	_ = x[Bool-2]
+	_ = x[Num-3]
+	_ = x[String-4]
+	_ = x[Nil-5]
+	_ = x[List-6]
+	_ = x[Map-7]
+	_ = x[Func-8]
+}
+
+const _Basic_name = "anyboolnumstringnillistmapfunc"
+
+var _Basic_index = [...]uint8{0, 3, 7, 10, 16, 19, 23, 26, 30}
+
+func (i Basic) String() string {
+	i -= 1
+	if i < 0 || i >= Basic(len(_Basic_index)-1) {
+		return "Basic(" + strconv.FormatInt(int64(i+1), 10) + ")"
+	}
+	return _Basic_name[_Basic_index[i]:_Basic_index[i+1]]
+}
@@ -0,0 +1,768 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// scope holds the types of the names declared by a func, a statement
+// running in a scope of its own or the program, as ir.Resolve has
+// them.
+type scope struct {
+	parent *scope
+	names  map[string]Type
+}
+
+// closure is a func literal whose body is checked once the rest of
+// the program is, as ir.Resolve does, so it sees every name of the
+// scopes it closes over.
+type closure struct {
+	f     *ir.Func
+	sig   *Signature
+	scope *scope
+}
+
+type checker struct {
+	scope   *scope
+	structs map[string]*Struct
+	// sig is the signature of the func being checked, nil at the
+	// top level.
+	sig      *Signature
+	closures []closure
+	errs     syntax.ErrorList
+}
+
+// Check checks the types of the program nodes, whose names were
+// bound by ir.Resolve. A global the program does not declare, like
+// a builtin, is of type any. The errors are returned as a
+// syntax.ErrorList.
+func Check(nodes []ir.Node) error {
+	c := &checker{structs: make(map[string]*Struct)}
+	c.scope = &scope{names: make(map[string]Type)}
+	for _, node := range nodes {
+		if d, ok := node.(*ir.TypeDecl); ok {
+			s := &Struct{Name: d.Name, Fields: d.Fields, Methods: make(map[string]*Signature)}
+			c.structs[d.Name] = s
+			c.scope.names[d.Name] = typeName{s}
+		}
+	}
+	// the funcs are typed before any value is, so a var can call
+	// the ones below it; a var is any until its declaration is
+	// checked.
+	sigs := make(map[*ir.Func]*Signature)
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ir.Func:
+			sig := c.signature(node)
+			sigs[node] = sig
+			if node.RecvType == "" {
+				c.scope.names[node.FuncName] = sig
+			} else if s, ok := c.structs[node.RecvType]; ok {
+				s.Methods[node.FuncName] = sig
+			}
+		case *ir.VarDecl:
+			for _, name := range node.Lhs {
+				c.scope.names[name] = Any
+			}
+		}
+	}
+	for _, node := range nodes {
+		if d, ok := node.(*ir.VarDecl); ok {
+			c.varDecl(d)
+		}
+	}
+	for _, node := range nodes {
+		if f, ok := node.(*ir.Func); ok {
+			c.funcBody(f, sigs[f])
+		}
+	}
+	for len(c.closures) > 0 {
+		cl := c.closures[0]
+		c.closures = c.closures[1:]
+		c.scope = cl.scope
+		c.funcBody(cl.f, cl.sig)
+	}
+	c.errs.Sort()
+	return c.errs.Err()
+}
+
+func (c *checker) errorf(node ir.Node, format string, args ...interface{}) {
+	c.errs.Add(node.Pos(), syntax.ErrType, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) open() {
+	c.scope = &scope{parent: c.scope, names: make(map[string]Type)}
+}
+
+func (c *checker) close() {
+	c.scope = c.scope.parent
+}
+
+func (c *checker) declare(name string, t Type) {
+	c.scope.names[name] = t
+}
+
+// lookup returns the type of the variable name, any when the program
+// does not declare it.
+func (c *checker) lookup(name string) Type {
+	for s := c.scope; s != nil; s = s.parent {
+		if t, ok := s.names[name]; ok {
+			return t
+		}
+	}
+	return Any
+}
+
+var basics = map[string]Basic{
+	"any":    Any,
+	"bool":   Bool,
+	"num":    Num,
+	"string": String,
+	"list":   List,
+	"map":    Map,
+	"func":   Func,
+}
+
+// typeOf returns the type named name, given at pos, which is any
+// when name is empty.
+func (c *checker) typeOf(pos syntax.Pos, name string) Type {
+	if name == "" {
+		return Any
+	}
+	if t, ok := basics[name]; ok {
+		return t
+	}
+	if s, ok := c.structs[name]; ok {
+		return s
+	}
+	c.errs.Add(pos, syntax.ErrType, fmt.Sprintf("undefined type %s", name))
+	return Any
+}
+
+func (c *checker) signature(f *ir.Func) *Signature {
+	sig := &Signature{Params: make([]Type, len(f.Args)), Variadic: f.Variadic}
+	for i := range f.Args {
+		sig.Params[i] = Any
+		if f.ArgTypes != nil {
+			sig.Params[i] = c.typeOf(f.ArgPos[i], f.ArgTypes[i])
+		}
+	}
+	for _, name := range f.Results {
+		sig.Results = append(sig.Results, c.typeOf(f.Pos(), name))
+	}
+	return sig
+}
+
+func (c *checker) funcBody(f *ir.Func, sig *Signature) {
+	outer := c.sig
+	c.sig = sig
+	c.open()
+	if f.Recv != "" {
+		var t Type = Any
+		if s, ok := c.structs[f.RecvType]; ok {
+			t = s
+		}
+		c.declare(f.Recv, t)
+	}
+	for i, arg := range f.Args {
+		t := sig.Params[i]
+		if sig.Variadic && i == len(f.Args)-1 {
+			t = List
+		}
+		c.declare(arg, t)
+	}
+	c.stmts(f.Body)
+	if sig.Results != nil && !terminates(f.Body) {
+		c.errorf(f, "missing return at the end of %s", funcName(f))
+	}
+	c.close()
+	c.sig = outer
+}
+
+func funcName(f *ir.Func) string {
+	if f.FuncName == "" {
+		return "func literal"
+	}
+	return f.FuncName
+}
+
+func (c *checker) stmts(stmts []ir.Node) {
+	for _, stmt := range stmts {
+		c.stmt(stmt)
+	}
+}
+
+// scoped checks node in a scope of its own.
+func (c *checker) scoped(node ir.Node) {
+	c.open()
+	c.stmt(node)
+	c.close()
+}
+
+func (c *checker) stmt(node ir.Node) {
+	switch node := node.(type) {
+	case nil:
+	case *ir.VarDecl:
+		c.varDecl(node)
+	case *ir.AssignStmt:
+		c.assign(node)
+	case *ir.ReturnStmt:
+		c.ret(node)
+	case *ir.BreakStmt, *ir.ContinueStmt:
+	case *ir.BlockStmt:
+		c.stmts(node.Stmts)
+	case *ir.IfStmt:
+		c.cond(node.Cond)
+		c.scoped(node.Body)
+		c.scoped(node.Else)
+	case *ir.ForStmt:
+		c.open()
+		c.stmt(node.Init)
+		if node.Cond != nil {
+			c.cond(node.Cond)
+		}
+		c.stmt(node.Body)
+		c.stmt(node.Post)
+		c.close()
+	case *ir.WhileStmt:
+		c.cond(node.Cond)
+		c.scoped(node.Body)
+	case *ir.LoopStmt:
+		c.scoped(node.Body)
+	case *ir.RangeStmt:
+		c.rangeStmt(node)
+	case *ir.SwitchStmt:
+		c.switchStmt(node)
+	case *ir.CallExpr:
+		// a call statement may have any number of results.
+		c.call(node)
+	default:
+		c.expr(node)
+	}
+}
+
+// varDecl checks a declaration and declares its names: of the type
+// it gives them, of the types of the values of a constant or a
+// nested func, and any for the other vars, which may be set to any
+// value later.
+func (c *checker) varDecl(node *ir.VarDecl) {
+	types, at := c.values(node, node.Rhs, len(node.Lhs))
+	t := c.typeOf(node.Pos(), node.Type)
+	for i, name := range node.Lhs {
+		if !Assignable(types[i], t) {
+			c.errorf(at[i], "cannot use %v as %v value in variable declaration", types[i], t)
+		}
+		lit, ok := at[i].(*ir.FuncLit)
+		switch {
+		case node.Type != "":
+			c.declare(name, t)
+		case node.Const, ok && lit.Func.FuncName == name:
+			c.declare(name, types[i])
+		default:
+			c.declare(name, Any)
+		}
+	}
+}
+
+func (c *checker) assign(node *ir.AssignStmt) {
+	if node.Op != 0 {
+		t := c.target(node.Lhs[0])
+		v := c.binary(node, node.Op, t, c.expr(node.Rhs[0]))
+		if !Assignable(v, t) {
+			c.errorf(node, "cannot use %v as %v value in assignment", v, t)
+		}
+		return
+	}
+	types, at := c.values(node, node.Rhs, len(node.Lhs))
+	for i, lhs := range node.Lhs {
+		if t := c.target(lhs); !Assignable(types[i], t) {
+			c.errorf(at[i], "cannot use %v as %v value in assignment", types[i], t)
+		}
+	}
+}
+
+// target checks what is assigned to by target and returns the type
+// of the values it may hold.
+func (c *checker) target(target ir.Node) Type {
+	switch target := target.(type) {
+	case *ir.Name:
+		return c.lookup(target.Name)
+	case *ir.IndexExpr:
+		c.indexExpr(target)
+	case *ir.SelectorExpr:
+		switch x := c.expr(target.X).(type) {
+		case *Struct:
+			if !x.field(target.Sel) {
+				c.errorf(target, "%s has no field %s", x.Name, target.Sel)
+			}
+		default:
+			if x != Any {
+				c.errorf(target, "%v has no field %s", x, target.Sel)
+			}
+		}
+	}
+	return Any
+}
+
+func (c *checker) ret(node *ir.ReturnStmt) {
+	types, at, known := c.spread(node.Returns)
+	if c.sig == nil || c.sig.Results == nil {
+		return
+	}
+	want := c.sig.Results
+	if known && len(types) != len(want) {
+		c.errorf(node, "wrong number of return values: have %d, want %d", len(types), len(want))
+		return
+	}
+	for i, t := range types {
+		if i < len(want) && !Assignable(t, want[i]) {
+			c.errorf(at[i], "cannot use %v as %v value in return statement", t, want[i])
+		}
+	}
+}
+
+func (c *checker) cond(node ir.Node) {
+	if t := c.expr(node); t != Bool && t != Any {
+		c.errorf(node, "non-bool condition (%v)", t)
+	}
+}
+
+func (c *checker) rangeStmt(node *ir.RangeStmt) {
+	var key, value Type = Any, Any
+	switch x := c.expr(node.X); x {
+	case Num:
+		if node.Key != "" {
+			c.errorf(node.X, "range over num permits only one iteration variable")
+		}
+		value = Num
+	case String:
+		key, value = Num, String
+	case List:
+		key = Num
+	case Map, Any:
+	default:
+		c.errorf(node.X, "cannot range over %v", x)
+	}
+	c.open()
+	if node.Key != "" {
+		c.declare(node.Key, key)
+	}
+	c.declare(node.Value, value)
+	c.stmt(node.Body)
+	c.close()
+}
+
+func (c *checker) switchStmt(node *ir.SwitchStmt) {
+	if node.Tag != nil {
+		c.expr(node.Tag)
+	}
+	for _, clause := range node.Cases {
+		c.open()
+		for _, value := range clause.Values {
+			switch value.(type) {
+			case *ir.ListPattern, *ir.MapPattern:
+				c.pattern(value)
+			default:
+				if node.Tag == nil {
+					c.cond(value)
+				} else {
+					c.expr(value)
+				}
+			}
+		}
+		c.stmts(clause.Body)
+		c.close()
+	}
+}
+
+// pattern checks a case pattern, declaring the names it binds.
+func (c *checker) pattern(node ir.Node) {
+	switch node := node.(type) {
+	case *ir.Name:
+		if node.Name != "_" {
+			c.declare(node.Name, Any)
+		}
+	case *ir.ListPattern:
+		for _, elem := range node.Elems {
+			c.pattern(elem)
+		}
+		if node.Rest != "" {
+			c.declare(node.Rest, List)
+		}
+	case *ir.MapPattern:
+		for _, key := range node.Keys {
+			c.expr(key)
+		}
+		for _, value := range node.Values {
+			c.pattern(value)
+		}
+	default:
+		c.expr(node)
+	}
+}
+
+// results returns the types of the values of node, which are only
+// known for a call when the results of the func it calls are.
+func (c *checker) results(node ir.Node) ([]Type, bool) {
+	if call, ok := node.(*ir.CallExpr); ok {
+		results := c.call(call)
+		return results, results != nil
+	}
+	return []Type{c.expr(node)}, true
+}
+
+// values returns the types of the n values exprs gives to the
+// variables of node, any for those not known, and the expressions
+// they are of.
+func (c *checker) values(node ir.Node, exprs []ir.Node, n int) ([]Type, []ir.Node) {
+	var types []Type
+	known := true
+	if len(exprs) == 1 {
+		types, known = c.results(exprs[0])
+	} else {
+		for _, expr := range exprs {
+			types = append(types, c.expr(expr))
+		}
+	}
+	if known && len(types) != n {
+		c.errorf(node, "assignment mismatch: %s but %s", plural(n, "variable"), plural(len(types), "value"))
+	}
+	at := make([]ir.Node, n)
+	for i := range at {
+		at[i] = exprs[0]
+		if len(exprs) == n {
+			at[i] = exprs[i]
+		}
+	}
+	if !known || len(types) != n {
+		types = make([]Type, n)
+		for i := range types {
+			types[i] = Any
+		}
+	}
+	return types, at
+}
+
+// spread returns the types of the values of exprs, where a call
+// gives all its results, as the args of a call and the values of a
+// return are, with the expressions they are of. When the results
+// of a call are not known, known is false and the types stop there.
+func (c *checker) spread(exprs []ir.Node) (types []Type, at []ir.Node, known bool) {
+	known = true
+	for _, expr := range exprs {
+		results, ok := c.results(expr)
+		if !known {
+			continue
+		}
+		known = ok
+		for _, t := range results {
+			types = append(types, t)
+			at = append(at, expr)
+		}
+	}
+	return types, at, known
+}
+
+// call checks a call and returns the types of its results, nil when
+// they are not known.
+func (c *checker) call(node *ir.CallExpr) []Type {
+	fun := c.expr(node.Fun)
+	args, at, known := c.spread(node.Args)
+	name := "func"
+	switch f := node.Fun.(type) {
+	case *ir.Name:
+		name = f.Name
+	case *ir.SelectorExpr:
+		name = f.Sel
+	}
+	sig, ok := fun.(*Signature)
+	if !ok {
+		if fun != Any && fun != Func {
+			c.errorf(node, "call of non-function %s (%v)", name, fun)
+		}
+		return nil
+	}
+	fixed := len(sig.Params)
+	if sig.Variadic {
+		fixed--
+		if known && len(args) < fixed {
+			c.errorf(node, "call %s: want at least %d args, got %d", name, fixed, len(args))
+		}
+	} else if known && len(args) != fixed {
+		c.errorf(node, "call %s: want %d args, got %d", name, fixed, len(args))
+	}
+	for i, t := range args {
+		var param Type
+		switch {
+		case i < fixed:
+			param = sig.Params[i]
+		case sig.Variadic:
+			param = sig.Params[fixed]
+		default:
+			return sig.Results
+		}
+		if !Assignable(t, param) {
+			c.errorf(at[i], "cannot use %v as %v value in argument to %s", t, param, name)
+		}
+	}
+	return sig.Results
+}
+
+// expr checks node, an expression with one value, and returns the
+// type of it.
+func (c *checker) expr(node ir.Node) Type {
+	switch node := node.(type) {
+	case *ir.Name:
+		return c.lookup(node.Name)
+	case *ir.Literal:
+		switch node.Type {
+		case syntax.TNUM:
+			return Num
+		case syntax.TSTRING:
+			return String
+		case syntax.TBOOL:
+			return Bool
+		}
+		return Nil
+	case *ir.FuncLit:
+		sig := c.signature(node.Func)
+		c.closures = append(c.closures, closure{f: node.Func, sig: sig, scope: c.scope})
+		return sig
+	case *ir.CallExpr:
+		results := c.call(node)
+		if results == nil {
+			return Any
+		}
+		if len(results) != 1 {
+			c.errorf(node, "expression has %d values, want 1", len(results))
+			return Any
+		}
+		return results[0]
+	case *ir.UnaryExpr:
+		x := c.expr(node.X)
+		want := Num
+		if node.Op == syntax.OpNOT {
+			want = Bool
+		}
+		if x != want && x != Any {
+			c.errorf(node, "invalid operation: operator %v not defined on %v", node.Op, x)
+		}
+		return want
+	case *ir.BinaryExpr:
+		if node.Op == syntax.OpAND || node.Op == syntax.OpOR {
+			for _, operand := range []ir.Node{node.Lhs, node.Rhs} {
+				if t := c.expr(operand); t != Bool && t != Any {
+					c.errorf(operand, "invalid operation: operator %v not defined on %v", node.Op, t)
+				}
+			}
+			return Bool
+		}
+		return c.binary(node, node.Op, c.expr(node.Lhs), c.expr(node.Rhs))
+	case *ir.ListLit:
+		for _, elem := range node.Elems {
+			c.expr(elem)
+		}
+		return List
+	case *ir.MapLit:
+		for i := range node.Keys {
+			c.expr(node.Keys[i])
+			c.expr(node.Values[i])
+		}
+		return Map
+	case *ir.StructLit:
+		return c.structLit(node)
+	case *ir.SelectorExpr:
+		switch x := c.expr(node.X).(type) {
+		case *Struct:
+			if x.field(node.Sel) {
+				return Any
+			}
+			if m, ok := x.Methods[node.Sel]; ok {
+				return m
+			}
+			c.errorf(node, "%s has no field or method %s", x.Name, node.Sel)
+		default:
+			if x != Any {
+				c.errorf(node, "%v has no field or method %s", x, node.Sel)
+			}
+		}
+		return Any
+	case *ir.IndexExpr:
+		c.indexExpr(node)
+		return Any
+	case *ir.SliceExpr:
+		if x := c.expr(node.X); x != List && x != Any {
+			c.errorf(node.X, "cannot slice %v", x)
+		}
+		for _, index := range []ir.Node{node.Lo, node.Hi} {
+			if index != nil {
+				c.index(index)
+			}
+		}
+		return List
+	}
+	panic(fmt.Sprintf("unknown node %T", node))
+}
+
+func (c *checker) indexExpr(node *ir.IndexExpr) {
+	switch x := c.expr(node.X); x {
+	case List:
+		c.index(node.Index)
+	case Map, Any:
+		c.expr(node.Index)
+	default:
+		c.errorf(node.X, "cannot index %v", x)
+		c.expr(node.Index)
+	}
+}
+
+// index checks node, an index into a list.
+func (c *checker) index(node ir.Node) {
+	if t := c.expr(node); t != Num && t != Any {
+		c.errorf(node, "invalid index type %v", t)
+	}
+}
+
+func (c *checker) structLit(node *ir.StructLit) Type {
+	t := c.expr(node.Type)
+	for _, value := range node.Values {
+		c.expr(value)
+	}
+	tn, ok := t.(typeName)
+	if !ok {
+		if t != Any {
+			c.errorf(node.Type, "%v is not a type", t)
+		}
+		return Any
+	}
+	s := tn.s
+	if node.Fields == nil && len(node.Values) > 0 && len(node.Values) != len(s.Fields) {
+		c.errorf(node, "%s has %d fields, got %d values", s.Name, len(s.Fields), len(node.Values))
+	}
+	for i, field := range node.Fields {
+		if !s.field(field) {
+			c.errorf(node.Values[i], "unknown field %s in %s", field, s.Name)
+		}
+	}
+	return s
+}
+
+// binary returns the type of l op r, reporting the operands op is
+// not defined on, as GetBinaryOpResult of package eval would.
+func (c *checker) binary(node ir.Node, op syntax.Op, l, r Type) Type {
+	var result Type
+	valid := func(t Type) bool { return t == Num }
+	switch op {
+	case syntax.OpEQ, syntax.OpNEQ:
+		return Bool
+	case syntax.OpPLUS:
+		valid = func(t Type) bool { return t == Num || t == String }
+		result = l
+		if l == Any || !valid(l) {
+			result = r
+		}
+	case syntax.OpLT, syntax.OpLEQ, syntax.OpGT, syntax.OpGEQ:
+		valid = func(t Type) bool { return t == Num || t == String }
+		result = Bool
+	default:
+		result = Num
+	}
+	if !valid(result) && result != Bool {
+		result = Any
+	}
+	for _, t := range []Type{l, r} {
+		if t != Any && !valid(t) {
+			c.errorf(node, "invalid operation: operator %v not defined on %v", op, t)
+			return result
+		}
+	}
+	if l != Any && r != Any && l != r {
+		c.errorf(node, "invalid operation: %v %v %v (mismatched types)", l, op, r)
+	}
+	return result
+}
+
+// terminates reports whether running stmts always ends in a return,
+// so a func with results cannot run off its end.
+func terminates(stmts []ir.Node) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	switch s := stmts[len(stmts)-1].(type) {
+	case *ir.ReturnStmt:
+		return true
+	case *ir.BlockStmt:
+		return terminates(s.Stmts)
+	case *ir.IfStmt:
+		return s.Else != nil && terminates([]ir.Node{s.Body}) && terminates([]ir.Node{s.Else})
+	case *ir.LoopStmt:
+		return !breaks(s.Body, s.Label, false)
+	case *ir.ForStmt:
+		return s.Cond == nil && !breaks(s.Body, s.Label, false)
+	case *ir.SwitchStmt:
+		hasDefault := false
+		for _, clause := range s.Cases {
+			if clause.Values == nil {
+				hasDefault = true
+			}
+			if !clause.Fallthrough && !terminates(clause.Body) {
+				return false
+			}
+			for _, stmt := range clause.Body {
+				if breaks(stmt, s.Label, false) {
+					return false
+				}
+			}
+		}
+		return hasDefault
+	}
+	return false
+}
+
+// breaks reports whether node has a break out of the loop or switch
+// labelled label it is in. nested is set inside another one, which
+// a break without a label leaves instead.
+func breaks(node ir.Node, label string, nested bool) bool {
+	switch node := node.(type) {
+	case *ir.BreakStmt:
+		if node.Label == "" {
+			return !nested
+		}
+		return node.Label == label
+	case *ir.BlockStmt:
+		for _, stmt := range node.Stmts {
+			if breaks(stmt, label, nested) {
+				return true
+			}
+		}
+	case *ir.IfStmt:
+		return breaks(node.Body, label, nested) || breaks(node.Else, label, nested)
+	case *ir.ForStmt:
+		return breaks(node.Body, label, true)
+	case *ir.WhileStmt:
+		return breaks(node.Body, label, true)
+	case *ir.LoopStmt:
+		return breaks(node.Body, label, true)
+	case *ir.RangeStmt:
+		return breaks(node.Body, label, true)
+	case *ir.SwitchStmt:
+		for _, clause := range node.Cases {
+			for _, stmt := range clause.Body {
+				if breaks(stmt, label, true) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// plural returns n and noun, in the plural unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
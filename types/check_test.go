@@ -0,0 +1,87 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cuiweixie/toylang/ir"
+	"github.com/cuiweixie/toylang/syntax"
+)
+
+// check checks src, with print and len predeclared, and returns its
+// errors.
+func check(t *testing.T, src string) []string {
+	file, err := syntax.Parse("x.toy", []byte(src))
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	nodes := ir.GenAst(file)
+	if _, err := ir.Resolve(nodes, []string{"print", "len"}); err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	var errs []string
+	if err := Check(nodes); err != nil {
+		for _, e := range err.(syntax.ErrorList) {
+			errs = append(errs, e.Error())
+		}
+	}
+	return errs
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		src  string
+		errs []string
+	}{
+		// well typed programs.
+		{"func add(a num, b num) num { return a + b }\nvar x num = 1 + add(1, 2)", nil},
+		{"func join(sep string, parts... string) string { var s string = sep; for _, p in parts { s += p }; return s }", nil},
+		{"func divmod(a num, b num) (num, num) { return a / b, a % b }\nfunc f() { var q, r num = divmod(7, 2) }", nil},
+		{"type P struct { x }\nfunc (p P) get() num { return p.x }\nfunc f(p P,) num { return p.get() }", nil},
+		{"func twice(g func, x num) num { return g(g(x)) }\nvar y = twice(func(n num,) num { return n }, 1)", nil},
+		{"func f(x any,) { print(x + 1, x[0], x.y) }", nil},
+		// names after names are more names, not types.
+		{"func add(x Y) { return x + Y }\nfunc f(x list) { return len(list) }", nil},
+		{"func f() { var a B = 1, 2; print(a + B) }", nil},
+		{"func divmod(a, b) { return a / b, a % b }\nfunc f() { var q num = divmod(7, 2); print(q + num) }", nil},
+		// diagnostics.
+		{"func f(a num, b Nope) {}", []string{
+			"x.toy:1:15: undefined type Nope",
+		}},
+		{"func f() Nope { return 1 }\nvar v Unknown = 1", []string{
+			"x.toy:1:1: undefined type Nope",
+			"x.toy:2:1: undefined type Unknown",
+		}},
+		{"var s string = 1", []string{
+			"x.toy:1:16: cannot use num as string value in variable declaration",
+		}},
+		{"func f(n num,) num { if n > 0 { return 1 } }", []string{
+			"x.toy:1:1: missing return at the end of f",
+		}},
+		{"func f(s string,) num { return s }", []string{
+			"x.toy:1:32: cannot use string as num value in return statement",
+		}},
+		{"func add(a num, b num) num { return a + b }\nfunc f() { add(1); add(1, \"b\") }", []string{
+			"x.toy:2:12: call add: want 2 args, got 1",
+			"x.toy:2:27: cannot use string as num value in argument to add",
+		}},
+		{"func f() { print(1 - \"a\", !1); if 1 {} }", []string{
+			"x.toy:1:18: invalid operation: operator - not defined on string",
+			"x.toy:1:27: invalid operation: operator ! not defined on num",
+			"x.toy:1:35: non-bool condition (num)",
+		}},
+		{"func f() { var y num = 1; y = \"z\" }", []string{
+			"x.toy:1:31: cannot use string as num value in assignment",
+		}},
+		{"type P struct { x y }\nfunc f() { var p P = P{1}; print(p.z, P{z: 1}) }", []string{
+			"x.toy:2:22: P has 2 fields, got 1 values",
+			"x.toy:2:34: P has no field or method z",
+			"x.toy:2:44: unknown field z in P",
+		}},
+	}
+	for _, test := range tests {
+		if errs := check(t, test.src); !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q:\ngot  %q\nwant %q", test.src, errs, test.errs)
+		}
+	}
+}
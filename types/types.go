@@ -0,0 +1,146 @@
+// Package types checks the types of a program before it runs.
+//
+// Types are optional. A var, arg or result may be given one, as in
+// func add(a num, b num) num or var s string = "", and Check infers
+// the types of literals, operators, constants and calls of funcs
+// from them. A value whose type is not known is of type any, which
+// every operation accepts; what is reported are the operations that
+// fail whenever they run, whatever the values of type any turn out
+// to be, and the values that do not have the types given.
+package types
+
+import "strings"
+
+// Type is the static type of a value: a Basic, a *Signature, a
+// *Struct, or the type of a struct type itself.
+type Type interface {
+	String() string
+}
+
+//go:generate stringer -type Basic -linecomment types.go
+type Basic int
+
+const (
+	_      Basic = iota
+	Any          // any
+	Bool         // bool
+	Num          // num
+	String       // string
+	Nil          // nil
+	List         // list
+	Map          // map
+	// Func is a func whose signature is not known.
+	Func // func
+)
+
+// Signature is the type of a func: the types of its args and of its
+// results, which are nil when they are not given. The last param of
+// a variadic func is the type of the args it collects.
+type Signature struct {
+	Params   []Type
+	Variadic bool
+	Results  []Type
+}
+
+func (s *Signature) String() string {
+	params := make([]string, len(s.Params))
+	for i, t := range s.Params {
+		params[i] = t.String()
+	}
+	if s.Variadic {
+		params[len(params)-1] += "..."
+	}
+	str := "func(" + strings.Join(params, ", ") + ")"
+	switch len(s.Results) {
+	case 0:
+	case 1:
+		str += " " + s.Results[0].String()
+	default:
+		results := make([]string, len(s.Results))
+		for i, t := range s.Results {
+			results[i] = t.String()
+		}
+		str += " (" + strings.Join(results, ", ") + ")"
+	}
+	return str
+}
+
+// Struct is a struct type declared by the program. Methods have
+// no receiver in their params.
+type Struct struct {
+	Name    string
+	Fields  []string
+	Methods map[string]*Signature
+}
+
+func (s *Struct) String() string {
+	return s.Name
+}
+
+func (s *Struct) field(name string) bool {
+	for _, f := range s.Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// typeName is the type of the name of a struct type, which makes
+// structs in a struct literal.
+type typeName struct {
+	s *Struct
+}
+
+func (typeName) String() string {
+	return "type"
+}
+
+// basic returns the Basic t is one of, Func for a signature and
+// zero for any other type.
+func basic(t Type) Basic {
+	switch t := t.(type) {
+	case Basic:
+		return t
+	case *Signature:
+		return Func
+	}
+	return 0
+}
+
+// Assignable reports whether a value of type v can be used where
+// one of type t is wanted.
+func Assignable(v, t Type) bool {
+	if v == Any || t == Any || v == t {
+		return true
+	}
+	if t == Func || v == Func {
+		return basic(v) == Func && basic(t) == Func
+	}
+	vs, ok := v.(*Signature)
+	ts, ok2 := t.(*Signature)
+	if !ok || !ok2 {
+		return false
+	}
+	if len(vs.Params) != len(ts.Params) || vs.Variadic != ts.Variadic {
+		return false
+	}
+	// v is called with the args a func of type t would be.
+	for i := range vs.Params {
+		if !Assignable(ts.Params[i], vs.Params[i]) {
+			return false
+		}
+	}
+	if vs.Results == nil || ts.Results == nil {
+		return true
+	}
+	if len(vs.Results) != len(ts.Results) {
+		return false
+	}
+	for i := range vs.Results {
+		if !Assignable(vs.Results[i], ts.Results[i]) {
+			return false
+		}
+	}
+	return true
+}